@@ -0,0 +1,143 @@
+// Copyright 2014-2017 Ulrich Kunitz. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package xz
+
+import (
+	"errors"
+	"fmt"
+	"io"
+
+	"github.com/ulikunitz/xz/internal/bcj"
+)
+
+// Filter IDs of the branch/call/jump converters. They improve the
+// compression of executable code for the given architecture.
+const (
+	FilterX86      uint64 = 0x04
+	FilterPowerPC  uint64 = 0x05
+	FilterIA64     uint64 = 0x06
+	FilterARM      uint64 = 0x07
+	FilterARMThumb uint64 = 0x08
+	FilterSPARC    uint64 = 0x09
+	FilterARM64    uint64 = 0x0a
+	FilterRISCV    uint64 = 0x0b
+)
+
+// bcjArch describes the converter for a BCJ filter ID.
+type bcjArch struct {
+	name      string
+	alignment uint32
+	new       func(encoder bool) bcj.Converter
+}
+
+// bcjArchs maps the BCJ filter IDs to the converters.
+var bcjArchs = map[uint64]bcjArch{
+	FilterX86:      {"x86", 1, bcj.NewX86},
+	FilterPowerPC:  {"PowerPC", 4, bcj.NewPowerPC},
+	FilterIA64:     {"IA-64", 16, bcj.NewIA64},
+	FilterARM:      {"ARM", 4, bcj.NewARM},
+	FilterARMThumb: {"ARM-Thumb", 2, bcj.NewARMThumb},
+	FilterSPARC:    {"SPARC", 4, bcj.NewSPARC},
+	FilterARM64:    {"ARM64", 4, bcj.NewARM64},
+	FilterRISCV:    {"RISC-V", 2, bcj.NewRISCV},
+}
+
+// isBCJFilter checks whether the filter ID belongs to a BCJ filter.
+func isBCJFilter(id uint64) bool {
+	_, ok := bcjArchs[id]
+	return ok
+}
+
+// bcjFilter declares a branch/call/jump converter filter stored in an
+// xz block header.
+type bcjFilter struct {
+	filterID uint64
+	// start offset for the position of the first byte
+	start uint32
+}
+
+// String returns a representation of the BCJ filter.
+func (f bcjFilter) String() string {
+	s := fmt.Sprintf("BCJ %s", bcjArchs[f.filterID].name)
+	if f.start != 0 {
+		s += fmt.Sprintf(" start offset %#x", f.start)
+	}
+	return s
+}
+
+// id returns the ID for the BCJ filter.
+func (f bcjFilter) id() uint64 { return f.filterID }
+
+// MarshalBinary converts the bcjFilter in its encoded representation.
+func (f bcjFilter) MarshalBinary() (data []byte, err error) {
+	if !isBCJFilter(f.filterID) {
+		return nil, errors.New("xz: invalid BCJ filter id")
+	}
+	if f.start == 0 {
+		return []byte{byte(f.filterID), 0}, nil
+	}
+	data = []byte{byte(f.filterID), 4, 0, 0, 0, 0}
+	putUint32LE(data[2:], f.start)
+	return data, nil
+}
+
+// UnmarshalBinary unmarshals the given data representation of the BCJ
+// filter.
+func (f *bcjFilter) UnmarshalBinary(data []byte) error {
+	if len(data) < 2 {
+		return errors.New("xz: data for BCJ filter has wrong length")
+	}
+	id := uint64(data[0])
+	if !isBCJFilter(id) {
+		return errors.New("xz: wrong BCJ filter id")
+	}
+	var start uint32
+	switch data[1] {
+	case 0:
+		if len(data) != 2 {
+			return errors.New(
+				"xz: data for BCJ filter has wrong length")
+		}
+	case 4:
+		if len(data) != 6 {
+			return errors.New(
+				"xz: data for BCJ filter has wrong length")
+		}
+		start = uint32LE(data[2:])
+	default:
+		return errors.New("xz: wrong BCJ filter size")
+	}
+
+	f.filterID, f.start = id, start
+	return nil
+}
+
+// reader creates a new reader for the BCJ filter.
+func (f bcjFilter) reader(r io.Reader, c *ReaderConfig) (fr io.Reader,
+	err error) {
+
+	a, ok := bcjArchs[f.filterID]
+	if !ok {
+		return nil, errors.New("xz: invalid BCJ filter id")
+	}
+	return bcj.NewReader(r, a.new(false), f.start), nil
+}
+
+// writeCloser creates a io.WriteCloser for the BCJ filter.
+func (f bcjFilter) writeCloser(w io.WriteCloser, c *WriterConfig,
+) (fw io.WriteCloser, err error) {
+	a, ok := bcjArchs[f.filterID]
+	if !ok {
+		return nil, errors.New("xz: invalid BCJ filter id")
+	}
+	if f.start%a.alignment != 0 {
+		return nil, errors.New("xz: BCJ start offset not aligned")
+	}
+	return bcj.NewWriter(w, a.new(true), f.start), nil
+}
+
+// last returns false, because a BCJ filter must be followed by another
+// filter.
+func (f bcjFilter) last() bool { return false }
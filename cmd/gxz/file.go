@@ -9,6 +9,7 @@ import (
 	"errors"
 	"fmt"
 	"io"
+	"io/ioutil"
 	"os"
 	"os/signal"
 	"path/filepath"
@@ -17,6 +18,7 @@ import (
 
 	"github.com/ulikunitz/xz"
 	"github.com/ulikunitz/xz/internal/xlog"
+	"github.com/ulikunitz/xz/lzip"
 	"github.com/ulikunitz/xz/lzma"
 )
 
@@ -47,29 +49,39 @@ type format struct {
 		err error)
 	newDecompressor func(r io.Reader, opts *options) (d io.Reader,
 		err error)
+	// validHeader is nil if the format cannot be detected
 	validHeader func(br *bufio.Reader) bool
 }
 
-// dictCapExps maps preset values to exponent for dictionary capacity
-// sizes.
-var lzmaDictCapExps = []uint{18, 20, 21, 22, 22, 23, 23, 24, 25, 26}
+// presetDictCap returns the dictionary capacity of the preset selected
+// by the options.
+func presetDictCap(opts *options) int {
+	c, err := lzma.WriterConfigForPreset(opts.preset, opts.extreme)
+	if err != nil {
+		xlog.Panicf("preset %d: %s", opts.preset, err)
+	}
+	return c.DictCap
+}
 
 // formats contains the formats supported by gxz.
 var formats = map[string]*format{
 	"lzma": &format{
 		newCompressor: func(w io.Writer, opts *options,
 		) (c io.WriteCloser, err error) {
-			lc := lzma.WriterConfig{
-				Properties: &lzma.Properties{LC: 3, LP: 0,
-					PB: 2},
-				DictCap: 1 << lzmaDictCapExps[opts.preset],
+			if opts.rawFilter.name == "lzma1" {
+				return opts.lzma.writerConfig().NewWriter(w)
+			}
+			lc, err := lzma.WriterConfigForPreset(opts.preset,
+				opts.extreme)
+			if err != nil {
+				return nil, err
 			}
 			return lc.NewWriter(w)
 		},
 		newDecompressor: func(r io.Reader, opts *options,
 		) (d io.Reader, err error) {
 			lc := lzma.ReaderConfig{
-				DictCap: 1 << lzmaDictCapExps[opts.preset],
+				DictCap: presetDictCap(opts),
 			}
 			return lc.NewReader(r)
 		},
@@ -81,18 +93,74 @@ var formats = map[string]*format{
 			return lzma.ValidHeader(h)
 		},
 	},
+	"lzip": &format{
+		newCompressor: func(w io.Writer, opts *options,
+		) (c io.WriteCloser, err error) {
+			cfg, err := lzip.WriterConfigForPreset(opts.preset,
+				opts.extreme)
+			if err != nil {
+				return nil, err
+			}
+			return cfg.NewWriter(w)
+		},
+		newDecompressor: func(r io.Reader, opts *options,
+		) (d io.Reader, err error) {
+			return lzip.NewReader(r)
+		},
+		validHeader: func(br *bufio.Reader) bool {
+			h, err := br.Peek(lzip.HeaderLen)
+			if err != nil {
+				return false
+			}
+			return lzip.ValidHeader(h)
+		},
+	},
+	"raw": &format{
+		newCompressor: func(w io.Writer, opts *options,
+		) (c io.WriteCloser, err error) {
+			if opts.rawFilter.name == "lzma1" {
+				return opts.lzma.writerConfig().NewRawWriter(w)
+			}
+			return opts.lzma.writer2Config().NewWriter2(w)
+		},
+		newDecompressor: func(r io.Reader, opts *options,
+		) (d io.Reader, err error) {
+			if opts.rawFilter.name == "lzma1" {
+				lc := lzma.ReaderConfig{
+					DictCap:     opts.lzma.dictCap,
+					EOSOptional: true,
+				}
+				return lc.NewRawReader(r, opts.lzma.properties,
+					-1)
+			}
+			lc := lzma.Reader2Config{DictCap: opts.lzma.dictCap}
+			return lc.NewReader2(r)
+		},
+		// raw streams cannot be detected
+		validHeader: nil,
+	},
 	"xz": &format{
 		newCompressor: func(w io.Writer, opts *options,
 		) (c io.WriteCloser, err error) {
-			cfg := xz.WriterConfig{
-				DictCap: 1 << lzmaDictCapExps[opts.preset],
+			cfg, err := xz.WriterConfigForPreset(opts.preset,
+				opts.extreme)
+			if err != nil {
+				return nil, err
 			}
+			if opts.chain != nil {
+				opts.lzma.setXZ(&cfg)
+				cfg.Filters = opts.chain
+			}
+			cfg.BlockSize = opts.blockSize
+			cfg.Workers = opts.threads
 			return cfg.NewWriter(w)
 		},
 		newDecompressor: func(r io.Reader, opts *options,
 		) (d io.Reader, err error) {
 			cfg := xz.ReaderConfig{
-				DictCap: 1 << lzmaDictCapExps[opts.preset],
+				DictCap:          presetDictCap(opts),
+				Workers:          opts.threads,
+				SkipUnknownCheck: true,
 			}
 			return cfg.NewReader(r)
 		},
@@ -119,15 +187,21 @@ func targetName(path string, opts *options) (target string, err error) {
 	}
 	ext := "." + opts.format
 	tarExt := ".txz"
-	if opts.format == "lzma" {
+	switch opts.format {
+	case "lzma":
 		tarExt = ".tlz"
+	case "lzip":
+		// lzip uses .tar.lz for tar files
+		ext, tarExt = ".lz", ""
+	case "raw":
+		tarExt = ""
 	}
 	if !opts.decompress {
 		if strings.HasSuffix(path, ext) {
 			return "", fmt.Errorf(
 				"%s: file has already %s suffix", path, ext)
 		}
-		if strings.HasSuffix(path, tarExt) {
+		if tarExt != "" && strings.HasSuffix(path, tarExt) {
 			return "", fmt.Errorf(
 				"%s: file has already %s suffix", path, tarExt)
 		}
@@ -140,7 +214,7 @@ func targetName(path string, opts *options) (target string, err error) {
 		}
 		return target, nil
 	}
-	if strings.HasSuffix(path, tarExt) {
+	if tarExt != "" && strings.HasSuffix(path, tarExt) {
 		target = path[:len(path)-len(tarExt)]
 		if filepath.Base(target) == "" {
 			return "", &userPathError{path, errBase}
@@ -302,6 +376,8 @@ type reader struct {
 	io.Reader
 	success bool
 	keep    bool
+	// reports unverified checks of xz files
+	cw *checkWarner
 }
 
 // errNoRegular indicates that a file is not regular.
@@ -345,14 +421,13 @@ func openFile(path string, opts *options) (f *os.File, err error) {
 
 var errInvalidFormat = errors.New("file format not recognized")
 
-// readerFormat tries to determine the type of a file. Currently it
-// checks for the XZ header magic and if it is not present assumes that
-// the file has been encoded by LZMA. The format field in options is
-// updated.
+// readerFormat tries to determine the type of a file. It checks the
+// header of the file against the headers of the xz, lzip and LZMA
+// formats. The format field in options is updated.
 func readerFormat(br *bufio.Reader, opts *options) (f *format, err error) {
 	var ok bool
 	if f, ok = formats[opts.format]; ok {
-		if !f.validHeader(br) {
+		if f.validHeader != nil && !f.validHeader(br) {
 			return nil, errInvalidFormat
 		}
 		return f, nil
@@ -362,7 +437,7 @@ func readerFormat(br *bufio.Reader, opts *options) (f *format, err error) {
 			opts.format)
 	}
 	for format, f := range formats {
-		if f.validHeader(br) {
+		if f.validHeader != nil && f.validHeader(br) {
 			opts.format = format
 			return f, nil
 		}
@@ -370,22 +445,118 @@ func readerFormat(br *bufio.Reader, opts *options) (f *format, err error) {
 	return nil, errInvalidFormat
 }
 
+// seekReader is a buffered file reader that supports Seek. The
+// parallel xz decoder uses it to read the indexes at the end of the
+// file.
+type seekReader struct {
+	*bufio.Reader
+	f *os.File
+}
+
+// Seek sets the offset for the next Read and discards the buffer.
+func (r *seekReader) Seek(offset int64, whence int) (int64, error) {
+	if whence == io.SeekCurrent {
+		offset -= int64(r.Buffered())
+	}
+	n, err := r.f.Seek(offset, whence)
+	if err != nil {
+		return n, err
+	}
+	r.Reset(r.f)
+	return n, nil
+}
+
 // newDecompressor creates a new decompressor.
-func newDecompressor(br *bufio.Reader, opts *options) (dec io.Reader,
+func newDecompressor(sr *seekReader, opts *options) (dec io.Reader,
 	err error) {
 	if !opts.decompress {
 		panic("no decompressor needed")
 	}
-	f, err := readerFormat(br, opts)
+	f, err := readerFormat(sr.Reader, opts)
 	if err != nil {
 		return nil, err
 	}
-	if dec, err = f.newDecompressor(br, opts); err != nil {
+	if dec, err = f.newDecompressor(sr, opts); err != nil {
 		return nil, err
 	}
 	return dec, nil
 }
 
+// warning is a problem that doesn't stop the processing of a file. The
+// warning has already been printed. The exit status of gxz is 2 if
+// only warnings have been reported.
+type warning struct {
+	error
+}
+
+// isWarning checks whether err is a warning.
+func isWarning(err error) bool {
+	_, ok := err.(*warning)
+	return ok
+}
+
+// checkWarner prints the warning of the xz tool once if an xz stream
+// uses a check type that cannot be verified. The warning is recorded
+// for the exit status.
+type checkWarner struct {
+	*xz.Reader
+	path    string
+	warning *warning
+}
+
+// Read reads decompressed data and checks for skipped checks.
+func (r *checkWarner) Read(p []byte) (n int, err error) {
+	n, err = r.Reader.Read(p)
+	if r.warning == nil {
+		if _, ok := r.SkippedCheck(); ok {
+			r.warning = &warning{&userPathError{r.path,
+				errors.New("unsupported type of integrity " +
+					"check; not verifying file integrity")}}
+			printErr(r.warning)
+		}
+	}
+	return n, err
+}
+
+// Errors reported for damaged input files.
+var (
+	errCorrupt       = errors.New("compressed data is corrupt")
+	errUnexpectedEnd = errors.New("unexpected end of input")
+)
+
+// decodeError converts the errors of the decompressors into the
+// messages of the xz tool. Errors that are not caused by damaged input
+// are returned unchanged.
+func decodeError(err error) error {
+	switch err.(type) {
+	case *os.PathError, *lzma.MemLimitError:
+		return err
+	}
+	switch err {
+	case io.ErrUnexpectedEOF:
+		return errUnexpectedEnd
+	case xz.ErrUnsupportedCheck:
+		return err
+	}
+	return errCorrupt
+}
+
+// decodeReader reports the errors of a decompressor with the path of
+// the file.
+type decodeReader struct {
+	io.Reader
+	path string
+}
+
+// Read reads decompressed data.
+func (r *decodeReader) Read(p []byte) (n int, err error) {
+	n, err = r.Reader.Read(p)
+	if err != nil && err != io.EOF {
+		err = &userPathError{r.path, decodeError(err)}
+	}
+	return n, err
+}
+
 // newReader creates a new reader for files.
 func newReader(path string, opts *options) (r *reader, err error) {
 	f, err := openFile(path, opts)
@@ -397,14 +568,27 @@ func newReader(path string, opts *options) (r *reader, err error) {
 		r = &reader{f: f, Reader: br, keep: opts.keep || opts.stdout}
 		return r, nil
 	}
-	dec, err := newDecompressor(br, opts)
+	dec, err := newDecompressor(&seekReader{br, f}, opts)
 	if err != nil {
 		return nil, &userPathError{path, err}
 	}
-	r = &reader{f: f, Reader: dec, keep: opts.keep || opts.stdout}
+	r = &reader{f: f, keep: opts.keep || opts.stdout}
+	if xr, ok := dec.(*xz.Reader); ok {
+		r.cw = &checkWarner{Reader: xr, path: path}
+		dec = r.cw
+	}
+	r.Reader = &decodeReader{Reader: dec, path: path}
 	return r, nil
 }
 
+// warning returns the warning recorded while reading the file or nil.
+func (r *reader) warning() error {
+	if r.cw == nil || r.cw.warning == nil {
+		return nil
+	}
+	return r.cw.warning
+}
+
 // isStdin checks whether the given file reference is stdin.
 func isStdin(f *os.File) bool {
 	return f.Fd() == uintptr(syscall.Stdin)
@@ -510,5 +694,24 @@ func processFile(path string, opts *options) (err error) {
 		printErr(err)
 		return err
 	}
-	return nil
+	return r.warning()
+}
+
+// testFile decompresses the file with the given path and discards the
+// output. The decompression verifies the checks of the blocks, the
+// index and the footer of xz files. The input file is never removed.
+// A warning is returned if the checks of the file couldn't be
+// verified.
+func testFile(path string, opts *options) (err error) {
+	r, err := newReader(path, opts)
+	if err != nil {
+		printErr(err)
+		return err
+	}
+	defer r.Close()
+	if _, err = io.Copy(ioutil.Discard, r); err != nil {
+		printErr(err)
+		return err
+	}
+	return r.warning()
 }
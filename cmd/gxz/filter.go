@@ -0,0 +1,460 @@
+// Copyright 2014-2017 Ulrich Kunitz. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/ulikunitz/xz"
+	"github.com/ulikunitz/xz/lzma"
+)
+
+// filterSpec records a filter flag like --lzma2=OPTS given on the
+// command line or a filter of the --filters flag.
+type filterSpec struct {
+	name string
+	arg  string
+}
+
+// filterValue implements the gflag.Value interface for the filter
+// flags. The filters are appended in the order of the command line.
+type filterValue struct {
+	name    string
+	filters *[]filterSpec
+}
+
+// Set checks the syntax of the filter options and appends the filter.
+// An error lets gflag treat the argument as file name.
+func (v *filterValue) Set(s string) error {
+	if _, err := splitFilterOptions(v.name, s); err != nil {
+		return err
+	}
+	*v.filters = append(*v.filters, filterSpec{v.name, s})
+	return nil
+}
+
+// Update appends the filter without options.
+func (v *filterValue) Update() {
+	*v.filters = append(*v.filters, filterSpec{name: v.name})
+}
+
+// Get returns the filters.
+func (v *filterValue) Get() interface{} { return *v.filters }
+
+// String returns an empty string since there is no default value.
+func (v *filterValue) String() string { return "" }
+
+// filtersValue implements the gflag.Value interface for the --filters
+// flag. The flag replaces the filters given before.
+type filtersValue struct {
+	filters *[]filterSpec
+}
+
+// Set parses a filter chain like "x86 lzma2:preset=9e,dict=64MiB". The
+// filters are separated by spaces or two dashes; the options follow a
+// colon or an equal sign.
+func (v *filtersValue) Set(s string) error {
+	fields := strings.Fields(strings.Replace(s, "--", " ", -1))
+	if len(fields) == 0 {
+		return errors.New("empty filter chain")
+	}
+	var filters []filterSpec
+	for _, f := range fields {
+		var spec filterSpec
+		if i := strings.IndexAny(f, ":="); i >= 0 {
+			spec = filterSpec{f[:i], f[i+1:]}
+		} else {
+			spec = filterSpec{name: f}
+		}
+		if _, ok := filterKeys[spec.name]; !ok {
+			return fmt.Errorf("filter %q unsupported", spec.name)
+		}
+		if _, err := splitFilterOptions(spec.name, spec.arg); err != nil {
+			return err
+		}
+		filters = append(filters, spec)
+	}
+	*v.filters = filters
+	return nil
+}
+
+// Update does nothing because the flag requires an argument.
+func (v *filtersValue) Update() {}
+
+// Get returns the filters.
+func (v *filtersValue) Get() interface{} { return *v.filters }
+
+// String returns an empty string since there is no default value.
+func (v *filtersValue) String() string { return "" }
+
+// bcjFilters maps the names of the branch/call/jump filters to the
+// filter IDs of the xz package.
+var bcjFilters = map[string]uint64{
+	"x86":      xz.FilterX86,
+	"powerpc":  xz.FilterPowerPC,
+	"ia64":     xz.FilterIA64,
+	"arm":      xz.FilterARM,
+	"armthumb": xz.FilterARMThumb,
+	"arm64":    xz.FilterARM64,
+	"sparc":    xz.FilterSPARC,
+	"riscv":    xz.FilterRISCV,
+}
+
+// filterNames lists the filter flags in the order of the usage message.
+var filterNames = []string{"x86", "arm", "armthumb", "arm64", "powerpc",
+	"ia64", "sparc", "riscv", "delta", "lzma1", "lzma2"}
+
+// filterKeys lists the option keys supported by the filters.
+var filterKeys = map[string][]string{
+	"lzma1": {"preset", "dict", "lc", "lp", "pb", "mf", "mode", "nice",
+		"depth"},
+	"lzma2": {"preset", "dict", "lc", "lp", "pb", "mf", "mode", "nice",
+		"depth"},
+	"delta": {"dist"},
+}
+
+func init() {
+	for name := range bcjFilters {
+		filterKeys[name] = []string{"start"}
+	}
+}
+
+// keyValue is a single option of a filter.
+type keyValue struct {
+	key   string
+	value string
+}
+
+// splitFilterOptions splits the options string of a filter into its
+// key=value pairs and checks the keys.
+func splitFilterOptions(name, s string) (kvs []keyValue, err error) {
+	if s == "" {
+		return nil, nil
+	}
+	for _, opt := range strings.Split(s, ",") {
+		kv := strings.SplitN(opt, "=", 2)
+		if len(kv) != 2 || kv[1] == "" {
+			return nil, fmt.Errorf("%s: option %q has no value",
+				name, opt)
+		}
+		found := false
+		for _, key := range filterKeys[name] {
+			if kv[0] == key {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return nil, fmt.Errorf("%s: unsupported option %q",
+				name, kv[0])
+		}
+		kvs = append(kvs, keyValue{kv[0], kv[1]})
+	}
+	return kvs, nil
+}
+
+// lzmaOptions contains the parameters of the LZMA1 and LZMA2 filters.
+type lzmaOptions struct {
+	dictCap    int
+	properties lzma.Properties
+	matcher    lzma.MatchAlgorithm
+	mode       lzma.Mode
+	niceLen    int
+	depth      int
+}
+
+// presetLZMAOptions returns the LZMA options for a compression preset.
+func presetLZMAOptions(preset int, extreme bool) (o lzmaOptions, err error) {
+	c, err := lzma.WriterConfigForPreset(preset, extreme)
+	if err != nil {
+		return o, err
+	}
+	o = lzmaOptions{
+		dictCap:    c.DictCap,
+		properties: *c.Properties,
+		matcher:    c.Matcher,
+		mode:       c.Mode,
+		niceLen:    c.NiceLen,
+		depth:      c.Depth,
+	}
+	return o, nil
+}
+
+// matchers maps the names of the match finders of the xz tool to the
+// match algorithms.
+var matchers = map[string]lzma.MatchAlgorithm{
+	"hc3": lzma.HC3,
+	"hc4": lzma.HC4,
+	"bt2": lzma.BT2,
+	"bt3": lzma.BT3,
+	"bt4": lzma.BT4,
+}
+
+// minNiceLen gives the smallest nice length supported by the match
+// finders.
+var minNiceLen = map[lzma.MatchAlgorithm]int{
+	lzma.HC3: 3,
+	lzma.HC4: 4,
+	lzma.BT2: 2,
+	lzma.BT3: 3,
+	lzma.BT4: 4,
+}
+
+// parseInt parses an integer in the range [min,max].
+func parseInt(s string, min, max int) (n int, err error) {
+	n, err = strconv.Atoi(s)
+	if err != nil || !(min <= n && n <= max) {
+		return 0, fmt.Errorf("value %q out of range [%d,%d]",
+			s, min, max)
+	}
+	return n, nil
+}
+
+// parsePreset parses a preset like 6 or 9e.
+func parsePreset(s string) (preset int, extreme bool, err error) {
+	if strings.HasSuffix(s, "e") {
+		s, extreme = s[:len(s)-1], true
+	}
+	preset, err = parseInt(s, lzma.MinPreset, lzma.MaxPreset)
+	return preset, extreme, err
+}
+
+// filterPreset is the preset providing the initial values of the LZMA
+// filter options. The xz tool ignores the compression preset for them.
+const filterPreset = 6
+
+// parseLZMAOptions parses the options of the LZMA1 or LZMA2 filter. The
+// options start with the values of preset 6. A preset option resets all
+// values; the options are applied from left to right as done by the xz
+// tool.
+func parseLZMAOptions(name, s string) (o lzmaOptions, err error) {
+	if o, err = presetLZMAOptions(filterPreset, false); err != nil {
+		return o, err
+	}
+	kvs, err := splitFilterOptions(name, s)
+	if err != nil {
+		return o, err
+	}
+	for _, kv := range kvs {
+		switch kv.key {
+		case "preset":
+			var preset int
+			var extreme bool
+			if preset, extreme, err = parsePreset(
+				kv.value); err == nil {
+				o, err = presetLZMAOptions(preset, extreme)
+			}
+		case "dict":
+			var n int64
+			if n, err = parseSize(kv.value); err != nil {
+				break
+			}
+			if !(lzma.MinDictCap <= n && n <= lzma.MaxDictCap) ||
+				int64(int(n)) != n {
+				err = errors.New("dictionary size out of range")
+				break
+			}
+			o.dictCap = int(n)
+		case "lc":
+			o.properties.LC, err = parseInt(kv.value, 0, 4)
+		case "lp":
+			o.properties.LP, err = parseInt(kv.value, 0, 4)
+		case "pb":
+			o.properties.PB, err = parseInt(kv.value, 0, 4)
+		case "mf":
+			var ok bool
+			if o.matcher, ok = matchers[kv.value]; !ok {
+				err = fmt.Errorf("match finder %q unsupported",
+					kv.value)
+			}
+		case "mode":
+			switch kv.value {
+			case "fast":
+				o.mode = lzma.ModeFast
+			case "normal":
+				o.mode = lzma.ModeNormal
+			default:
+				err = fmt.Errorf("mode %q unsupported",
+					kv.value)
+			}
+		case "nice":
+			o.niceLen, err = parseInt(kv.value, 2, 273)
+		case "depth":
+			o.depth, err = parseInt(kv.value, 0, lzma.MaxDepth)
+		}
+		if err != nil {
+			return o, fmt.Errorf("%s: option %s: %s", name, kv.key,
+				err)
+		}
+	}
+	if o.properties.LC+o.properties.LP > 4 {
+		return o, fmt.Errorf("%s: the sum of lc and lp exceeds 4",
+			name)
+	}
+	// The xz tool raises the nice length to the minimum of the match
+	// finder.
+	if n := minNiceLen[o.matcher]; o.niceLen < n {
+		o.niceLen = n
+	}
+	return o, nil
+}
+
+// writerConfig returns the configuration for a raw LZMA writer.
+func (o *lzmaOptions) writerConfig() lzma.WriterConfig {
+	p := o.properties
+	return lzma.WriterConfig{
+		Properties: &p,
+		DictCap:    o.dictCap,
+		Matcher:    o.matcher,
+		Mode:       o.mode,
+		NiceLen:    o.niceLen,
+		Depth:      o.depth,
+		EOSMarker:  true,
+	}
+}
+
+// writer2Config returns the configuration for an LZMA2 writer.
+func (o *lzmaOptions) writer2Config() lzma.Writer2Config {
+	p := o.properties
+	return lzma.Writer2Config{
+		Properties: &p,
+		DictCap:    o.dictCap,
+		Matcher:    o.matcher,
+		Mode:       o.mode,
+		NiceLen:    o.niceLen,
+		Depth:      o.depth,
+	}
+}
+
+// setXZ sets the LZMA2 parameters of the xz writer configuration.
+func (o *lzmaOptions) setXZ(c *xz.WriterConfig) {
+	p := o.properties
+	c.Properties = &p
+	c.DictCap = o.dictCap
+	c.Matcher = o.matcher
+	c.Mode = o.mode
+	c.NiceLen = o.niceLen
+	c.Depth = o.depth
+}
+
+// parseDeltaOptions parses the options of the delta filter. The
+// distance defaults to 1.
+func parseDeltaOptions(s string) (fc xz.FilterConfig, err error) {
+	fc = xz.FilterConfig{ID: xz.FilterDelta, Dist: 1}
+	kvs, err := splitFilterOptions("delta", s)
+	if err != nil {
+		return fc, err
+	}
+	for _, kv := range kvs {
+		// dist is the only key
+		if fc.Dist, err = parseInt(kv.value, 1, 256); err != nil {
+			return fc, fmt.Errorf("delta: option dist: %s", err)
+		}
+	}
+	return fc, nil
+}
+
+// parseBCJOptions parses the options of a branch/call/jump filter. The
+// start offset supports the size suffixes.
+func parseBCJOptions(name, s string) (fc xz.FilterConfig, err error) {
+	fc = xz.FilterConfig{ID: bcjFilters[name]}
+	kvs, err := splitFilterOptions(name, s)
+	if err != nil {
+		return fc, err
+	}
+	for _, kv := range kvs {
+		// start is the only key
+		if kv.value == "0" {
+			fc.StartOffset = 0
+			continue
+		}
+		n, err := parseSize(kv.value)
+		if err != nil || n > 1<<32-1 {
+			return fc, fmt.Errorf("%s: start offset %q out of range",
+				name, kv.value)
+		}
+		fc.StartOffset = uint32(n)
+	}
+	return fc, nil
+}
+
+// xzFilterChain converts the filter flags into the filter chain of the
+// xz writer. The chain must end with the LZMA2 filter; its options are
+// returned in o.
+func xzFilterChain(filters []filterSpec) (chain []xz.FilterConfig,
+	o lzmaOptions, err error) {
+
+	if len(filters) > 4 {
+		return nil, o, errors.New(
+			"the filter chain supports at most four filters")
+	}
+	if filters[len(filters)-1].name != "lzma2" {
+		return nil, o, errors.New(
+			"the last filter of the chain must be lzma2")
+	}
+	chain = make([]xz.FilterConfig, len(filters))
+	for i, f := range filters {
+		switch {
+		case f.name == "lzma2" && i == len(filters)-1:
+			o, err = parseLZMAOptions(f.name, f.arg)
+			chain[i] = xz.FilterConfig{ID: xz.FilterLZMA2,
+				DictCap: o.dictCap}
+		case f.name == "delta":
+			chain[i], err = parseDeltaOptions(f.arg)
+		case bcjFilters[f.name] != 0:
+			chain[i], err = parseBCJOptions(f.name, f.arg)
+		default:
+			err = fmt.Errorf(
+				"filter %s not supported in the filter chain",
+				f.name)
+		}
+		if err != nil {
+			return nil, o, err
+		}
+	}
+	return chain, o, nil
+}
+
+// normalizeFilters parses the filter flags. The raw format requires a
+// single LZMA1 or LZMA2 filter; without a filter flag LZMA2 with the
+// compression preset is used. The options of filter flags don't depend
+// on the compression preset. The lzma format supports only the LZMA1
+// filter and the xz format a filter chain ending with LZMA2. The flags
+// are ignored for the decompression of the other formats as done by the
+// xz tool.
+func normalizeFilters(o *options) (err error) {
+	if o.lzma, err = presetLZMAOptions(o.preset, o.extreme); err != nil {
+		return err
+	}
+	if o.format == "raw" && len(o.filters) == 0 {
+		o.rawFilter = filterSpec{name: "lzma2"}
+		return nil
+	}
+	if len(o.filters) == 0 || (o.decompress && o.format != "raw") {
+		return nil
+	}
+	switch o.format {
+	case "raw", "lzma":
+		f := o.filters[0]
+		if len(o.filters) > 1 ||
+			!(f.name == "lzma1" || f.name == "lzma2") {
+			return fmt.Errorf("%s format supports only "+
+				"a single LZMA filter", o.format)
+		}
+		if o.format == "lzma" && f.name != "lzma1" {
+			return errors.New("lzma format requires the lzma1 filter")
+		}
+		o.rawFilter = f
+		o.lzma, err = parseLZMAOptions(f.name, f.arg)
+		return err
+	case "xz":
+		o.chain, o.lzma, err = xzFilterChain(o.filters)
+		return err
+	}
+	return fmt.Errorf("%s format doesn't support filter options", o.format)
+}
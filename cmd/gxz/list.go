@@ -0,0 +1,558 @@
+// Copyright 2014-2017 Ulrich Kunitz. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"github.com/ulikunitz/xz"
+)
+
+// The list mode reads only the headers, indexes and footers of the xz
+// files. The output imitates the output of xz --list; the robot format
+// is identical to the format of xz --robot --list.
+
+// Memory usage of the decoders as computed by xz.
+const (
+	lzma2MemUsage = 65592
+	deltaMemUsage = 352
+	bcjMemUsage   = 1024
+)
+
+// Minimum xz versions in the encoding of the xz robot format.
+const (
+	xzVersion500 = 50000002
+	xzVersion540 = 50040002
+	xzVersion560 = 50060002
+)
+
+// checkNames provides the names of the check types used by xz.
+var checkNames = map[byte]string{
+	xz.None:   "None",
+	xz.CRC32:  "CRC32",
+	xz.CRC64:  "CRC64",
+	xz.SHA256: "SHA-256",
+}
+
+// checkName returns the name of the check type as used by xz.
+func checkName(check byte) string {
+	if s, ok := checkNames[check]; ok {
+		return s
+	}
+	return fmt.Sprintf("Unknown-%d", check)
+}
+
+// listSummary aggregates the information for a file or for all
+// listed files.
+type listSummary struct {
+	files        int
+	streams      int
+	blocks       int
+	compressed   int64
+	uncompressed int64
+	padding      int64
+	// bit set of check types
+	checks uint16
+	// maximum dictionary capacity of all LZMA2 filters
+	dictCap int
+	// maximum memory usage of all blocks
+	memUsage int64
+	// all blocks store both sizes in their header
+	sizesInHeaders bool
+	minVersion     int
+}
+
+// newListSummary summarizes the streams of a file.
+func newListSummary(streams []xz.StreamInfo) listSummary {
+	s := listSummary{
+		files:          1,
+		streams:        len(streams),
+		sizesInHeaders: true,
+		minVersion:     xzVersion500,
+	}
+	for _, si := range streams {
+		s.compressed += si.CompressedSize + si.Padding
+		s.uncompressed += si.UncompressedSize
+		s.padding += si.Padding
+		s.checks |= 1 << si.CheckType
+		s.blocks += len(si.Blocks)
+		for _, b := range si.Blocks {
+			if m := blockMemUsage(&b); m > s.memUsage {
+				s.memUsage = m
+			}
+			if v := blockVersion(&b); v > s.minVersion {
+				s.minVersion = v
+			}
+			if !(b.CompressedSizeStored &&
+				b.UncompressedSizeStored) {
+				s.sizesInHeaders = false
+			}
+			for _, f := range b.Filters {
+				if f.ID == xz.FilterLZMA2 && f.DictCap > s.dictCap {
+					s.dictCap = f.DictCap
+				}
+			}
+		}
+	}
+	return s
+}
+
+// add adds the summary t to s.
+func (s *listSummary) add(t *listSummary) {
+	s.files += t.files
+	s.streams += t.streams
+	s.blocks += t.blocks
+	s.compressed += t.compressed
+	s.uncompressed += t.uncompressed
+	s.padding += t.padding
+	s.checks |= t.checks
+	if t.dictCap > s.dictCap {
+		s.dictCap = t.dictCap
+	}
+	if t.memUsage > s.memUsage {
+		s.memUsage = t.memUsage
+	}
+	s.sizesInHeaders = s.sizesInHeaders && t.sizesInHeaders
+	if t.minVersion > s.minVersion {
+		s.minVersion = t.minVersion
+	}
+}
+
+// checksString returns the names of the check types in the set
+// separated by sep.
+func checksString(checks uint16, sep string) string {
+	var names []string
+	for i := uint(0); i < 16; i++ {
+		if checks&(1<<i) != 0 {
+			names = append(names, checkName(byte(i)))
+		}
+	}
+	return strings.Join(names, sep)
+}
+
+// blockMemUsage returns the memory required to decode the block.
+func blockMemUsage(b *xz.BlockInfo) int64 {
+	var m int64
+	for _, f := range b.Filters {
+		switch f.ID {
+		case xz.FilterLZMA2:
+			m += int64(f.DictCap) + lzma2MemUsage
+		case xz.FilterDelta:
+			m += deltaMemUsage
+		default:
+			m += bcjMemUsage
+		}
+	}
+	return m
+}
+
+// blockVersion returns the first xz version supporting the filters of
+// the block.
+func blockVersion(b *xz.BlockInfo) int {
+	v := xzVersion500
+	for _, f := range b.Filters {
+		switch {
+		case f.ID == xz.FilterRISCV && v < xzVersion560:
+			v = xzVersion560
+		case f.ID == xz.FilterARM64 && v < xzVersion540:
+			v = xzVersion540
+		}
+	}
+	return v
+}
+
+// versionString converts a version in the encoding of the robot format
+// into the usual string representation.
+func versionString(v int) string {
+	return fmt.Sprintf("%d.%d.%d", v/10000000, v/10000%1000, v/10%1000)
+}
+
+// ratioString returns the compression ratio with three decimals or ---
+// if it cannot be represented.
+func ratioString(compressed, uncompressed int64) string {
+	if uncompressed <= 0 {
+		return "---"
+	}
+	r := float64(compressed) / float64(uncompressed)
+	if r > 9.999 {
+		return "---"
+	}
+	return fmt.Sprintf("%.3f", r)
+}
+
+// sizeString formats a size in the largest unit that keeps the number
+// below 10000.
+func sizeString(n int64) string {
+	if n < 1024 {
+		return fmt.Sprintf("%d B", n)
+	}
+	units := []string{"KiB", "MiB", "GiB", "TiB"}
+	d := float64(n) / 1024
+	i := 0
+	for d > 9999.9 && i < len(units)-1 {
+		d /= 1024
+		i++
+	}
+	return fmt.Sprintf("%.1f %s", d, units[i])
+}
+
+// sizeBytesString formats a size and adds the exact number of bytes.
+func sizeBytesString(n int64) string {
+	if n < 1024 {
+		return sizeString(n)
+	}
+	return fmt.Sprintf("%s (%d B)", sizeString(n), n)
+}
+
+// mibString rounds n up to full mebibytes.
+func mibString(n int64) string {
+	return fmt.Sprintf("%d MiB", (n+1<<20-1)>>20)
+}
+
+// dictString returns the dictionary capacity for the list table.
+func dictString(n int) string {
+	switch {
+	case n <= 0:
+		return "---"
+	case n%(1<<20) == 0:
+		return fmt.Sprintf("%d MiB", n>>20)
+	case n%(1<<10) == 0:
+		return fmt.Sprintf("%d KiB", n>>10)
+	}
+	return fmt.Sprintf("%d B", n)
+}
+
+// filtersString returns the filter chain using the options of xz.
+func filtersString(filters []xz.FilterConfig) string {
+	s := make([]string, len(filters))
+	for i, f := range filters {
+		s[i] = "--" + f.String()
+	}
+	return strings.Join(s, " ")
+}
+
+// flagsString reports the sizes stored in the block header.
+func flagsString(b *xz.BlockInfo) string {
+	p := []byte("--")
+	if b.CompressedSizeStored {
+		p[0] = 'c'
+	}
+	if b.UncompressedSizeStored {
+		p[1] = 'u'
+	}
+	return string(p)
+}
+
+// checkValue returns the check of a block as hex string. The CRC
+// values are stored in little-endian byte order, but xz shows them as
+// numbers.
+func checkValue(check byte, b *xz.BlockInfo) string {
+	if len(b.Check) == 0 {
+		return "---"
+	}
+	if check != xz.CRC32 && check != xz.CRC64 {
+		return hex.EncodeToString(b.Check)
+	}
+	p := make([]byte, len(b.Check))
+	for i, c := range b.Check {
+		p[len(p)-1-i] = c
+	}
+	return hex.EncodeToString(p)
+}
+
+var errListStdin = errors.New(
+	"--list does not support reading from standard input")
+
+// inspectFile reads the stream information of the xz file.
+func inspectFile(path string, opts *options) (streams []xz.StreamInfo,
+	err error) {
+
+	if path == "-" {
+		return nil, errListStdin
+	}
+	f, err := openFile(path, opts)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	fi, err := f.Stat()
+	if err != nil {
+		return nil, err
+	}
+	h := make([]byte, xz.HeaderLen)
+	if _, err = io.ReadFull(f, h); err != nil || !xz.ValidHeader(h) {
+		return nil, &userPathError{path, errInvalidFormat}
+	}
+	streams, err = xz.Inspect(f, fi.Size())
+	if err != nil {
+		return nil, &userPathError{path, err}
+	}
+	return streams, nil
+}
+
+// lister prints the list output.
+type lister struct {
+	w       io.Writer
+	robot   bool
+	verbose int
+	nfiles  int
+	// number of files listed so far
+	i      int
+	totals listSummary
+}
+
+// listFiles lists the given files. The function returns an error if
+// one of the files couldn't be listed.
+func listFiles(args []string, opts *options) (err error) {
+	l := &lister{
+		w:       os.Stdout,
+		robot:   opts.robot,
+		verbose: opts.verbose,
+		nfiles:  len(args),
+		totals:  listSummary{sizesInHeaders: true},
+	}
+	for _, path := range args {
+		streams, ferr := inspectFile(path, opts)
+		if ferr != nil {
+			printErr(ferr)
+			err = ferr
+			continue
+		}
+		l.listFile(path, streams)
+	}
+	if l.i > 1 || (l.robot && l.i > 0) {
+		l.printTotals()
+	}
+	return err
+}
+
+// listFile prints the information for a single file.
+func (l *lister) listFile(path string, streams []xz.StreamInfo) {
+	s := newListSummary(streams)
+	l.i++
+	l.totals.add(&s)
+	switch {
+	case l.robot:
+		l.robotFile(path, streams, &s)
+	case l.verbose > 0:
+		l.verboseFile(path, streams, &s)
+	default:
+		if l.i == 1 {
+			fmt.Fprintf(l.w, listFmt, "Strms", "Blocks",
+				"Compressed", "Uncompressed", "Ratio", "Dict",
+				"Check", "Filename")
+		}
+		fmt.Fprintf(l.w, listFmt, fmt.Sprint(s.streams),
+			fmt.Sprint(s.blocks), sizeString(s.compressed),
+			sizeString(s.uncompressed),
+			ratioString(s.compressed, s.uncompressed),
+			dictString(s.dictCap), checksString(s.checks, ","), path)
+	}
+}
+
+// listFmt is the format of a line of the list table.
+const listFmt = "%5s %7s  %11s %12s  %5s  %9s  %-7s %s\n"
+
+// robotFile prints the information for a file in the robot format.
+func (l *lister) robotFile(path string, streams []xz.StreamInfo,
+	s *listSummary) {
+
+	fmt.Fprintf(l.w, "name\t%s\n", path)
+	fmt.Fprintf(l.w, "file\t%d\t%d\t%d\t%d\t%s\t%s\t%d\n",
+		s.streams, s.blocks, s.compressed, s.uncompressed,
+		ratioString(s.compressed, s.uncompressed),
+		checksString(s.checks, ","), s.padding)
+	if l.verbose < 1 {
+		return
+	}
+	for i, si := range streams {
+		fmt.Fprintf(l.w, "stream\t%d\t%d\t%d\t%d\t%d\t%d\t%s\t%s\t%d\n",
+			i+1, len(si.Blocks), si.Offset, si.UncompressedOffset,
+			si.CompressedSize, si.UncompressedSize,
+			ratioString(si.CompressedSize, si.UncompressedSize),
+			checkName(si.CheckType), si.Padding)
+	}
+	n := 0
+	for i, si := range streams {
+		for j, b := range si.Blocks {
+			n++
+			total := totalSize(&b)
+			fmt.Fprintf(l.w, "block\t%d\t%d\t%d\t%d\t%d\t%d\t%d\t%s\t%s",
+				i+1, j+1, n, b.Offset, b.UncompressedOffset,
+				total, b.UncompressedSize,
+				ratioString(total, b.UncompressedSize),
+				checkName(si.CheckType))
+			if l.verbose >= 2 {
+				fmt.Fprintf(l.w, "\t%s\t%d\t%s\t%d\t%d\t%s",
+					checkValue(si.CheckType, &b), b.HeaderSize,
+					flagsString(&b), b.CompressedSize,
+					blockMemUsage(&b),
+					filtersString(b.Filters))
+			}
+			fmt.Fprintln(l.w)
+		}
+	}
+	if l.verbose >= 2 {
+		fmt.Fprintf(l.w, "summary\t%d\t%s\t%d\n", s.memUsage,
+			yesNo(s.sizesInHeaders, "yes", "no"), s.minVersion)
+	}
+}
+
+// totalSize returns the size of the block including the block padding.
+func totalSize(b *xz.BlockInfo) int64 {
+	return (b.UnpaddedSize + 3) &^ 3
+}
+
+// yesNo selects one of the strings.
+func yesNo(b bool, yes, no string) string {
+	if b {
+		return yes
+	}
+	return no
+}
+
+// printSummary prints the summary lines of the verbose format.
+func (l *lister) printSummary(s *listSummary) {
+	fmt.Fprintf(l.w, "  Streams:           %d\n", s.streams)
+	fmt.Fprintf(l.w, "  Blocks:            %d\n", s.blocks)
+	fmt.Fprintf(l.w, "  Compressed size:   %s\n",
+		sizeBytesString(s.compressed))
+	fmt.Fprintf(l.w, "  Uncompressed size: %s\n",
+		sizeBytesString(s.uncompressed))
+	fmt.Fprintf(l.w, "  Ratio:             %s\n",
+		ratioString(s.compressed, s.uncompressed))
+	fmt.Fprintf(l.w, "  Check:             %s\n",
+		checksString(s.checks, ", "))
+	fmt.Fprintf(l.w, "  Stream Padding:    %s\n",
+		sizeBytesString(s.padding))
+}
+
+// printRequirements prints the decoder requirements in the verbose
+// format.
+func (l *lister) printRequirements(s *listSummary) {
+	fmt.Fprintf(l.w, "  Memory needed:     %s\n", mibString(s.memUsage))
+	fmt.Fprintf(l.w, "  Sizes in headers:  %s\n",
+		yesNo(s.sizesInHeaders, "Yes", "No"))
+	fmt.Fprintf(l.w, "  Minimum XZ Utils version: %s\n",
+		versionString(s.minVersion))
+}
+
+// verboseFile prints the information for a file in the verbose human
+// readable format.
+func (l *lister) verboseFile(path string, streams []xz.StreamInfo,
+	s *listSummary) {
+
+	if l.i > 1 {
+		fmt.Fprintln(l.w)
+	}
+	fmt.Fprintf(l.w, "%s (%d/%d)\n", path, l.i, l.nfiles)
+	l.printSummary(s)
+
+	fmt.Fprintln(l.w, "  Streams:")
+	const streamFmt = "    %6s %9s %15s %15s %15s %15s  %5s  %-10s %7s\n"
+	fmt.Fprintf(l.w, streamFmt, "Stream", "Blocks", "CompOffset",
+		"UncompOffset", "CompSize", "UncompSize", "Ratio", "Check",
+		"Padding")
+	for i, si := range streams {
+		fmt.Fprintf(l.w, streamFmt, fmt.Sprint(i+1),
+			fmt.Sprint(len(si.Blocks)), fmt.Sprint(si.Offset),
+			fmt.Sprint(si.UncompressedOffset),
+			fmt.Sprint(si.CompressedSize),
+			fmt.Sprint(si.UncompressedSize),
+			ratioString(si.CompressedSize, si.UncompressedSize),
+			checkName(si.CheckType), fmt.Sprint(si.Padding))
+	}
+
+	if s.blocks > 0 {
+		// the width of the check value column depends on the largest
+		// check
+		cw := len("CheckVal")
+		for _, si := range streams {
+			for _, b := range si.Blocks {
+				if k := 2 * b.CheckSize; k > cw {
+					cw = k
+				}
+			}
+		}
+		fmt.Fprintln(l.w, "  Blocks:")
+		l.blockLine(cw, "Stream", "Block", "CompOffset",
+			"UncompOffset", "TotalSize", "UncompSize", "Ratio",
+			"Check", "CheckVal", "Header", "Flags", "CompSize",
+			"MemUsage", "Filters")
+		for i, si := range streams {
+			for j, b := range si.Blocks {
+				total := totalSize(&b)
+				l.blockLine(cw, fmt.Sprint(i+1), fmt.Sprint(j+1),
+					fmt.Sprint(b.Offset),
+					fmt.Sprint(b.UncompressedOffset),
+					fmt.Sprint(total),
+					fmt.Sprint(b.UncompressedSize),
+					ratioString(total, b.UncompressedSize),
+					checkName(si.CheckType), checkValue(si.CheckType, &b),
+					fmt.Sprint(b.HeaderSize), flagsString(&b),
+					fmt.Sprint(b.CompressedSize),
+					mibString(blockMemUsage(&b)),
+					filtersString(b.Filters))
+			}
+		}
+	}
+
+	if l.verbose >= 2 {
+		l.printRequirements(s)
+	}
+}
+
+// blockLine prints a line of the block table. The columns starting
+// with the check value are only printed with -vv.
+func (l *lister) blockLine(cw int, a ...string) {
+	if l.verbose < 2 {
+		fmt.Fprintf(l.w, "    %6s %9s %15s %15s %15s %15s  %5s  %s\n",
+			a[0], a[1], a[2], a[3], a[4], a[5], a[6], a[7])
+		return
+	}
+	fmt.Fprintf(l.w, "    %6s %9s %15s %15s %15s %15s  %5s  %-10s "+
+		"%-*s  %6s  %-5s %15s %11s  %s\n",
+		a[0], a[1], a[2], a[3], a[4], a[5], a[6], a[7], cw, a[8],
+		a[9], a[10], a[11], a[12], a[13])
+}
+
+// printTotals prints the totals for all listed files.
+func (l *lister) printTotals() {
+	t := &l.totals
+	switch {
+	case l.robot:
+		fmt.Fprintf(l.w, "totals\t%d\t%d\t%d\t%d\t%s\t%s\t%d\t%d",
+			t.streams, t.blocks, t.compressed, t.uncompressed,
+			ratioString(t.compressed, t.uncompressed),
+			checksString(t.checks, ","), t.padding, t.files)
+		if l.verbose >= 2 {
+			fmt.Fprintf(l.w, "\t%d\t%s\t%d", t.memUsage,
+				yesNo(t.sizesInHeaders, "yes", "no"),
+				t.minVersion)
+		}
+		fmt.Fprintln(l.w)
+	case l.verbose > 0:
+		fmt.Fprintln(l.w)
+		fmt.Fprintln(l.w, "Totals:")
+		fmt.Fprintf(l.w, "  Number of files:   %d\n", t.files)
+		l.printSummary(t)
+		if l.verbose >= 2 {
+			l.printRequirements(t)
+		}
+	default:
+		fmt.Fprintln(l.w, strings.Repeat("-", 79))
+		fmt.Fprintf(l.w, listFmt, fmt.Sprint(t.streams),
+			fmt.Sprint(t.blocks), sizeString(t.compressed),
+			sizeString(t.uncompressed),
+			ratioString(t.compressed, t.uncompressed),
+			dictString(t.dictCap), checksString(t.checks, ","),
+			fmt.Sprintf("%d files", t.files))
+	}
+}
@@ -13,12 +13,16 @@ package main
 import (
 	"fmt"
 	"io"
+	"math"
 	"os"
 	"path/filepath"
+	"runtime"
 	"runtime/pprof"
+	"strconv"
 	"strings"
 	"text/template"
 
+	"github.com/ulikunitz/xz"
 	"github.com/ulikunitz/xz/internal/gflag"
 	"github.com/ulikunitz/xz/internal/term"
 	"github.com/ulikunitz/xz/internal/xlog"
@@ -38,14 +42,67 @@ in place).
 	            the file content is used to identify the format.
     xz              The xz file format.
     lzma, alone     Compress to the .lzma file format.
+    lzip            Compress to the .lz file format.
+    raw             Raw LZMA1 or LZMA2 stream without any headers. The
+                    same filter options must be used for decompression.
+                    The .raw suffix is used.
   -h, --help        give this help
   -k, --keep        keep (don't delete) input files
+  -l, --list        list information about xz files
   -L, --license     display software license
   -q, --quiet       suppress all warnings
+  -t, --test        test compressed file integrity
+  -T, --threads <n> use n threads to compress or decompress xz files; 0
+                    uses the number of CPUs; default is 1. All thread
+                    counts above 1 produce the same compressed output.
+                    A single thread writes one block without sizes, so
+                    its output differs, and the output of -T0 depends on
+                    the number of CPUs.
+  --block-size <size>
+                    start a new xz block after size bytes of input; the
+                    suffixes KiB, MiB and GiB are supported. The default
+                    with multiple threads is three times the dictionary
+                    capacity.
   -v, --verbose     verbose mode
   -V, --version     display version string
   -z, --compress    force compression
   -0 ... -9         compression preset; default is 6
+  -e, --extreme     use a slower variant of the compression preset that
+                    may compress better
+  --x86[=<options>], --arm[=<options>], --armthumb[=<options>],
+  --arm64[=<options>], --powerpc[=<options>], --ia64[=<options>],
+  --sparc[=<options>], --riscv[=<options>]
+                    add a branch/call/jump filter to the filter chain of
+                    the xz format. The option start=<offset> sets the
+                    start offset.
+  --delta[=<options>]
+                    add the delta filter to the filter chain of the xz
+                    format. The option dist=<n> sets the distance 1-256;
+                    default is 1.
+  --lzma1[=<options>]
+  --lzma2[=<options>]
+                    select the LZMA1 filter for the lzma and raw formats
+                    or the LZMA2 filter for the xz and raw formats. The
+                    filters are applied in command line order; the xz
+                    format requires LZMA2 as last filter. The options
+                    start from preset 6 independent of -0 ... -9 and are
+                    a comma-separated list of key=value pairs:
+      preset=<n>    reset the options to preset 0-9 with optional suffix e
+      dict=<size>   dictionary capacity; suffixes KiB, MiB and GiB
+      lc=<n>        number of literal context bits 0-4
+      lp=<n>        number of literal position bits 0-4; lc+lp <= 4
+      pb=<n>        number of position bits 0-4
+      mf=<name>     match finder hc3, hc4, bt2, bt3 or bt4
+      mode=<mode>   encoder mode fast or normal
+      nice=<n>      nice length of a match 2-273; raised to the
+                    minimum of the match finder
+      depth=<n>     maximum search depth of the match finder; 0 selects
+                    the default; at most 65536
+  --filters <filters>
+                    set the filter chain, for instance
+                    "x86 lzma2:preset=9e,dict=64MiB"; filters are
+                    separated by spaces or --, options follow : or =
+  --robot           use machine-parsable messages (useful for scripts)
   --cpuprofile <file>
                     create a cpuprofile that can be used with go tool pprof
 
@@ -97,11 +154,27 @@ type options struct {
 	format     string
 	keep       bool
 	license    bool
+	list       bool
+	robot      bool
+	test       bool
+	threads    int
+	blockArg   string
+	blockSize  int64
 	version    bool
 	quiet      int
 	verbose    int
 	preset     int
+	extreme    bool
 	cpuprofile string
+	// filter flags in command line order
+	filters []filterSpec
+	// filter for the raw and lzma formats
+	rawFilter filterSpec
+	// filter chain for the xz format
+	chain []xz.FilterConfig
+	// options of the LZMA filter; set from the preset without filter
+	// flags
+	lzma lzmaOptions
 }
 
 func (o *options) Init() {
@@ -115,19 +188,32 @@ func (o *options) Init() {
 	gflag.StringVarP(&o.format, "format", "F", "auto", "")
 	gflag.BoolVarP(&o.keep, "keep", "k", false, "")
 	gflag.BoolVarP(&o.license, "license", "L", false, "")
+	gflag.BoolVarP(&o.list, "list", "l", false, "")
+	gflag.BoolVarP(&o.robot, "robot", "", false, "")
+	gflag.BoolVarP(&o.test, "test", "t", false, "")
+	gflag.IntVarP(&o.threads, "threads", "T", 1, "")
+	gflag.StringVarP(&o.blockArg, "block-size", "", "", "")
 	gflag.BoolVarP(&o.version, "version", "V", false, "")
 	gflag.CounterVarP(&o.quiet, "quiet", "q", 0, "")
 	gflag.CounterVarP(&o.verbose, "verbose", "v", 0, "")
 	gflag.PresetVar(&o.preset, 0, 9, 6, "")
+	gflag.BoolVarP(&o.extreme, "extreme", "e", false, "")
 	gflag.StringVarP(&o.cpuprofile, "cpuprofile", "", "", "")
+	for _, name := range filterNames {
+		gflag.VarP(&filterValue{name, &o.filters}, name, "",
+			gflag.OptionalArg)
+	}
+	gflag.VarP(&filtersValue{&o.filters}, "filters", "",
+		gflag.RequiredArg)
 }
 
 // normalizeFormat normalizes the format field of options. If the
 // function completes without error the format field will be "xz",
-// "lzma" or "auto". The latter only if the option decompress is true.
+// "lzma", "lzip", "raw" or "auto". The latter only if the option
+// decompress is true.
 func normalizeFormat(o *options) error {
 	switch o.format {
-	case "xz", "lzma":
+	case "xz", "lzma", "lzip", "raw":
 	case "auto":
 		if !o.decompress {
 			o.format = "xz"
@@ -140,6 +226,51 @@ func normalizeFormat(o *options) error {
 	return nil
 }
 
+// normalizeThreads replaces the thread count 0 by the number of CPUs
+// and parses the block size argument.
+func normalizeThreads(o *options) error {
+	switch {
+	case o.threads < 0:
+		return fmt.Errorf("number of threads %d is negative",
+			o.threads)
+	case o.threads == 0:
+		o.threads = runtime.NumCPU()
+	}
+	if o.blockArg != "" {
+		var err error
+		if o.blockSize, err = parseSize(o.blockArg); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// sizeSuffixes maps the supported size suffixes to their multipliers.
+var sizeSuffixes = []struct {
+	suffix string
+	m      int64
+}{
+	{"KiB", 1 << 10}, {"KB", 1 << 10}, {"k", 1 << 10}, {"K", 1 << 10},
+	{"MiB", 1 << 20}, {"MB", 1 << 20}, {"M", 1 << 20},
+	{"GiB", 1 << 30}, {"GB", 1 << 30}, {"G", 1 << 30},
+}
+
+// parseSize parses a positive size with an optional suffix.
+func parseSize(s string) (n int64, err error) {
+	t, m := s, int64(1)
+	for _, x := range sizeSuffixes {
+		if strings.HasSuffix(t, x.suffix) {
+			t, m = t[:len(t)-len(x.suffix)], x.m
+			break
+		}
+	}
+	n, err = strconv.ParseInt(t, 10, 64)
+	if err != nil || n <= 0 || n > math.MaxInt64/m {
+		return 0, fmt.Errorf("invalid size %q", s)
+	}
+	return n * m, nil
+}
+
 func main() {
 	// setup logger
 	cmdName := filepath.Base(os.Args[0])
@@ -208,10 +339,22 @@ func main() {
 		}
 	}
 
+	if opts.test {
+		opts.decompress = true
+	}
+
 	if err := normalizeFormat(&opts); err != nil {
 		pprof.StopCPUProfile()
 		xlog.Fatal(err)
 	}
+	if err := normalizeFilters(&opts); err != nil {
+		pprof.StopCPUProfile()
+		xlog.Fatal(err)
+	}
+	if err := normalizeThreads(&opts); err != nil {
+		pprof.StopCPUProfile()
+		xlog.Fatal(err)
+	}
 
 	var args []string
 	if gflag.NArg() == 0 {
@@ -221,6 +364,19 @@ func main() {
 		args = gflag.Args()
 	}
 
+	if opts.list {
+		if opts.format != "xz" && opts.format != "auto" {
+			pprof.StopCPUProfile()
+			xlog.Fatal("--list works only on .xz files")
+		}
+		exit := 0
+		if err := listFiles(args, &opts); err != nil {
+			exit = 1
+		}
+		pprof.StopCPUProfile()
+		os.Exit(exit)
+	}
+
 	if opts.stdout && !opts.decompress && !opts.force &&
 		term.IsTerminal(os.Stdout.Fd()) {
 		pprof.StopCPUProfile()
@@ -228,9 +384,22 @@ func main() {
 Use -f to force compression. For help type gxz -h.`)
 	}
 
+	process := processFile
+	if opts.test {
+		process = testFile
+	}
+	// The exit status follows xz: 1 for errors and 2 if only
+	// warnings have been reported.
 	exit := 0
 	for _, arg := range args {
-		if err := processFile(arg, &opts); err != nil {
+		err := process(arg, &opts)
+		switch {
+		case err == nil:
+		case isWarning(err):
+			if exit == 0 {
+				exit = 2
+			}
+		default:
 			exit = 1
 		}
 	}
@@ -52,3 +52,30 @@ var crc64Table = crc64.MakeTable(crc64.ECMA)
 func newCRC64() hash.Hash {
 	return crc64Hash{Hash64: crc64.New(crc64Table)}
 }
+
+// skipHash implements a hash that doesn't compute anything. The Sum
+// method returns no bytes. It supports the check type None and the
+// skipping of unsupported checks.
+type skipHash struct {
+	size int
+}
+
+// Write ignores the data.
+func (h skipHash) Write(p []byte) (n int, err error) { return len(p), nil }
+
+// Sum returns b unchanged.
+func (h skipHash) Sum(b []byte) []byte { return b }
+
+// Reset does nothing.
+func (h skipHash) Reset() {}
+
+// Size returns the size of the check field.
+func (h skipHash) Size() int { return h.size }
+
+// BlockSize returns 1.
+func (h skipHash) BlockSize() int { return 1 }
+
+// newNoneHash returns the hash for the check type None.
+func newNoneHash() hash.Hash {
+	return skipHash{}
+}
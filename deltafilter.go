@@ -0,0 +1,123 @@
+// Copyright 2014-2017 Ulrich Kunitz. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package xz
+
+import (
+	"errors"
+	"fmt"
+	"io"
+
+	"github.com/ulikunitz/xz/internal/delta"
+)
+
+// FilterDelta is the filter ID of the delta filter. The filter stores
+// the differences between bytes at the given distance, which improves
+// the compression of uncompressed sample data like audio.
+const FilterDelta uint64 = 0x03
+
+// Delta filter constants.
+const (
+	deltaFilterLen = 3
+	minDeltaDist   = delta.MinDist
+	maxDeltaDist   = delta.MaxDist
+)
+
+// deltaFilter declares the delta filter information stored in an xz
+// block header.
+type deltaFilter struct {
+	dist int
+}
+
+// String returns a representation of the delta filter.
+func (f deltaFilter) String() string {
+	return fmt.Sprintf("delta distance %d", f.dist)
+}
+
+// id returns the ID for the delta filter.
+func (f deltaFilter) id() uint64 { return FilterDelta }
+
+// MarshalBinary converts the deltaFilter in its encoded representation.
+func (f deltaFilter) MarshalBinary() (data []byte, err error) {
+	if !(minDeltaDist <= f.dist && f.dist <= maxDeltaDist) {
+		return nil, errors.New("xz: delta distance out of range")
+	}
+	return []byte{byte(FilterDelta), 1, byte(f.dist - 1)}, nil
+}
+
+// UnmarshalBinary unmarshals the given data representation of the delta
+// filter.
+func (f *deltaFilter) UnmarshalBinary(data []byte) error {
+	if len(data) != deltaFilterLen {
+		return errors.New("xz: data for delta filter has wrong length")
+	}
+	if uint64(data[0]) != FilterDelta {
+		return errors.New("xz: wrong delta filter id")
+	}
+	if data[1] != 1 {
+		return errors.New("xz: wrong delta filter size")
+	}
+	f.dist = int(data[2]) + 1
+	return nil
+}
+
+// reader creates a new reader for the delta filter.
+func (f deltaFilter) reader(r io.Reader, c *ReaderConfig) (fr io.Reader,
+	err error) {
+
+	if !(minDeltaDist <= f.dist && f.dist <= maxDeltaDist) {
+		return nil, errors.New("xz: delta distance out of range")
+	}
+	return delta.NewReader(r, f.dist), nil
+}
+
+// writeCloser creates a io.WriteCloser for the delta filter.
+func (f deltaFilter) writeCloser(w io.WriteCloser, c *WriterConfig,
+) (fw io.WriteCloser, err error) {
+	if !(minDeltaDist <= f.dist && f.dist <= maxDeltaDist) {
+		return nil, errors.New("xz: delta distance out of range")
+	}
+	return &deltaWriter{w: w, d: delta.New(f.dist)}, nil
+}
+
+// last returns false, because the delta filter must be followed by
+// another filter.
+func (f deltaFilter) last() bool { return false }
+
+// deltaWriter encodes the data before writing it to the underlying
+// writer.
+type deltaWriter struct {
+	w   io.WriteCloser
+	d   *delta.Delta
+	buf []byte
+}
+
+// Write encodes the data in p and writes it.
+func (w *deltaWriter) Write(p []byte) (n int, err error) {
+	if w.buf == nil {
+		w.buf = make([]byte, 4096)
+	}
+	for len(p) > 0 {
+		q := w.buf[:copy(w.buf, p)]
+		w.d.Encode(q)
+		k, err := w.w.Write(q)
+		n += k
+		if err != nil {
+			return n, err
+		}
+		p = p[k:]
+	}
+	return n, nil
+}
+
+// Flush flushes the underlying writer. The delta writer doesn't buffer
+// data itself.
+func (w *deltaWriter) Flush() error {
+	return flushWriter(w.w)
+}
+
+// Close closes the underlying writer.
+func (w *deltaWriter) Close() error {
+	return w.w.Close()
+}
@@ -46,6 +46,10 @@ const HeaderLen = 12
 
 // Constants for the checksum methods supported by xz.
 const (
+	// None selects no check. Since it is zero, it cannot be selected
+	// through the CheckSum field of WriterConfig, whose zero value
+	// selects CRC64. Callers must set NoCheckSum instead.
+	None   byte = 0x0
 	CRC32  byte = 0x1
 	CRC64       = 0x4
 	SHA256      = 0xa
@@ -54,19 +58,23 @@ const (
 // errInvalidFlags indicates that flags are invalid.
 var errInvalidFlags = errors.New("xz: invalid flags")
 
+// ErrUnsupportedCheck indicates that a stream uses a check type that is
+// reserved by the xz specification. Such streams can be read by setting
+// the SkipUnknownCheck field of the ReaderConfig.
+var ErrUnsupportedCheck = errors.New("xz: unsupported check type")
+
 // verifyFlags returns the error errInvalidFlags if the value is
-// invalid.
+// invalid. All 16 check IDs of the specification are accepted.
 func verifyFlags(flags byte) error {
-	switch flags {
-	case CRC32, CRC64, SHA256:
-		return nil
-	default:
+	if flags > 0x0f {
 		return errInvalidFlags
 	}
+	return nil
 }
 
 // flagstrings maps flag values to strings.
 var flagstrings = map[byte]string{
+	None:   "None",
 	CRC32:  "CRC-32",
 	CRC64:  "CRC-64",
 	SHA256: "SHA-256",
@@ -76,15 +84,29 @@ var flagstrings = map[byte]string{
 func flagString(flags byte) string {
 	s, ok := flagstrings[flags]
 	if !ok {
+		if flags <= 0x0f {
+			return fmt.Sprintf("Unknown-%d", flags)
+		}
 		return "invalid"
 	}
 	return s
 }
 
+// checkSize returns the size of the check field for the check ID in
+// flags. The specification defines the size for all check IDs.
+func checkSize(flags byte) int {
+	if flags == 0 {
+		return 0
+	}
+	return 4 << uint((flags-1)/3)
+}
+
 // newHashFunc returns a function that creates hash instances for the
 // hash method encoded in flags.
 func newHashFunc(flags byte) (newHash func() hash.Hash, err error) {
 	switch flags {
+	case None:
+		newHash = newNoneHash
 	case CRC32:
 		newHash = newCRC32
 	case CRC64:
@@ -92,7 +114,10 @@ func newHashFunc(flags byte) (newHash func() hash.Hash, err error) {
 	case SHA256:
 		newHash = sha256.New
 	default:
-		err = errInvalidFlags
+		if err = verifyFlags(flags); err != nil {
+			return nil, err
+		}
+		err = ErrUnsupportedCheck
 	}
 	return
 }
@@ -520,7 +545,8 @@ type filter interface {
 }
 
 // readFilter reads a block filter from the block header. At this point
-// in time only the LZMA2 filter is supported.
+// in time the LZMA2 filter, the delta filter and the BCJ filters are
+// supported.
 func readFilter(r io.Reader) (f filter, err error) {
 	br := lzma.ByteReader(r)
 
@@ -530,15 +556,13 @@ func readFilter(r io.Reader) (f filter, err error) {
 		return nil, err
 	}
 
-	var data []byte
-	switch id {
-	case lzmaFilterID:
-		data = make([]byte, lzmaFilterLen)
-		data[0] = lzmaFilterID
-		if _, err = io.ReadFull(r, data[1:]); err != nil {
-			return nil, err
-		}
+	switch {
+	case id == lzmaFilterID:
 		f = new(lzmaFilter)
+	case id == FilterDelta:
+		f = new(deltaFilter)
+	case isBCJFilter(id):
+		f = new(bcjFilter)
 	default:
 		if id >= minReservedID {
 			return nil, errors.New(
@@ -546,23 +570,47 @@ func readFilter(r io.Reader) (f filter, err error) {
 		}
 		return nil, errors.New("xz: invalid filter id")
 	}
+
+	// size of properties
+	size, _, err := readUvarint(br)
+	if err != nil {
+		return nil, err
+	}
+	if size > maxFilterPropsSize {
+		return nil, errors.New("xz: filter properties too large")
+	}
+
+	data := make([]byte, 20+size)
+	n := putUvarint(data, id)
+	n += putUvarint(data[n:], size)
+	data = data[:n+int(size)]
+	if _, err = io.ReadFull(r, data[n:]); err != nil {
+		return nil, err
+	}
 	if err = f.UnmarshalBinary(data); err != nil {
 		return nil, err
 	}
 	return f, err
 }
 
-// readFilters reads count filters. At this point in time only the count
-// 1 is supported.
+// maxFilterPropsSize limits the size of the filter properties. A block
+// header cannot be longer than 1024 bytes.
+const maxFilterPropsSize = 1024
+
+// readFilters reads count filters.
 func readFilters(r io.Reader, count int) (filters []filter, err error) {
-	if count != 1 {
+	if !(minFilters <= count && count <= maxFilters) {
 		return nil, errors.New("xz: unsupported filter count")
 	}
-	f, err := readFilter(r)
-	if err != nil {
-		return nil, err
+	filters = make([]filter, 0, count)
+	for i := 0; i < count; i++ {
+		f, err := readFilter(r)
+		if err != nil {
+			return nil, err
+		}
+		filters = append(filters, f)
 	}
-	return []filter{f}, err
+	return filters, nil
 }
 
 // writeFilters writes the filters.
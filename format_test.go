@@ -140,3 +140,34 @@ func TestBlockHeader(t *testing.T) {
 		t.Errorf("got dictCap %d; want %d", glf.dictCap, hlf.dictCap)
 	}
 }
+
+func TestBlockHeaderBCJ(t *testing.T) {
+	h := blockHeader{
+		compressedSize:   -1,
+		uncompressedSize: -1,
+		filters: []filter{
+			&bcjFilter{filterID: FilterARM64, start: 0x1000},
+			&bcjFilter{filterID: FilterX86},
+			&lzmaFilter{4096},
+		},
+	}
+	data, err := h.MarshalBinary()
+	if err != nil {
+		t.Fatalf("MarshalBinary error %s", err)
+	}
+	g, _, err := readBlockHeader(bytes.NewReader(data))
+	if err != nil {
+		t.Fatalf("readBlockHeader error %s", err)
+	}
+	if len(g.filters) != len(h.filters) {
+		t.Fatalf("got len(filters) %d; want %d",
+			len(g.filters), len(h.filters))
+	}
+	for i := 0; i < 2; i++ {
+		gf := g.filters[i].(*bcjFilter)
+		hf := h.filters[i].(*bcjFilter)
+		if *gf != *hf {
+			t.Errorf("filter %d: got %v; want %v", i, gf, hf)
+		}
+	}
+}
@@ -0,0 +1,200 @@
+// Copyright 2014-2017 Ulrich Kunitz. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package xz
+
+import (
+	"bufio"
+	"errors"
+	"io"
+)
+
+// streamIndex describes the position of an xz stream and the records of
+// its index.
+type streamIndex struct {
+	// offset of the stream header
+	offset int64
+	// flags of the stream header and footer
+	flags byte
+	// records of the stream index
+	records []record
+	// size of the index including the index indicator
+	indexSize int64
+	// length of the stream padding following the stream
+	padding int64
+}
+
+// paddedSize returns the size of the block including the block padding.
+func (rec record) paddedSize() int64 {
+	return rec.unpaddedSize + int64(padLen(rec.unpaddedSize))
+}
+
+// errIndexScan indicates that the streams of an xz file couldn't be
+// located by reading the footers and indexes from the end.
+var errIndexScan = errors.New("xz: streams can't be located from the end")
+
+// readAtFull reads exactly len(p) bytes at offset off.
+func readAtFull(ra io.ReaderAt, p []byte, off int64) error {
+	n, err := ra.ReadAt(p, off)
+	if n == len(p) {
+		return nil
+	}
+	if err == nil || err == io.EOF {
+		err = io.ErrUnexpectedEOF
+	}
+	return err
+}
+
+// readStreamIndexes locates all streams of an xz file with the given
+// size by reading footers and indexes starting at the end of the file.
+// The stream indexes are returned in file order.
+func readStreamIndexes(ra io.ReaderAt, size int64) (streams []streamIndex,
+	err error) {
+
+	if size%4 != 0 {
+		return nil, errIndexScan
+	}
+	pos := size
+	var padding int64
+	p := make([]byte, footerLen)
+	for pos > 0 {
+		if pos < HeaderLen+footerLen {
+			return nil, errIndexScan
+		}
+
+		// stream padding
+		if err = readAtFull(ra, p[:4], pos-4); err != nil {
+			return nil, err
+		}
+		if allZeros(p[:4]) {
+			pos -= 4
+			padding += 4
+			continue
+		}
+
+		// footer
+		if err = readAtFull(ra, p, pos-footerLen); err != nil {
+			return nil, err
+		}
+		var f footer
+		if err = f.UnmarshalBinary(p); err != nil {
+			return nil, err
+		}
+
+		// index
+		s := streamIndex{flags: f.flags, indexSize: f.indexSize,
+			padding: padding}
+		indexStart := pos - footerLen - f.indexSize
+		if indexStart < HeaderLen {
+			return nil, errIndexScan
+		}
+		if err = readAtFull(ra, p[:1], indexStart); err != nil {
+			return nil, err
+		}
+		if p[0] != 0 {
+			return nil, errIndexScan
+		}
+		ir := bufio.NewReader(io.NewSectionReader(ra, indexStart+1,
+			f.indexSize-1))
+		var n int64
+		s.records, n, err = readIndexBody(ir)
+		if err != nil {
+			if err == io.EOF {
+				err = io.ErrUnexpectedEOF
+			}
+			return nil, err
+		}
+		if n+1 != f.indexSize {
+			return nil, errors.New("xz: index size in footer wrong")
+		}
+
+		// blocks
+		var blocksSize int64
+		for _, rec := range s.records {
+			if rec.unpaddedSize <= 0 ||
+				rec.unpaddedSize > indexStart {
+				return nil, errIndexScan
+			}
+			blocksSize += rec.paddedSize()
+			if blocksSize > indexStart {
+				return nil, errIndexScan
+			}
+		}
+
+		// header
+		s.offset = indexStart - blocksSize - HeaderLen
+		if s.offset < 0 {
+			return nil, errIndexScan
+		}
+		if err = readAtFull(ra, p[:HeaderLen], s.offset); err != nil {
+			return nil, err
+		}
+		var h header
+		if err = h.UnmarshalBinary(p[:HeaderLen]); err != nil {
+			return nil, err
+		}
+		if h.flags != f.flags {
+			return nil, errors.New("xz: footer flags incorrect")
+		}
+
+		streams = append(streams, s)
+		pos = s.offset
+		padding = 0
+	}
+	if padding > 0 {
+		return nil, errIndexScan
+	}
+
+	// reverse the order
+	for i, j := 0, len(streams)-1; i < j; i, j = i+1, j-1 {
+		streams[i], streams[j] = streams[j], streams[i]
+	}
+	return streams, nil
+}
+
+// seekReaderAt implements io.ReaderAt for an io.ReadSeeker. It changes
+// the offset of the ReadSeeker and must not be used concurrently.
+type seekReaderAt struct {
+	rs io.ReadSeeker
+}
+
+// ReadAt reads len(p) bytes at offset off.
+func (s seekReaderAt) ReadAt(p []byte, off int64) (n int, err error) {
+	if _, err = s.rs.Seek(off, io.SeekStart); err != nil {
+		return 0, err
+	}
+	return io.ReadFull(s.rs, p)
+}
+
+// withReaderAt calls f with a ReaderAt for the data of rs from the
+// current offset to the end and the size of the data. The offset of rs
+// is restored before the function returns.
+func withReaderAt(rs io.ReadSeeker, f func(ra io.ReaderAt, size int64) error,
+) error {
+	start, err := rs.Seek(0, io.SeekCurrent)
+	if err != nil {
+		return err
+	}
+	end, err := rs.Seek(0, io.SeekEnd)
+	if err != nil {
+		return err
+	}
+	ra := io.NewSectionReader(seekReaderAt{rs}, start, end-start)
+	err = f(ra, end-start)
+	if _, serr := rs.Seek(start, io.SeekStart); serr != nil {
+		return serr
+	}
+	return err
+}
+
+// scanIndexes reads the stream indexes of a seekable input starting at
+// the current offset. The offset is restored before the function
+// returns.
+func scanIndexes(rs io.ReadSeeker) (streams []streamIndex, err error) {
+	err = withReaderAt(rs, func(ra io.ReaderAt, size int64) error {
+		streams, err = readStreamIndexes(ra, size)
+		return err
+	})
+	return streams, err
+}
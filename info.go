@@ -0,0 +1,220 @@
+// Copyright 2014-2017 Ulrich Kunitz. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package xz
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+)
+
+// StreamInfo describes an xz stream in a file.
+type StreamInfo struct {
+	// offset of the stream header in the file
+	Offset int64
+	// offset of the stream data in the uncompressed data of the file
+	UncompressedOffset int64
+	// check type of the stream: None, CRC32, CRC64, SHA256 or a
+	// reserved check ID
+	CheckType byte
+	// size of the stream from the start of the stream header to
+	// the end of the stream footer
+	CompressedSize int64
+	// size of the uncompressed data of the stream
+	UncompressedSize int64
+	// size of the index including the index indicator
+	IndexSize int64
+	// number of padding bytes following the stream
+	Padding int64
+	// blocks of the stream
+	Blocks []BlockInfo
+}
+
+// CheckName returns the name of the check type as used by the xz tool.
+func (s *StreamInfo) CheckName() string {
+	return flagString(s.CheckType)
+}
+
+// BlockInfo describes a block of an xz stream.
+type BlockInfo struct {
+	// offset of the block header in the file
+	Offset int64
+	// offset of the block data in the uncompressed data of the file
+	UncompressedOffset int64
+	// size of the block header
+	HeaderSize int
+	// size of the compressed data without header, padding and check
+	CompressedSize int64
+	// size of the uncompressed data
+	UncompressedSize int64
+	// size of header, compressed data and check as stored in the
+	// index
+	UnpaddedSize int64
+	// size of the check field
+	CheckSize int
+	// value of the check field
+	Check []byte
+	// CompressedSizeStored and UncompressedSizeStored report whether
+	// the block header contains the respective size
+	CompressedSizeStored   bool
+	UncompressedSizeStored bool
+	// filter chain of the block
+	Filters []FilterConfig
+}
+
+// String returns a description of the filter using the option syntax
+// of the xz tool, for instance lzma2=dict=8MiB or delta=dist=2.
+func (fc FilterConfig) String() string {
+	switch {
+	case fc.ID == FilterLZMA2:
+		return "lzma2=dict=" + sizeString(int64(fc.DictCap))
+	case fc.ID == FilterDelta:
+		return fmt.Sprintf("delta=dist=%d", fc.Dist)
+	case isBCJFilter(fc.ID):
+		s := bcjOptionNames[fc.ID]
+		if fc.StartOffset != 0 {
+			s += fmt.Sprintf("=start=%d", fc.StartOffset)
+		}
+		return s
+	}
+	return fmt.Sprintf("filter-%#x", fc.ID)
+}
+
+// bcjOptionNames provides the names of the BCJ filters used by the xz
+// tool.
+var bcjOptionNames = map[uint64]string{
+	FilterX86:      "x86",
+	FilterPowerPC:  "powerpc",
+	FilterIA64:     "ia64",
+	FilterARM:      "arm",
+	FilterARMThumb: "armthumb",
+	FilterSPARC:    "sparc",
+	FilterARM64:    "arm64",
+	FilterRISCV:    "riscv",
+}
+
+// sizeString formats a size using the suffixes KiB and MiB if the size
+// is a multiple of them.
+func sizeString(n int64) string {
+	switch {
+	case n > 0 && n%(1<<20) == 0:
+		return fmt.Sprintf("%dMiB", n>>20)
+	case n > 0 && n%(1<<10) == 0:
+		return fmt.Sprintf("%dKiB", n>>10)
+	}
+	return fmt.Sprintf("%dB", n)
+}
+
+// filterConfig returns the configuration for a filter of a block header.
+func filterConfig(f filter) FilterConfig {
+	switch f := f.(type) {
+	case *lzmaFilter:
+		return FilterConfig{ID: FilterLZMA2, DictCap: int(f.dictCap)}
+	case *deltaFilter:
+		return FilterConfig{ID: FilterDelta, Dist: f.dist}
+	case *bcjFilter:
+		return FilterConfig{ID: f.filterID, StartOffset: f.start}
+	}
+	return FilterConfig{ID: f.id()}
+}
+
+// Inspect returns the information about all streams and blocks of the
+// xz file provided by ra with the given size. Only the stream headers,
+// block headers, block checks, indexes and footers are read; no data
+// is decompressed.
+func Inspect(ra io.ReaderAt, size int64) (streams []StreamInfo, err error) {
+	indexes, err := readStreamIndexes(ra, size)
+	if err != nil {
+		return nil, err
+	}
+	streams = make([]StreamInfo, 0, len(indexes))
+	var uoff int64
+	for _, s := range indexes {
+		si := StreamInfo{
+			Offset:             s.offset,
+			UncompressedOffset: uoff,
+			CheckType:          s.flags,
+			IndexSize:          s.indexSize,
+			Padding:            s.padding,
+			Blocks:             make([]BlockInfo, 0, len(s.records)),
+		}
+		off := s.offset + HeaderLen
+		for _, rec := range s.records {
+			b, err := inspectBlock(ra, off, rec, checkSize(s.flags))
+			if err != nil {
+				return nil, err
+			}
+			b.UncompressedOffset = uoff
+			si.Blocks = append(si.Blocks, b)
+			si.UncompressedSize += rec.uncompressedSize
+			uoff += rec.uncompressedSize
+			off += rec.paddedSize()
+		}
+		si.CompressedSize = off + s.indexSize + footerLen - s.offset
+		streams = append(streams, si)
+	}
+	return streams, nil
+}
+
+// inspectBlock reads the header and the check of the block at offset
+// off and combines them with the index record.
+func inspectBlock(ra io.ReaderAt, off int64, rec record, checkSize int,
+) (b BlockInfo, err error) {
+
+	xz := bufio.NewReader(io.NewSectionReader(ra, off, rec.paddedSize()))
+	h, hlen, err := readBlockHeader(xz)
+	if err != nil {
+		if err == errIndexIndicator {
+			err = errIndex
+		}
+		return b, err
+	}
+	b = BlockInfo{
+		Offset:           off,
+		HeaderSize:       hlen,
+		CompressedSize:   rec.unpaddedSize - int64(hlen+checkSize),
+		UncompressedSize: rec.uncompressedSize,
+		UnpaddedSize:     rec.unpaddedSize,
+		CheckSize:        checkSize,
+		Check:            make([]byte, checkSize),
+		Filters:          make([]FilterConfig, len(h.filters)),
+
+		CompressedSizeStored:   h.compressedSize >= 0,
+		UncompressedSizeStored: h.uncompressedSize >= 0,
+	}
+	if b.CompressedSize <= 0 {
+		return b, errIndex
+	}
+	if h.compressedSize >= 0 && h.compressedSize != b.CompressedSize {
+		return b, errors.New("xz: compressed size in block header " +
+			"doesn't match index")
+	}
+	if h.uncompressedSize >= 0 &&
+		h.uncompressedSize != b.UncompressedSize {
+		return b, errors.New("xz: uncompressed size in block header " +
+			"doesn't match index")
+	}
+	for i, f := range h.filters {
+		b.Filters[i] = filterConfig(f)
+	}
+	err = readAtFull(ra, b.Check, off+rec.paddedSize()-int64(checkSize))
+	if err != nil {
+		return b, err
+	}
+	return b, nil
+}
+
+// InspectSeeker returns the information about all streams and blocks
+// of the xz file starting at the current offset of rs. The offset is
+// restored before the function returns. Offsets in the returned
+// information are relative to the start offset.
+func InspectSeeker(rs io.ReadSeeker) (streams []StreamInfo, err error) {
+	err = withReaderAt(rs, func(ra io.ReaderAt, size int64) error {
+		streams, err = Inspect(ra, size)
+		return err
+	})
+	return streams, err
+}
@@ -0,0 +1,123 @@
+// Copyright 2014-2017 Ulrich Kunitz. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package xz
+
+import (
+	"bytes"
+	"io"
+	"math/rand"
+	"os"
+	"testing"
+
+	"github.com/ulikunitz/xz/internal/randtxt"
+)
+
+func TestInspectFox(t *testing.T) {
+	const file = "fox.xz"
+	f, err := os.Open(file)
+	if err != nil {
+		t.Fatalf("os.Open(%q) error %s", file, err)
+	}
+	defer f.Close()
+	streams, err := InspectSeeker(f)
+	if err != nil {
+		t.Fatalf("InspectSeeker error %s", err)
+	}
+	if len(streams) != 1 {
+		t.Fatalf("got %d streams; want 1", len(streams))
+	}
+	s := streams[0]
+	fi, err := f.Stat()
+	if err != nil {
+		t.Fatalf("f.Stat error %s", err)
+	}
+	if s.CompressedSize+s.Padding != fi.Size() {
+		t.Errorf("stream size %d; want %d", s.CompressedSize+s.Padding,
+			fi.Size())
+	}
+	if len(s.Blocks) != 1 {
+		t.Fatalf("got %d blocks; want 1", len(s.Blocks))
+	}
+	b := s.Blocks[0]
+	if b.Offset != HeaderLen {
+		t.Errorf("block offset %d; want %d", b.Offset, HeaderLen)
+	}
+	if len(b.Filters) != 1 || b.Filters[0].ID != FilterLZMA2 {
+		t.Errorf("got filters %v; want LZMA2 filter", b.Filters)
+	}
+	if pos, _ := f.Seek(0, io.SeekCurrent); pos != 0 {
+		t.Errorf("file offset %d after InspectSeeker; want 0", pos)
+	}
+}
+
+func TestInspect(t *testing.T) {
+	const txtlen = 30000
+	var buf bytes.Buffer
+	io.CopyN(&buf, randtxt.NewReader(rand.NewSource(48)), txtlen)
+	txt := buf.Bytes()
+
+	var xz bytes.Buffer
+	configs := []WriterConfig{
+		{CheckSum: SHA256, BlockSize: 1 << 14},
+		{NoCheckSum: true, Filters: []FilterConfig{
+			{ID: FilterDelta, Dist: 3},
+			{ID: FilterARM},
+			{ID: FilterLZMA2},
+		}},
+	}
+	for _, cfg := range configs {
+		w, err := cfg.NewWriter(&xz)
+		if err != nil {
+			t.Fatalf("NewWriter error %s", err)
+		}
+		if _, err = w.Write(txt); err != nil {
+			t.Fatalf("w.Write error %s", err)
+		}
+		if err = w.Close(); err != nil {
+			t.Fatalf("w.Close error %s", err)
+		}
+		xz.Write(make([]byte, 4))
+	}
+
+	streams, err := Inspect(bytes.NewReader(xz.Bytes()), int64(xz.Len()))
+	if err != nil {
+		t.Fatalf("Inspect error %s", err)
+	}
+	if len(streams) != 2 {
+		t.Fatalf("got %d streams; want 2", len(streams))
+	}
+	s0, s1 := streams[0], streams[1]
+	if s0.CheckName() != "SHA-256" || s1.CheckName() != "None" {
+		t.Errorf("got checks %s and %s", s0.CheckName(), s1.CheckName())
+	}
+	if s1.Offset != s0.CompressedSize+s0.Padding || s1.Padding != 4 {
+		t.Errorf("second stream offset %d padding %d", s1.Offset,
+			s1.Padding)
+	}
+	if len(s0.Blocks) != 2 {
+		t.Fatalf("got %d blocks; want 2", len(s0.Blocks))
+	}
+	b := s0.Blocks[1]
+	if b.UncompressedOffset != 1<<14 ||
+		b.UncompressedSize != txtlen-1<<14 || b.CheckSize != 32 {
+		t.Errorf("unexpected second block %+v", b)
+	}
+	if len(b.Check) != 32 || len(s1.Blocks[0].Check) != 0 {
+		t.Errorf("got check values %x and %x", b.Check,
+			s1.Blocks[0].Check)
+	}
+	if b.CompressedSizeStored || b.UncompressedSizeStored {
+		t.Errorf("sizes reported as stored in block header")
+	}
+	if b.Offset != s0.Blocks[0].Offset+s0.Blocks[0].UnpaddedSize+
+		int64(padLen(s0.Blocks[0].UnpaddedSize)) {
+		t.Errorf("second block offset %d", b.Offset)
+	}
+	f := s1.Blocks[0].Filters
+	if len(f) != 3 || f[0].String() != "delta=dist=3" ||
+		f[1].String() != "arm" || f[2].String() != "lzma2=dict=8MiB" {
+		t.Errorf("got filters %v", f)
+	}
+}
@@ -0,0 +1,243 @@
+// Copyright 2014-2017 Ulrich Kunitz. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package bcj
+
+import "encoding/binary"
+
+// Converter converts the branch instructions in a byte slice in place.
+// The argument pos gives the position of p[0] in the stream. Convert
+// returns the number of bytes processed. The unprocessed bytes at the
+// end of p must be provided again together with the following data. At
+// the end of the stream the unprocessed bytes remain unchanged.
+type Converter interface {
+	Convert(p []byte, pos uint32) int
+}
+
+// convertFunc is the type of the functions implementing stateless
+// converters.
+type convertFunc func(p []byte, pos uint32, encoder bool) int
+
+// simple provides a Converter for a stateless conversion function.
+type simple struct {
+	f       convertFunc
+	encoder bool
+}
+
+// Convert converts the branch instructions in p.
+func (s simple) Convert(p []byte, pos uint32) int {
+	return s.f(p, pos, s.encoder)
+}
+
+// NewPowerPC returns the converter for PowerPC code. The encoder flag
+// selects the conversion direction.
+func NewPowerPC(encoder bool) Converter { return simple{powerPC, encoder} }
+
+// NewIA64 returns the converter for IA-64 (Itanium) code.
+func NewIA64(encoder bool) Converter { return simple{ia64, encoder} }
+
+// NewARM returns the converter for 32-bit ARM code.
+func NewARM(encoder bool) Converter { return simple{arm, encoder} }
+
+// NewARMThumb returns the converter for ARM Thumb code.
+func NewARMThumb(encoder bool) Converter { return simple{armThumb, encoder} }
+
+// NewSPARC returns the converter for SPARC code.
+func NewSPARC(encoder bool) Converter { return simple{sparc, encoder} }
+
+// NewARM64 returns the converter for ARM64 code.
+func NewARM64(encoder bool) Converter { return simple{arm64, encoder} }
+
+// NewRISCV returns the converter for RISC-V code.
+func NewRISCV(encoder bool) Converter {
+	if encoder {
+		return simple{riscvEncode, true}
+	}
+	return simple{riscvDecode, false}
+}
+
+// powerPC converts big-endian PowerPC branch instructions with the link
+// bit set.
+func powerPC(p []byte, pos uint32, encoder bool) int {
+	var i int
+	for i = 0; i+4 <= len(p); i += 4 {
+		if p[i]>>2 != 0x12 || p[i+3]&3 != 1 {
+			continue
+		}
+		src := binary.BigEndian.Uint32(p[i:]) & 0x03fffffc
+		var dest uint32
+		if encoder {
+			dest = pos + uint32(i) + src
+		} else {
+			dest = src - (pos + uint32(i))
+		}
+		p[i] = 0x48 | byte((dest>>24)&3)
+		p[i+1] = byte(dest >> 16)
+		p[i+2] = byte(dest >> 8)
+		p[i+3] = p[i+3]&3 | byte(dest)
+	}
+	return i
+}
+
+// ia64BranchTable maps the instruction template of an IA-64 bundle to
+// the slots that may contain branch instructions.
+var ia64BranchTable = [32]uint32{
+	0, 0, 0, 0, 0, 0, 0, 0,
+	0, 0, 0, 0, 0, 0, 0, 0,
+	4, 4, 6, 6, 0, 0, 7, 7,
+	4, 4, 0, 0, 4, 4, 0, 0,
+}
+
+// ia64 converts the branch instructions in the 16-byte bundles of
+// IA-64 code.
+func ia64(p []byte, pos uint32, encoder bool) int {
+	var i int
+	for i = 0; i+16 <= len(p); i += 16 {
+		mask := ia64BranchTable[p[i]&0x1f]
+		bitPos := uint32(5)
+		for slot := uint32(0); slot < 3; slot, bitPos = slot+1, bitPos+41 {
+			if (mask>>slot)&1 == 0 {
+				continue
+			}
+			bytePos := int(bitPos >> 3)
+			bitRes := bitPos & 7
+			var instr uint64
+			for j := 0; j < 6; j++ {
+				instr |= uint64(p[i+j+bytePos]) << (8 * uint(j))
+			}
+			norm := instr >> bitRes
+			if (norm>>37)&0xf != 0x5 || (norm>>9)&0x7 != 0 {
+				continue
+			}
+			src := uint32((norm >> 13) & 0xfffff)
+			src |= uint32((norm>>36)&1) << 20
+			src <<= 4
+			var dest uint32
+			if encoder {
+				dest = pos + uint32(i) + src
+			} else {
+				dest = src - (pos + uint32(i))
+			}
+			dest >>= 4
+			norm &^= uint64(0x8fffff) << 13
+			norm |= uint64(dest&0xfffff) << 13
+			norm |= uint64(dest&0x100000) << (36 - 20)
+			instr &= 1<<bitRes - 1
+			instr |= norm << bitRes
+			for j := 0; j < 6; j++ {
+				p[i+j+bytePos] = byte(instr >> (8 * uint(j)))
+			}
+		}
+	}
+	return i
+}
+
+// arm converts the BL instructions of 32-bit ARM code.
+func arm(p []byte, pos uint32, encoder bool) int {
+	var i int
+	for i = 0; i+4 <= len(p); i += 4 {
+		if p[i+3] != 0xeb {
+			continue
+		}
+		src := (uint32(p[i+2])<<16 | uint32(p[i+1])<<8 |
+			uint32(p[i])) << 2
+		var dest uint32
+		if encoder {
+			dest = pos + uint32(i) + 8 + src
+		} else {
+			dest = src - (pos + uint32(i) + 8)
+		}
+		dest >>= 2
+		p[i+2] = byte(dest >> 16)
+		p[i+1] = byte(dest >> 8)
+		p[i] = byte(dest)
+	}
+	return i
+}
+
+// armThumb converts the BL instruction pairs of ARM Thumb code.
+func armThumb(p []byte, pos uint32, encoder bool) int {
+	var i int
+	for i = 0; i+4 <= len(p); i += 2 {
+		if p[i+1]&0xf8 != 0xf0 || p[i+3]&0xf8 != 0xf8 {
+			continue
+		}
+		src := (uint32(p[i+1])&7)<<19 | uint32(p[i])<<11 |
+			(uint32(p[i+3])&7)<<8 | uint32(p[i+2])
+		src <<= 1
+		var dest uint32
+		if encoder {
+			dest = pos + uint32(i) + 4 + src
+		} else {
+			dest = src - (pos + uint32(i) + 4)
+		}
+		dest >>= 1
+		p[i+1] = 0xf0 | byte((dest>>19)&7)
+		p[i] = byte(dest >> 11)
+		p[i+3] = 0xf8 | byte((dest>>8)&7)
+		p[i+2] = byte(dest)
+		i += 2
+	}
+	return i
+}
+
+// sparc converts the call instructions of SPARC code.
+func sparc(p []byte, pos uint32, encoder bool) int {
+	var i int
+	for i = 0; i+4 <= len(p); i += 4 {
+		if !(p[i] == 0x40 && p[i+1]&0xc0 == 0x00) &&
+			!(p[i] == 0x7f && p[i+1]&0xc0 == 0xc0) {
+			continue
+		}
+		src := binary.BigEndian.Uint32(p[i:]) << 2
+		var dest uint32
+		if encoder {
+			dest = pos + uint32(i) + src
+		} else {
+			dest = src - (pos + uint32(i))
+		}
+		dest >>= 2
+		dest = ((0-(dest>>22)&1)<<22)&0x3fffffff |
+			dest&0x3fffff | 0x40000000
+		binary.BigEndian.PutUint32(p[i:], dest)
+	}
+	return i
+}
+
+// arm64 converts the BL and ADRP instructions of ARM64 code.
+func arm64(p []byte, pos uint32, encoder bool) int {
+	var i int
+	for i = 0; i+4 <= len(p); i += 4 {
+		pc := pos + uint32(i)
+		instr := binary.LittleEndian.Uint32(p[i:])
+		if instr>>26 == 0x25 {
+			// BL
+			pc >>= 2
+			if !encoder {
+				pc = -pc
+			}
+			instr = 0x94000000 | (instr+pc)&0x03ffffff
+			binary.LittleEndian.PutUint32(p[i:], instr)
+		} else if instr&0x9f000000 == 0x90000000 {
+			// ADRP
+			src := (instr>>29)&3 | (instr>>3)&0x001ffffc
+			// Only addresses in the range +/-512 MiB are
+			// converted.
+			if (src+0x00020000)&0x001c0000 != 0 {
+				continue
+			}
+			pc >>= 12
+			if !encoder {
+				pc = -pc
+			}
+			dest := src + pc
+			instr &= 0x9000001f
+			instr |= (dest & 3) << 29
+			instr |= (dest & 0x0003fffc) << 3
+			instr |= (-(dest & 0x00020000)) & 0x00e00000
+			binary.LittleEndian.PutUint32(p[i:], instr)
+		}
+	}
+	return i
+}
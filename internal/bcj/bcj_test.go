@@ -0,0 +1,94 @@
+// Copyright 2014-2017 Ulrich Kunitz. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package bcj
+
+import (
+	"bytes"
+	"io"
+	"io/ioutil"
+	"math/rand"
+	"testing"
+)
+
+// codeBytes generates data that contains many byte sequences looking
+// like branch instructions.
+func codeBytes(n int, seed int64) []byte {
+	alphabet := []byte{0x00, 0xff, 0xe8, 0xe9, 0xeb, 0x48, 0x49, 0x40,
+		0x7f, 0xc0, 0xef, 0x17, 0x97, 0x94, 0x90, 0xf0, 0xf8, 0x05,
+		0x10, 0x13}
+	r := rand.New(rand.NewSource(seed))
+	p := make([]byte, n)
+	for i := range p {
+		if r.Intn(4) == 0 {
+			p[i] = byte(r.Intn(256))
+		} else {
+			p[i] = alphabet[r.Intn(len(alphabet))]
+		}
+	}
+	return p
+}
+
+type nopCloser struct{ io.Writer }
+
+func (nopCloser) Close() error { return nil }
+
+// encode converts data writing it in chunks of the given size.
+func encode(t *testing.T, c Converter, data []byte, pos uint32,
+	chunk int) []byte {
+
+	var buf bytes.Buffer
+	w := NewWriter(nopCloser{&buf}, c, pos)
+	for p := data; len(p) > 0; {
+		k := chunk
+		if k > len(p) {
+			k = len(p)
+		}
+		if _, err := w.Write(p[:k]); err != nil {
+			t.Fatalf("w.Write error %s", err)
+		}
+		p = p[k:]
+	}
+	if err := w.Close(); err != nil {
+		t.Fatalf("w.Close error %s", err)
+	}
+	return buf.Bytes()
+}
+
+func TestConverters(t *testing.T) {
+	tests := []struct {
+		name string
+		new  func(encoder bool) Converter
+		pos  uint32
+	}{
+		{"x86", NewX86, 0},
+		{"x86", NewX86, 7},
+		{"PowerPC", NewPowerPC, 0},
+		{"IA64", NewIA64, 16},
+		{"ARM", NewARM, 0},
+		{"ARMThumb", NewARMThumb, 2},
+		{"SPARC", NewSPARC, 0},
+		{"ARM64", NewARM64, 4096},
+		{"RISCV", NewRISCV, 0},
+	}
+	data := codeBytes(200000, 1)
+	for _, tc := range tests {
+		enc := encode(t, tc.new(true), data, tc.pos, len(data))
+		if bytes.Equal(enc, data) {
+			t.Errorf("%s: no instruction converted", tc.name)
+		}
+		enc2 := encode(t, tc.new(true), data, tc.pos, 4093)
+		if !bytes.Equal(enc, enc2) {
+			t.Errorf("%s: encoding depends on write size", tc.name)
+		}
+		r := NewReader(bytes.NewReader(enc), tc.new(false), tc.pos)
+		dec, err := ioutil.ReadAll(r)
+		if err != nil {
+			t.Fatalf("%s: ReadAll error %s", tc.name, err)
+		}
+		if !bytes.Equal(dec, data) {
+			t.Errorf("%s: decoded data differs", tc.name)
+		}
+	}
+}
@@ -0,0 +1,18 @@
+// Copyright 2014-2017 Ulrich Kunitz. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+/*
+Package bcj provides the branch/call/jump converters of the xz format.
+
+The converters replace the relative addresses of branch instructions in
+executable code with absolute addresses. Calls of the same function at
+different positions result then in identical byte sequences that can be
+compressed better. The conversion is reversible and can be applied to
+arbitrary data.
+
+The package supports the converters for x86, PowerPC, IA-64, ARM, ARM
+Thumb, SPARC, ARM64 and RISC-V code. They are compatible with the
+filters of the xz tool.
+*/
+package bcj
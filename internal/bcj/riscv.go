@@ -0,0 +1,145 @@
+// Copyright 2014-2017 Ulrich Kunitz. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package bcj
+
+import "encoding/binary"
+
+// The RISC-V converter handles JAL instructions with rd x1 or x5 and
+// AUIPC instructions followed by an instruction using the AUIPC result
+// as rs1. Such pairs are stored in a special format: an AUIPC with rd
+// x2 containing the lower 20 bits of the second instruction followed by
+// the absolute address in big-endian byte order. Byte sequences looking
+// like the special format are converted with a simplified scheme to
+// keep the conversion reversible for arbitrary data.
+
+// notAUIPCPair checks whether the rd of the AUIPC differs from the rs1
+// of the second instruction or the second instruction is compressed.
+func notAUIPCPair(auipc, inst2 uint32) bool {
+	return ((auipc<<8)^(inst2-3))&0xf8003 != 0
+}
+
+// notSpecialAUIPC checks whether the AUIPC doesn't have the special
+// format: rd x2, the lowest opcode bits of the packed instruction set
+// and the packed rs1 is neither x0 nor x2.
+func notSpecialAUIPC(auipc, rs1 uint32) bool {
+	return (auipc-0x3117)<<18 >= rs1&0x1d
+}
+
+// riscvEncode converts RISC-V code for compression.
+func riscvEncode(p []byte, pos uint32, encoder bool) int {
+	if len(p) < 8 {
+		return 0
+	}
+	size := len(p) - 8
+	var i int
+	for i = 0; i <= size; i += 2 {
+		inst := uint32(p[i])
+		if inst == 0xef {
+			// JAL
+			b1 := uint32(p[i+1])
+			if b1&0x0d != 0 {
+				continue
+			}
+			b2 := uint32(p[i+2])
+			b3 := uint32(p[i+3])
+			addr := (b1&0xf0)<<8 | (b2&0x0f)<<16 | (b2&0x10)<<7 |
+				(b2&0xe0)>>4 | (b3&0x7f)<<4 | (b3&0x80)<<13
+			addr += pos + uint32(i)
+			p[i+1] = byte(b1&0x0f | (addr>>13)&0xf0)
+			p[i+2] = byte(addr >> 9)
+			p[i+3] = byte(addr >> 1)
+			i += 4 - 2
+		} else if inst&0x7f == 0x17 {
+			// AUIPC
+			inst = binary.LittleEndian.Uint32(p[i:])
+			if inst&0xe80 != 0 {
+				// rd is neither x0 nor x2
+				inst2 := binary.LittleEndian.Uint32(p[i+4:])
+				if notAUIPCPair(inst, inst2) {
+					i += 6 - 2
+					continue
+				}
+				addr := inst & 0xfffff000
+				addr += (inst2 >> 20) - ((inst2 >> 19) & 0x1000)
+				addr += pos + uint32(i)
+				inst = 0x17 | 2<<7 | inst2<<12
+				binary.LittleEndian.PutUint32(p[i:], inst)
+				binary.BigEndian.PutUint32(p[i+4:], addr)
+			} else {
+				// rd is x0 or x2
+				rs1 := inst >> 27
+				if notSpecialAUIPC(inst, rs1) {
+					i += 4 - 2
+					continue
+				}
+				addr := binary.LittleEndian.Uint32(p[i+4:])
+				inst2 := inst>>12 | addr<<20
+				inst = 0x17 | rs1<<7 | addr&0xfffff000
+				binary.LittleEndian.PutUint32(p[i:], inst)
+				binary.LittleEndian.PutUint32(p[i+4:], inst2)
+			}
+			i += 8 - 2
+		}
+	}
+	return i
+}
+
+// riscvDecode reverses the conversion of riscvEncode.
+func riscvDecode(p []byte, pos uint32, encoder bool) int {
+	if len(p) < 8 {
+		return 0
+	}
+	size := len(p) - 8
+	var i int
+	for i = 0; i <= size; i += 2 {
+		inst := uint32(p[i])
+		if inst == 0xef {
+			// JAL
+			b1 := uint32(p[i+1])
+			if b1&0x0d != 0 {
+				continue
+			}
+			b2 := uint32(p[i+2])
+			b3 := uint32(p[i+3])
+			addr := (b1&0xf0)<<13 | b2<<9 | b3<<1
+			addr -= pos + uint32(i)
+			p[i+1] = byte(b1&0x0f | (addr>>8)&0xf0)
+			p[i+2] = byte((addr>>16)&0x0f | (addr>>7)&0x10 |
+				(addr<<4)&0xe0)
+			p[i+3] = byte((addr>>4)&0x7f | (addr>>13)&0x80)
+			i += 4 - 2
+		} else if inst&0x7f == 0x17 {
+			// AUIPC
+			var inst2 uint32
+			inst = binary.LittleEndian.Uint32(p[i:])
+			if inst&0xe80 != 0 {
+				// rd is neither x0 nor x2
+				inst2 = binary.LittleEndian.Uint32(p[i+4:])
+				if notAUIPCPair(inst, inst2) {
+					i += 6 - 2
+					continue
+				}
+				addr := inst&0xfffff000 + inst2>>20
+				inst = 0x17 | 2<<7 | inst2<<12
+				inst2 = addr
+			} else {
+				// rd is x0 or x2
+				rs1 := inst >> 27
+				if notSpecialAUIPC(inst, rs1) {
+					i += 4 - 2
+					continue
+				}
+				addr := binary.BigEndian.Uint32(p[i+4:])
+				addr -= pos + uint32(i)
+				inst2 = inst>>12 | addr<<20
+				inst = 0x17 | rs1<<7 | (addr+0x800)&0xfffff000
+			}
+			binary.LittleEndian.PutUint32(p[i:], inst)
+			binary.LittleEndian.PutUint32(p[i+4:], inst2)
+			i += 8 - 2
+		}
+	}
+	return i
+}
@@ -0,0 +1,141 @@
+// Copyright 2014-2017 Ulrich Kunitz. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package bcj
+
+import (
+	"errors"
+	"io"
+)
+
+// bufSize defines the size of the buffers used by Reader and Writer.
+const bufSize = 1 << 16
+
+// Reader applies a Converter to the data read from an underlying
+// reader.
+type Reader struct {
+	r   io.Reader
+	c   Converter
+	pos uint32
+	buf []byte
+	// buf[head:conv] contains converted data; buf[conv:tail]
+	// unprocessed data
+	head, conv, tail int
+	err              error
+}
+
+// NewReader creates a reader that converts the data from r. The
+// argument pos provides the position of the first byte.
+func NewReader(r io.Reader, c Converter, pos uint32) *Reader {
+	return &Reader{r: r, c: c, pos: pos, buf: make([]byte, bufSize)}
+}
+
+// Read reads converted data.
+func (r *Reader) Read(p []byte) (n int, err error) {
+	for {
+		if r.head < r.conv {
+			n = copy(p, r.buf[r.head:r.conv])
+			r.head += n
+			return n, nil
+		}
+		if r.err != nil {
+			if r.conv < r.tail {
+				// unprocessed data at the end of the stream
+				r.conv = r.tail
+				continue
+			}
+			return 0, r.err
+		}
+		copy(r.buf, r.buf[r.head:r.tail])
+		r.tail -= r.head
+		r.head, r.conv = 0, 0
+		k, err := r.r.Read(r.buf[r.tail:])
+		r.tail += k
+		if err != nil {
+			r.err = err
+			if err != io.EOF {
+				// Data read before the error is
+				// delivered unconverted.
+				r.conv = r.tail
+				continue
+			}
+		}
+		r.conv = r.c.Convert(r.buf[:r.tail], r.pos)
+		r.pos += uint32(r.conv)
+	}
+}
+
+// Writer applies a Converter to the data written to it and writes the
+// result to an underlying WriteCloser.
+type Writer struct {
+	w   io.WriteCloser
+	c   Converter
+	pos uint32
+	buf []byte
+	err error
+}
+
+// NewWriter creates a writer that converts the data written to it
+// before writing it to w. The argument pos provides the position of the
+// first byte.
+func NewWriter(w io.WriteCloser, c Converter, pos uint32) *Writer {
+	return &Writer{w: w, c: c, pos: pos,
+		buf: make([]byte, 0, bufSize)}
+}
+
+// errClosed indicates that the writer has been closed.
+var errClosed = errors.New("bcj: writer closed")
+
+// Write converts the data in p and writes the processed data to the
+// underlying writer.
+func (w *Writer) Write(p []byte) (n int, err error) {
+	if w.err != nil {
+		return 0, w.err
+	}
+	for len(p) > 0 {
+		k := cap(w.buf) - len(w.buf)
+		if k > len(p) {
+			k = len(p)
+		}
+		w.buf = append(w.buf, p[:k]...)
+		p = p[k:]
+		n += k
+		c := w.c.Convert(w.buf, w.pos)
+		w.pos += uint32(c)
+		if _, err = w.w.Write(w.buf[:c]); err != nil {
+			w.err = err
+			return n, err
+		}
+		w.buf = w.buf[:copy(w.buf, w.buf[c:])]
+	}
+	return n, nil
+}
+
+// Flush calls the Flush method of the underlying writer if it has one.
+// The unprocessed data of an incomplete instruction stays buffered,
+// because it can only be converted after more data has been written.
+func (w *Writer) Flush() error {
+	if w.err != nil {
+		return w.err
+	}
+	if f, ok := w.w.(interface {
+		Flush() error
+	}); ok {
+		return f.Flush()
+	}
+	return nil
+}
+
+// Close writes the unprocessed data and closes the underlying writer.
+func (w *Writer) Close() error {
+	if w.err != nil {
+		return w.err
+	}
+	w.err = errClosed
+	if _, err := w.w.Write(w.buf); err != nil {
+		return err
+	}
+	w.buf = w.buf[:0]
+	return w.w.Close()
+}
@@ -0,0 +1,98 @@
+// Copyright 2014-2017 Ulrich Kunitz. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package bcj
+
+// x86 converts the relative addresses of the x86 CALL (0xe8) and JMP
+// (0xe9) instructions. The state tracks the positions of preceding
+// 0xe8 and 0xe9 bytes that are not converted.
+type x86 struct {
+	encoder  bool
+	prevMask uint32
+	prevPos  uint32
+}
+
+// NewX86 returns the converter for x86 code. The encoder flag selects
+// the conversion direction.
+func NewX86(encoder bool) Converter {
+	return &x86{encoder: encoder, prevPos: ^uint32(4)}
+}
+
+// test86MSByte checks whether b is 0x00 or 0xff.
+func test86MSByte(b byte) bool {
+	return (b+1)&0xfe == 0
+}
+
+var (
+	x86MaskToAllowed = [8]bool{true, true, true, false, true, false,
+		false, false}
+	x86MaskToBitNumber = [8]uint32{0, 1, 2, 2, 3, 3, 3, 3}
+)
+
+// Convert converts the x86 instructions in p.
+func (x *x86) Convert(p []byte, pos uint32) int {
+	if len(p) < 5 {
+		return 0
+	}
+	prevMask, prevPos := x.prevMask, x.prevPos
+	if pos-prevPos > 5 {
+		prevPos = pos - 5
+	}
+	limit := len(p) - 5
+	i := 0
+	for i <= limit {
+		b := p[i]
+		if b != 0xe8 && b != 0xe9 {
+			i++
+			continue
+		}
+		offset := pos + uint32(i) - prevPos
+		prevPos = pos + uint32(i)
+		if offset > 5 {
+			prevMask = 0
+		} else {
+			for k := uint32(0); k < offset; k++ {
+				prevMask &= 0x77
+				prevMask <<= 1
+			}
+		}
+		b = p[i+4]
+		if !test86MSByte(b) || !x86MaskToAllowed[(prevMask>>1)&7] ||
+			prevMask>>1 >= 0x10 {
+			i++
+			prevMask |= 1
+			if test86MSByte(b) {
+				prevMask |= 0x10
+			}
+			continue
+		}
+		src := uint32(b)<<24 | uint32(p[i+3])<<16 |
+			uint32(p[i+2])<<8 | uint32(p[i+1])
+		var dest uint32
+		for {
+			if x.encoder {
+				dest = src + (pos + uint32(i) + 5)
+			} else {
+				dest = src - (pos + uint32(i) + 5)
+			}
+			if prevMask == 0 {
+				break
+			}
+			k := x86MaskToBitNumber[prevMask>>1]
+			b = byte(dest >> (24 - k*8))
+			if !test86MSByte(b) {
+				break
+			}
+			src = dest ^ (1<<(32-k*8) - 1)
+		}
+		p[i+4] = ^byte((dest>>24)&1 - 1)
+		p[i+3] = byte(dest >> 16)
+		p[i+2] = byte(dest >> 8)
+		p[i+1] = byte(dest)
+		i += 5
+		prevMask = 0
+	}
+	x.prevMask, x.prevPos = prevMask, prevPos
+	return i
+}
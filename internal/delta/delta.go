@@ -0,0 +1,69 @@
+// Copyright 2014-2017 Ulrich Kunitz. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package delta
+
+import "io"
+
+// Range of the distance supported by the delta filter.
+const (
+	MinDist = 1
+	MaxDist = 256
+)
+
+// Delta maintains the history of the last 256 bytes for the delta
+// encoding and decoding.
+type Delta struct {
+	dist    int
+	pos     byte
+	history [256]byte
+}
+
+// New returns a Delta for the given distance. The function panics if
+// the distance is out of range.
+func New(dist int) *Delta {
+	if !(MinDist <= dist && dist <= MaxDist) {
+		panic("delta: distance out of range")
+	}
+	return &Delta{dist: dist}
+}
+
+// Encode replaces the bytes in p by the difference to the byte dist
+// positions before.
+func (d *Delta) Encode(p []byte) {
+	for i, b := range p {
+		p[i] = b - d.history[byte(d.dist+int(d.pos))]
+		d.history[d.pos] = b
+		d.pos--
+	}
+}
+
+// Decode reverses Encode.
+func (d *Delta) Decode(p []byte) {
+	for i, b := range p {
+		b += d.history[byte(d.dist+int(d.pos))]
+		p[i] = b
+		d.history[d.pos] = b
+		d.pos--
+	}
+}
+
+// Reader decodes the data read from an underlying reader.
+type Reader struct {
+	r io.Reader
+	d *Delta
+}
+
+// NewReader creates a reader that decodes the data from r using the
+// given distance.
+func NewReader(r io.Reader, dist int) *Reader {
+	return &Reader{r: r, d: New(dist)}
+}
+
+// Read reads and decodes data.
+func (r *Reader) Read(p []byte) (n int, err error) {
+	n, err = r.r.Read(p)
+	r.d.Decode(p[:n])
+	return n, err
+}
@@ -0,0 +1,46 @@
+// Copyright 2014-2017 Ulrich Kunitz. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package delta
+
+import (
+	"bytes"
+	"io/ioutil"
+	"math/rand"
+	"testing"
+	"testing/iotest"
+)
+
+func TestDelta(t *testing.T) {
+	data := make([]byte, 10000)
+	r := rand.New(rand.NewSource(1))
+	for i := range data {
+		data[i] = byte(i%7) + byte(r.Intn(3))
+	}
+	for _, dist := range []int{MinDist, 2, 4, 100, MaxDist} {
+		enc := append([]byte(nil), data...)
+		d := New(dist)
+		for p := enc; len(p) > 0; {
+			k := 1 + r.Intn(300)
+			if k > len(p) {
+				k = len(p)
+			}
+			d.Encode(p[:k])
+			p = p[k:]
+		}
+		if dist == MinDist && enc[1] != data[1]-data[0] {
+			t.Fatalf("dist %d: got %d; want %d", dist, enc[1],
+				data[1]-data[0])
+		}
+		dr := NewReader(iotest.OneByteReader(bytes.NewReader(enc)),
+			dist)
+		dec, err := ioutil.ReadAll(dr)
+		if err != nil {
+			t.Fatalf("dist %d: ReadAll error %s", dist, err)
+		}
+		if !bytes.Equal(dec, data) {
+			t.Fatalf("dist %d: decoded data differs", dist)
+		}
+	}
+}
@@ -0,0 +1,13 @@
+// Copyright 2014-2017 Ulrich Kunitz. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+/*
+Package delta provides the delta filter of the xz and 7z formats.
+
+The filter replaces every byte by its difference to the byte a fixed
+distance before. Sample data like uncompressed audio or images can be
+compressed better after the conversion. The distance must be in the
+range 1 to 256.
+*/
+package delta
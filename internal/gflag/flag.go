@@ -31,6 +31,7 @@ import (
 	"sort"
 	"strconv"
 	"strings"
+	"unicode/utf8"
 )
 
 // CommandLine is the default set of command-line flags parsed from
@@ -317,11 +318,17 @@ func (f *FlagSet) parseArg(i int) (next int, err error) {
 	// short options
 	f.removeArg(i)
 	arg = arg[1:]
-	for _, r := range arg {
+	for j, r := range arg {
 		flag, err := f.lookupShortOption(r)
 		if err != nil {
 			return i, err
 		}
+		if k := j + utf8.RuneLen(r); flag.HasArg == RequiredArg &&
+			k < len(arg) {
+			// the rest of the argument is the flag argument as
+			// in -T0
+			return i, flag.Value.Set(arg[k:])
+		}
 		if err = f.processExtraFlagArg(flag, i); err != nil {
 			return i, err
 		}
@@ -103,6 +103,25 @@ func TestFlagSet_Int(t *testing.T) {
 	}
 }
 
+func TestFlagSet_IntAttached(t *testing.T) {
+	f := NewFlagSet("IntAttached", ContinueOnError)
+	a := f.IntP("test-a", "a", 0, "")
+	v := f.CounterP("verbose", "v", 0, "")
+	err := f.Parse([]string{"-va12", "foo"})
+	if err != nil {
+		t.Fatalf("f.Parse error %s", err)
+	}
+	if *a != 12 {
+		t.Errorf("*a is %d; want %d", *a, 12)
+	}
+	if *v != 1 {
+		t.Errorf("*v is %d; want %d", *v, 1)
+	}
+	if f.NArg() != 1 || f.Arg(0) != "foo" {
+		t.Errorf("f.Args() is %v; want [foo]", f.Args())
+	}
+}
+
 func TestFlagSet_String(t *testing.T) {
 	f := NewFlagSet("String", ContinueOnError)
 	a := f.StringP("test-s", "s", "test", "")
@@ -0,0 +1,151 @@
+// Copyright 2014-2017 Ulrich Kunitz. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package lzip
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+)
+
+/*** Header ***/
+
+// headerMagic stores the magic bytes for the header.
+var headerMagic = []byte{'L', 'Z', 'I', 'P'}
+
+// HeaderLen provides the length of the lzip member header.
+const HeaderLen = 6
+
+// version is the only version of the lzip format supported.
+const version = 1
+
+// Limits for the dictionary size supported by lzip.
+const (
+	minDictCap = 1 << 12
+	maxDictCap = 1 << 29
+)
+
+// header represents the header of an lzip member.
+type header struct {
+	dictCap int
+}
+
+// errHeaderMagic indicates that the header magic bytes are wrong.
+var errHeaderMagic = errors.New("lzip: invalid header magic bytes")
+
+// ValidHeader checks whether data is a correct lzip member header. The
+// length of data must be HeaderLen.
+func ValidHeader(data []byte) bool {
+	var h header
+	err := h.UnmarshalBinary(data)
+	return err == nil
+}
+
+// decodeDictCap decodes the coded dictionary size. The lower five bits
+// give the binary logarithm of the base size. The upper three bits
+// give the number of sixteenths of the base size that have to be
+// subtracted.
+func decodeDictCap(c byte) (n int, err error) {
+	b := uint(c & 0x1f)
+	if !(12 <= b && b <= 29) {
+		return 0, errors.New("lzip: dictionary size out of range")
+	}
+	n = 1 << b
+	n -= int(c>>5) * (n >> 4)
+	if n < minDictCap {
+		return 0, errors.New("lzip: dictionary size out of range")
+	}
+	return n, nil
+}
+
+// encodeDictCap computes the code for the smallest dictionary size
+// that is not less than n. The dictionary size for the code is returned
+// as well.
+func encodeDictCap(n int) (c byte, dictCap int, err error) {
+	if n > maxDictCap {
+		return 0, 0, errors.New("lzip: dictionary size out of range")
+	}
+	if n < minDictCap {
+		n = minDictCap
+	}
+	b := uint(12)
+	for 1<<b < n {
+		b++
+	}
+	base := 1 << b
+	frac := (base - n) / (base >> 4)
+	if frac > 7 {
+		frac = 7
+	}
+	c = byte(frac<<5) | byte(b)
+	return c, base - frac*(base>>4), nil
+}
+
+// MarshalBinary converts the header into its binary representation.
+func (h header) MarshalBinary() (data []byte, err error) {
+	c, _, err := encodeDictCap(h.dictCap)
+	if err != nil {
+		return nil, err
+	}
+	data = make([]byte, HeaderLen)
+	copy(data, headerMagic)
+	data[4] = version
+	data[5] = c
+	return data, nil
+}
+
+// UnmarshalBinary reads the header from the binary representation.
+func (h *header) UnmarshalBinary(data []byte) error {
+	if len(data) != HeaderLen {
+		return errors.New("lzip: wrong header length")
+	}
+	if !bytes.Equal(data[:4], headerMagic) {
+		return errHeaderMagic
+	}
+	if data[4] != version {
+		return errors.New("lzip: unsupported version")
+	}
+	var err error
+	h.dictCap, err = decodeDictCap(data[5])
+	return err
+}
+
+/*** Trailer ***/
+
+// trailerLen provides the length of the lzip member trailer.
+const trailerLen = 20
+
+// trailer represents the trailer of an lzip member.
+type trailer struct {
+	// CRC32 of the uncompressed data
+	crc uint32
+	// size of the uncompressed data
+	dataSize int64
+	// size of the member including header and trailer
+	memberSize int64
+}
+
+// MarshalBinary converts the trailer into its binary representation.
+func (t trailer) MarshalBinary() (data []byte, err error) {
+	data = make([]byte, trailerLen)
+	binary.LittleEndian.PutUint32(data, t.crc)
+	binary.LittleEndian.PutUint64(data[4:], uint64(t.dataSize))
+	binary.LittleEndian.PutUint64(data[12:], uint64(t.memberSize))
+	return data, nil
+}
+
+// UnmarshalBinary reads the trailer from the binary representation.
+func (t *trailer) UnmarshalBinary(data []byte) error {
+	if len(data) != trailerLen {
+		return errors.New("lzip: wrong trailer length")
+	}
+	t.crc = binary.LittleEndian.Uint32(data)
+	t.dataSize = int64(binary.LittleEndian.Uint64(data[4:]))
+	t.memberSize = int64(binary.LittleEndian.Uint64(data[12:]))
+	if t.dataSize < 0 || t.memberSize < HeaderLen+trailerLen {
+		return errors.New("lzip: sizes in trailer out of range")
+	}
+	return nil
+}
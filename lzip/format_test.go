@@ -0,0 +1,94 @@
+// Copyright 2014-2017 Ulrich Kunitz. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package lzip
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestEncodeDictCap(t *testing.T) {
+	tests := []struct {
+		n       int
+		c       byte
+		dictCap int
+	}{
+		{0, 0x0c, 1 << 12},
+		{1 << 12, 0x0c, 1 << 12},
+		{1<<12 + 1, 0xed, 1<<13 - 7*(1<<9)},
+		{1 << 23, 0x17, 1 << 23},
+		{3 << 20, 0x96, 3 << 20},
+		{3<<20 + 1, 0x76, 4<<20 - 3<<18},
+		{1 << 29, 0x1d, 1 << 29},
+	}
+	for _, tc := range tests {
+		c, dictCap, err := encodeDictCap(tc.n)
+		if err != nil {
+			t.Fatalf("encodeDictCap(%d) error %s", tc.n, err)
+		}
+		if c != tc.c || dictCap != tc.dictCap {
+			t.Errorf("encodeDictCap(%d) = %#02x, %d; want %#02x, %d",
+				tc.n, c, dictCap, tc.c, tc.dictCap)
+		}
+		n, err := decodeDictCap(c)
+		if err != nil {
+			t.Fatalf("decodeDictCap(%#02x) error %s", c, err)
+		}
+		if n != dictCap {
+			t.Errorf("decodeDictCap(%#02x) = %d; want %d",
+				c, n, dictCap)
+		}
+	}
+	if _, _, err := encodeDictCap(1<<29 + 1); err == nil {
+		t.Errorf("encodeDictCap(1<<29 + 1) returned no error")
+	}
+	if _, err := decodeDictCap(0x1e); err == nil {
+		t.Errorf("decodeDictCap(0x1e) returned no error")
+	}
+}
+
+func TestHeader(t *testing.T) {
+	h := header{dictCap: 1 << 23}
+	data, err := h.MarshalBinary()
+	if err != nil {
+		t.Fatalf("MarshalBinary error %s", err)
+	}
+	want := []byte{'L', 'Z', 'I', 'P', 1, 0x17}
+	if !bytes.Equal(data, want) {
+		t.Fatalf("MarshalBinary returned % x; want % x", data, want)
+	}
+	if !ValidHeader(data) {
+		t.Fatalf("ValidHeader(% x) returned false", data)
+	}
+	var g header
+	if err = g.UnmarshalBinary(data); err != nil {
+		t.Fatalf("UnmarshalBinary error %s", err)
+	}
+	if g != h {
+		t.Fatalf("UnmarshalBinary returned %+v; want %+v", g, h)
+	}
+	data[4] = 0
+	if ValidHeader(data) {
+		t.Fatalf("ValidHeader accepted version 0")
+	}
+}
+
+func TestTrailer(t *testing.T) {
+	tr := trailer{crc: 0x12345678, dataSize: 44, memberSize: 80}
+	data, err := tr.MarshalBinary()
+	if err != nil {
+		t.Fatalf("MarshalBinary error %s", err)
+	}
+	if len(data) != trailerLen {
+		t.Fatalf("len(data) = %d; want %d", len(data), trailerLen)
+	}
+	var g trailer
+	if err = g.UnmarshalBinary(data); err != nil {
+		t.Fatalf("UnmarshalBinary error %s", err)
+	}
+	if g != tr {
+		t.Fatalf("UnmarshalBinary returned %+v; want %+v", g, tr)
+	}
+}
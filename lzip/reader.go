@@ -0,0 +1,210 @@
+// Copyright 2014-2017 Ulrich Kunitz. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// Package lzip supports the compression and decompression of lzip
+// files. An lzip file consists of one or more members, each containing
+// a header, a raw LZMA stream terminated by an end-of-stream marker and
+// a trailer with the CRC32 and the sizes of the member. See
+// http://www.nongnu.org/lzip/manual/lzip_manual.html#File-format
+package lzip
+
+import (
+	"bufio"
+	"errors"
+	"hash"
+	"hash/crc32"
+	"io"
+
+	"github.com/ulikunitz/xz/lzma"
+)
+
+// properties are the LZMA properties used by all lzip members.
+var properties = lzma.Properties{LC: 3, LP: 0, PB: 2}
+
+// ReaderConfig defines the parameters for the lzip reader. The
+// SingleMember parameter requests the reader to stop after the first
+// member.
+type ReaderConfig struct {
+	SingleMember bool
+	// MemLimit limits the memory in bytes used for decoding a member.
+	// The memory usage is estimated from the dictionary size in the
+	// member header. If the limit is exceeded, an error of type
+	// *lzma.MemLimitError is returned. The value zero means no
+	// limit.
+	MemLimit int64
+}
+
+// Verify checks the reader parameters for validity.
+func (c *ReaderConfig) Verify() error {
+	if c == nil {
+		return errors.New("lzip: reader parameters are nil")
+	}
+	if c.MemLimit < 0 {
+		return errors.New("lzip: memory limit is negative")
+	}
+	return nil
+}
+
+// Reader supports the reading of one or multiple lzip members.
+type Reader struct {
+	ReaderConfig
+
+	lz *countingReader
+	lr *lzma.Reader
+	// CRC32 and size of the uncompressed data of the member
+	crc      hash.Hash32
+	dataSize int64
+	err      error
+}
+
+// NewReader creates a new lzip reader using the default parameters.
+// The function reads and checks the header of the first member.
+func NewReader(lz io.Reader) (r *Reader, err error) {
+	return ReaderConfig{}.NewReader(lz)
+}
+
+// NewReader creates an lzip reader. The reader will process all
+// members of the file unless SingleMember has been set in the reader
+// configuration c.
+func (c ReaderConfig) NewReader(lz io.Reader) (r *Reader, err error) {
+	if err = c.Verify(); err != nil {
+		return nil, err
+	}
+	r = &Reader{
+		ReaderConfig: c,
+		lz:           &countingReader{r: bufio.NewReader(lz)},
+		crc:          crc32.NewIEEE(),
+	}
+	if err = r.readHeader(true); err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+// errTrailingData indicates that data follows the last member that is
+// not an lzip member.
+var errTrailingData = errors.New("lzip: trailing data after last member")
+
+// readHeader reads the header of the next member and creates the LZMA
+// reader for it. For the following members io.EOF is returned if no
+// data is available and errTrailingData if the data doesn't start with
+// the header magic.
+func (r *Reader) readHeader(first bool) error {
+	data := make([]byte, HeaderLen)
+	if _, err := io.ReadFull(r.lz, data); err != nil {
+		switch {
+		case first && err == io.EOF:
+			return io.ErrUnexpectedEOF
+		case !first && err == io.ErrUnexpectedEOF:
+			return errTrailingData
+		}
+		return err
+	}
+	var h header
+	err := h.UnmarshalBinary(data)
+	if err != nil {
+		if !first && err == errHeaderMagic {
+			return errTrailingData
+		}
+		return err
+	}
+	lc := lzma.ReaderConfig{DictCap: h.dictCap, MemLimit: r.MemLimit}
+	// the counter includes the header of the member
+	r.lz.n = HeaderLen
+	r.crc.Reset()
+	r.dataSize = 0
+	r.lr, err = lc.NewRawReader(r.lz, properties, -1)
+	return err
+}
+
+// readTrailer reads the trailer of the current member and checks it
+// against the data read.
+func (r *Reader) readTrailer() error {
+	data := make([]byte, trailerLen)
+	if _, err := io.ReadFull(r.lz, data); err != nil {
+		if err == io.EOF {
+			err = io.ErrUnexpectedEOF
+		}
+		return err
+	}
+	var t trailer
+	if err := t.UnmarshalBinary(data); err != nil {
+		return err
+	}
+	if t.crc != r.crc.Sum32() {
+		return errors.New("lzip: CRC32 mismatch")
+	}
+	if t.dataSize != r.dataSize {
+		return errors.New("lzip: data size mismatch")
+	}
+	if t.memberSize != r.lz.n {
+		return errors.New("lzip: member size mismatch")
+	}
+	return nil
+}
+
+// Read reads uncompressed data from the lzip file. The reader returns
+// the data of a member before reading the header of the next member.
+func (r *Reader) Read(p []byte) (n int, err error) {
+	if r.err != nil {
+		return 0, r.err
+	}
+	for n < len(p) {
+		k, err := r.lr.Read(p[n:])
+		r.crc.Write(p[n : n+k])
+		r.dataSize += int64(k)
+		n += k
+		if err == nil {
+			continue
+		}
+		if err != io.EOF {
+			r.err = err
+			return n, err
+		}
+		if err = r.readTrailer(); err != nil {
+			r.err = err
+			return n, err
+		}
+		if r.SingleMember {
+			err = io.EOF
+		} else {
+			err = r.readHeader(false)
+		}
+		if err != nil {
+			r.err = err
+			if n > 0 && err == io.EOF {
+				return n, nil
+			}
+			return n, err
+		}
+		if n > 0 {
+			return n, nil
+		}
+	}
+	return n, nil
+}
+
+// countingReader counts the bytes read from the underlying reader. It
+// supports io.ByteReader, so the LZMA reader doesn't read beyond the
+// end of the LZMA stream.
+type countingReader struct {
+	r *bufio.Reader
+	n int64
+}
+
+// Read reads data from the buffered reader.
+func (cr *countingReader) Read(p []byte) (n int, err error) {
+	n, err = cr.r.Read(p)
+	cr.n += int64(n)
+	return n, err
+}
+
+// ReadByte reads a single byte from the buffered reader.
+func (cr *countingReader) ReadByte() (c byte, err error) {
+	c, err = cr.r.ReadByte()
+	if err == nil {
+		cr.n++
+	}
+	return c, err
+}
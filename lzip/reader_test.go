@@ -0,0 +1,82 @@
+// Copyright 2014-2017 Ulrich Kunitz. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package lzip
+
+import (
+	"bytes"
+	"io"
+	"io/ioutil"
+	"testing"
+)
+
+// compress returns the text compressed into a single lzip member.
+func compress(t *testing.T, text string) []byte {
+	var buf bytes.Buffer
+	w, err := NewWriter(&buf)
+	if err != nil {
+		t.Fatalf("NewWriter error %s", err)
+	}
+	if _, err = io.WriteString(w, text); err != nil {
+		t.Fatalf("WriteString error %s", err)
+	}
+	if err = w.Close(); err != nil {
+		t.Fatalf("w.Close error %s", err)
+	}
+	return buf.Bytes()
+}
+
+func TestReaderConcatenated(t *testing.T) {
+	a := compress(t, "The quick brown fox ")
+	b := compress(t, "jumps over the lazy dog.")
+	data := append(append([]byte{}, a...), b...)
+	r, err := NewReader(bytes.NewReader(data))
+	if err != nil {
+		t.Fatalf("NewReader error %s", err)
+	}
+	out, err := ioutil.ReadAll(r)
+	if err != nil {
+		t.Fatalf("ReadAll error %s", err)
+	}
+	const want = "The quick brown fox jumps over the lazy dog."
+	if string(out) != want {
+		t.Fatalf("read %q; want %q", out, want)
+	}
+}
+
+func TestReaderErrors(t *testing.T) {
+	data := compress(t, "The quick brown fox jumps over the lazy dog.")
+	tests := []struct {
+		name string
+		data func() []byte
+	}{
+		{"empty", func() []byte { return nil }},
+		{"truncated", func() []byte { return data[:len(data)-1] }},
+		{"crc", func() []byte {
+			p := append([]byte{}, data...)
+			p[len(p)-trailerLen] ^= 1
+			return p
+		}},
+		{"member size", func() []byte {
+			p := append([]byte{}, data...)
+			p[len(p)-8]++
+			return p
+		}},
+		{"trailing data", func() []byte {
+			return append(append([]byte{}, data...), "garbage"...)
+		}},
+		{"trailing byte", func() []byte {
+			return append(append([]byte{}, data...), 0)
+		}},
+	}
+	for _, tc := range tests {
+		r, err := NewReader(bytes.NewReader(tc.data()))
+		if err != nil {
+			continue
+		}
+		if _, err = ioutil.ReadAll(r); err == nil {
+			t.Errorf("%s: no error", tc.name)
+		}
+	}
+}
@@ -0,0 +1,231 @@
+// Copyright 2014-2017 Ulrich Kunitz. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package lzip
+
+import (
+	"errors"
+	"hash"
+	"hash/crc32"
+	"io"
+
+	"github.com/ulikunitz/xz/lzma"
+)
+
+// maxInt64 provides the maximum value of the int64 type.
+const maxInt64 = 1<<63 - 1
+
+// WriterConfig describes the parameters for the lzip writer.
+type WriterConfig struct {
+	// DictCap gives the dictionary capacity. The header can only
+	// store certain sizes between 4 KiB and 512 MiB, so the capacity
+	// is rounded up to the next size that can be stored. The zero
+	// value selects 8 MiB.
+	DictCap int
+	BufSize int
+	// match algorithm
+	Matcher lzma.MatchAlgorithm
+	// Mode, NiceLen and Depth tune the LZMA encoder; see
+	// lzma.WriterConfig
+	Mode    lzma.Mode
+	NiceLen int
+	Depth   int
+	// MemberSize limits the uncompressed size of a member. If it is
+	// exceeded a new member is started. The zero value selects a
+	// single member.
+	MemberSize int64
+}
+
+// WriterConfigForPreset returns the writer configuration for the
+// given preset level between lzma.MinPreset and lzma.MaxPreset. The
+// extreme flag selects a slower variant of the preset that may
+// compress better. The presets are the presets of the xz-utils.
+func WriterConfigForPreset(level int, extreme bool) (c WriterConfig,
+	err error) {
+
+	lc, err := lzma.WriterConfigForPreset(level, extreme)
+	if err != nil {
+		return c, err
+	}
+	c = WriterConfig{
+		DictCap: lc.DictCap,
+		Matcher: lc.Matcher,
+		Mode:    lc.Mode,
+		NiceLen: lc.NiceLen,
+		Depth:   lc.Depth,
+	}
+	return c, nil
+}
+
+// fill replaces zero values with default values.
+func (c *WriterConfig) fill() {
+	if c.DictCap == 0 {
+		c.DictCap = 8 * 1024 * 1024
+	}
+	if c.MemberSize == 0 {
+		c.MemberSize = maxInt64
+	}
+}
+
+// lzmaConfig returns the configuration for the LZMA writer of a
+// member.
+func (c *WriterConfig) lzmaConfig() (lc lzma.WriterConfig, err error) {
+	_, dictCap, err := encodeDictCap(c.DictCap)
+	if err != nil {
+		return lc, err
+	}
+	p := properties
+	lc = lzma.WriterConfig{
+		Properties: &p,
+		DictCap:    dictCap,
+		BufSize:    c.BufSize,
+		Matcher:    c.Matcher,
+		Mode:       c.Mode,
+		NiceLen:    c.NiceLen,
+		Depth:      c.Depth,
+		EOSMarker:  true,
+	}
+	return lc, nil
+}
+
+// Verify checks the configuration for errors. Zero values will be
+// replaced by default values.
+func (c *WriterConfig) Verify() error {
+	if c == nil {
+		return errors.New("lzip: writer configuration is nil")
+	}
+	c.fill()
+	lc, err := c.lzmaConfig()
+	if err != nil {
+		return err
+	}
+	if err = lc.Verify(); err != nil {
+		return err
+	}
+	if c.MemberSize <= 0 {
+		return errors.New("lzip: member size out of range")
+	}
+	return nil
+}
+
+// Writer compresses data into the lzip format. The data is split into
+// members of at most MemberSize uncompressed bytes.
+type Writer struct {
+	WriterConfig
+
+	lz countingWriter
+	lw *lzma.Writer
+	// CRC32 and size of the uncompressed data of the member
+	crc      hash.Hash32
+	dataSize int64
+}
+
+// NewWriter creates a new lzip writer using the default parameters.
+func NewWriter(lz io.Writer) (w *Writer, err error) {
+	return WriterConfig{}.NewWriter(lz)
+}
+
+// NewWriter creates a new lzip writer. The header of the first member
+// will be written immediately.
+func (c WriterConfig) NewWriter(lz io.Writer) (w *Writer, err error) {
+	if err = c.Verify(); err != nil {
+		return nil, err
+	}
+	w = &Writer{
+		WriterConfig: c,
+		lz:           countingWriter{w: lz},
+		crc:          crc32.NewIEEE(),
+	}
+	if err = w.newMember(); err != nil {
+		return nil, err
+	}
+	return w, nil
+}
+
+// newMember writes the header of a new member and creates the LZMA
+// writer for it.
+func (w *Writer) newMember() error {
+	lc, err := w.lzmaConfig()
+	if err != nil {
+		return err
+	}
+	data, err := header{dictCap: lc.DictCap}.MarshalBinary()
+	if err != nil {
+		return err
+	}
+	w.lz.n = 0
+	if _, err = w.lz.Write(data); err != nil {
+		return err
+	}
+	w.crc.Reset()
+	w.dataSize = 0
+	w.lw, err = lc.NewRawWriter(&w.lz)
+	return err
+}
+
+// closeMember finishes the LZMA stream of the current member and writes
+// the trailer.
+func (w *Writer) closeMember() error {
+	if err := w.lw.Close(); err != nil {
+		return err
+	}
+	t := trailer{
+		crc:        w.crc.Sum32(),
+		dataSize:   w.dataSize,
+		memberSize: w.lz.n + trailerLen,
+	}
+	data, err := t.MarshalBinary()
+	if err != nil {
+		return err
+	}
+	_, err = w.lz.Write(data)
+	return err
+}
+
+// Write compresses the data in p. New members are started if the
+// member size is reached.
+func (w *Writer) Write(p []byte) (n int, err error) {
+	for len(p) > 0 {
+		if w.dataSize >= w.MemberSize {
+			if err = w.closeMember(); err != nil {
+				return n, err
+			}
+			if err = w.newMember(); err != nil {
+				return n, err
+			}
+		}
+		q := p
+		if m := w.MemberSize - w.dataSize; m < int64(len(q)) {
+			q = q[:m]
+		}
+		k, err := w.lw.Write(q)
+		w.crc.Write(q[:k])
+		w.dataSize += int64(k)
+		n += k
+		if err != nil {
+			return n, err
+		}
+		p = p[k:]
+	}
+	return n, nil
+}
+
+// Close closes the writer and writes the trailer of the last member.
+// It doesn't close the underlying writer.
+func (w *Writer) Close() error {
+	return w.closeMember()
+}
+
+// countingWriter is a writer that counts all data written to it.
+type countingWriter struct {
+	w io.Writer
+	n int64
+}
+
+// Write writes data to the countingWriter.
+func (cw *countingWriter) Write(p []byte) (n int, err error) {
+	n, err = cw.w.Write(p)
+	cw.n += int64(n)
+	return n, err
+}
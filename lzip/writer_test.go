@@ -0,0 +1,124 @@
+// Copyright 2014-2017 Ulrich Kunitz. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package lzip
+
+import (
+	"bytes"
+	"io"
+	"io/ioutil"
+	"math/rand"
+	"testing"
+
+	"github.com/ulikunitz/xz/internal/randtxt"
+)
+
+func TestWriter(t *testing.T) {
+	const text = "The quick brown fox jumps over the lazy dog."
+	var buf bytes.Buffer
+	w, err := NewWriter(&buf)
+	if err != nil {
+		t.Fatalf("NewWriter error %s", err)
+	}
+	n, err := io.WriteString(w, text)
+	if err != nil {
+		t.Fatalf("WriteString error %s", err)
+	}
+	if n != len(text) {
+		t.Fatalf("Writestring wrote %d bytes; want %d", n, len(text))
+	}
+	if err = w.Close(); err != nil {
+		t.Fatalf("w.Close error %s", err)
+	}
+	var tr trailer
+	data := buf.Bytes()
+	if err = tr.UnmarshalBinary(data[len(data)-trailerLen:]); err != nil {
+		t.Fatalf("trailer error %s", err)
+	}
+	if tr.dataSize != int64(len(text)) {
+		t.Fatalf("trailer data size %d; want %d",
+			tr.dataSize, len(text))
+	}
+	if tr.memberSize != int64(len(data)) {
+		t.Fatalf("trailer member size %d; want %d",
+			tr.memberSize, len(data))
+	}
+	r, err := NewReader(&buf)
+	if err != nil {
+		t.Fatalf("NewReader error %s", err)
+	}
+	out, err := ioutil.ReadAll(r)
+	if err != nil {
+		t.Fatalf("ReadAll error %s", err)
+	}
+	if string(out) != text {
+		t.Fatalf("read %q; want %q", out, text)
+	}
+}
+
+func TestWriterMembers(t *testing.T) {
+	const txtlen = 50000
+	var buf bytes.Buffer
+	io.CopyN(&buf, randtxt.NewReader(rand.NewSource(41)), txtlen)
+	txt := buf.Bytes()
+	cfg := WriterConfig{DictCap: 1 << 14, MemberSize: 12000}
+	var lz bytes.Buffer
+	w, err := cfg.NewWriter(&lz)
+	if err != nil {
+		t.Fatalf("NewWriter error %s", err)
+	}
+	if _, err = w.Write(txt); err != nil {
+		t.Fatalf("w.Write error %s", err)
+	}
+	if err = w.Close(); err != nil {
+		t.Fatalf("w.Close error %s", err)
+	}
+	members := bytes.Count(lz.Bytes(), headerMagic)
+	if members != 5 {
+		t.Fatalf("got %d members; want %d", members, 5)
+	}
+	data := lz.Bytes()
+
+	r, err := NewReader(bytes.NewReader(data))
+	if err != nil {
+		t.Fatalf("NewReader error %s", err)
+	}
+	out, err := ioutil.ReadAll(r)
+	if err != nil {
+		t.Fatalf("ReadAll error %s", err)
+	}
+	if !bytes.Equal(out, txt) {
+		t.Fatalf("decompressed data differs from original")
+	}
+
+	r, err = ReaderConfig{SingleMember: true}.NewReader(
+		bytes.NewReader(data))
+	if err != nil {
+		t.Fatalf("NewReader error %s", err)
+	}
+	out, err = ioutil.ReadAll(r)
+	if err != nil {
+		t.Fatalf("ReadAll error %s", err)
+	}
+	if !bytes.Equal(out, txt[:cfg.MemberSize]) {
+		t.Fatalf("single member: got %d bytes; want %d",
+			len(out), cfg.MemberSize)
+	}
+}
+
+func TestWriterConfigForPreset(t *testing.T) {
+	cfg, err := WriterConfigForPreset(1, false)
+	if err != nil {
+		t.Fatalf("WriterConfigForPreset error %s", err)
+	}
+	if err = cfg.Verify(); err != nil {
+		t.Fatalf("Verify error %s", err)
+	}
+	if cfg.DictCap != 1<<20 {
+		t.Fatalf("DictCap %d; want %d", cfg.DictCap, 1<<20)
+	}
+	if _, err = WriterConfigForPreset(10, false); err == nil {
+		t.Fatalf("WriterConfigForPreset(10) returned no error")
+	}
+}
@@ -9,6 +9,7 @@ import (
 	"errors"
 	"fmt"
 	"io"
+	"sort"
 	"unicode"
 )
 
@@ -44,8 +45,13 @@ type binTree struct {
 	root uint32
 	// current x value
 	x uint32
-	// preallocated array
-	data []byte
+	// length of matches that stop the search
+	niceLen int
+	// number of nodes checked
+	depth int
+	// preallocated slices
+	data  []byte
+	dists []int
 }
 
 // null represents the nonexistent index. We can't use zero because it
@@ -71,11 +77,36 @@ func newBinTree(capacity int) (t *binTree, err error) {
 		root: null,
 		data: make([]byte, maxMatchLen),
 	}
+	t.setParams(defaultNiceLen, 0)
 	return t, nil
 }
 
+// setParams sets the nice length and the number of nodes checked. The
+// depth zero selects the default.
+func (t *binTree) setParams(niceLen, depth int) error {
+	if depth == 0 {
+		depth = maxCandidates
+	}
+	t.niceLen = niceLen
+	t.depth = depth
+	t.dists = make([]int, 0, depth)
+	return nil
+}
+
+// NiceLen returns the length of matches that stop the search.
+func (t *binTree) NiceLen() int { return t.niceLen }
+
 func (t *binTree) SetDict(d *encoderDict) { t.dict = d }
 
+// Reset puts the binary tree into its initial state. The node array is
+// kept.
+func (t *binTree) Reset() {
+	t.hoff = -int64(wordLen)
+	t.front = 0
+	t.root = null
+	t.x = 0
+}
+
 // WriteByte writes a single byte into the binary tree.
 func (t *binTree) WriteByte(c byte) error {
 	t.x = (t.x << 8) | uint32(c)
@@ -378,12 +409,14 @@ func (t *binTree) dump(w io.Writer) error {
 	return bw.Flush()
 }
 
+// distance returns the distance of the word stored in node v from the
+// end of the data written into the tree.
 func (t *binTree) distance(v uint32) int {
 	dist := int(t.front) - int(v)
 	if dist <= 0 {
 		dist += len(t.node)
 	}
-	return dist
+	return dist + wordLen - 1
 }
 
 type matchParams struct {
@@ -408,6 +441,9 @@ func (t *binTree) match(m match, distIter func() (int, bool), p matchParams,
 			return m, checked, false
 		}
 		checked++
+		if dist > t.dict.DictLen() {
+			continue
+		}
 		if m.n > 0 {
 			i := buf.rear - dist + m.n - 1
 			if i < 0 {
@@ -459,8 +495,8 @@ func (t *binTree) NextOp(rep [4]uint32) operation {
 	)
 	p := matchParams{
 		rep:     rep,
-		nAccept: maxMatchLen,
-		check:   32,
+		nAccept: t.niceLen,
+		check:   t.depth,
 	}
 	i := 4
 	iterSmall := func() (dist int, ok bool) {
@@ -521,3 +557,65 @@ end:
 	}
 	return m
 }
+
+// maxCandidates is the default for the number of nodes checked.
+const maxCandidates = 32
+
+// FindMatches appends the matches for the lookahead data at offset k
+// to ms. The matches have increasing lengths and for each length the
+// smallest distance is returned. The search stops if a match of the
+// nice length has been found.
+func (t *binTree) FindMatches(ms []match, k int) []match {
+	d := t.dict
+	maxLen, maxDist := d.searchLimits(k)
+	if maxLen < minMatchLen {
+		return ms
+	}
+	ms = d.shortMatches(ms, k, maxLen, maxDist)
+	if matchesDone(ms, maxLen, t.niceLen) || maxLen < wordLen {
+		return ms
+	}
+	d.feedMatcher(k)
+	var word [wordLen]byte
+	for i := range word {
+		word[i] = d.peekByte(k + i)
+	}
+	x := xval(word[:])
+
+	// The tree may contain the data beyond the head.
+	delta := int(d.mpos - d.head - int64(k))
+	dists := t.dists[:0]
+	add := func(v uint32) {
+		dist := t.distance(v) - delta
+		if 3 < dist && dist <= maxDist {
+			dists = append(dists, dist)
+		}
+	}
+	u, v := t.search(t.root, x)
+	if u == v {
+		for u != null && len(dists) < t.depth {
+			add(u)
+			u, v = t.search(t.node[u].l, x)
+			if u != v {
+				u = null
+			}
+		}
+	} else {
+		// only the neighbors may share a prefix shorter than
+		// the word length
+		if u != null {
+			add(u)
+		}
+		if v != null {
+			add(v)
+		}
+	}
+	sort.Ints(dists)
+	for _, dist := range dists {
+		ms = d.appendMatch(ms, k, dist, maxLen)
+		if matchesDone(ms, maxLen, t.niceLen) {
+			break
+		}
+	}
+	return ms
+}
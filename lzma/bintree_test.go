@@ -105,3 +105,30 @@ func TestBinTree_Cycle(t *testing.T) {
 		t.Fatal("decompressed data differs from original")
 	}
 }
+
+func TestBinTree_NextOp(t *testing.T) {
+	bt, err := newBinTree(4096)
+	if err != nil {
+		t.Fatal(err)
+	}
+	d, err := newEncoderDict(4096, 4096, bt)
+	if err != nil {
+		t.Fatal(err)
+	}
+	const s = "Klopp feiert mit Liverpool seinen hoechsten Sieg. " +
+		"Klopp feiert."
+	if _, err = d.Write([]byte(s)); err != nil {
+		t.Fatalf("d.Write error %s", err)
+	}
+	// The distances of the nodes must include the word length.
+	// Otherwise the repetition isn't found.
+	k := strings.LastIndex(s, "Klopp")
+	for d.Pos() < int64(k) {
+		d.Discard(1)
+	}
+	op := bt.NextOp([4]uint32{})
+	want := match{distance: int64(k), n: len("Klopp feiert")}
+	if m, ok := op.(match); !ok || m != want {
+		t.Fatalf("NextOp returned %v; want %v", op, want)
+	}
+}
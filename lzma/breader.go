@@ -37,3 +37,29 @@ func (r *breader) ReadByte() (c byte, err error) {
 	}
 	return r.p[0], nil
 }
+
+// countingByteReader counts the bytes read from the underlying
+// ByteReader.
+type countingByteReader struct {
+	br io.ByteReader
+	n  int64
+}
+
+// ReadByte reads a byte and increments the counter.
+func (r *countingByteReader) ReadByte() (c byte, err error) {
+	c, err = r.br.ReadByte()
+	if err == nil {
+		r.n++
+	}
+	return c, err
+}
+
+// UnreadByte unreads the last byte and decrements the counter. The
+// underlying ByteReader must support io.ByteScanner.
+func (r *countingByteReader) UnreadByte() error {
+	if err := r.br.(io.ByteScanner).UnreadByte(); err != nil {
+		return err
+	}
+	r.n--
+	return nil
+}
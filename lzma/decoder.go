@@ -28,6 +28,9 @@ type decoder struct {
 	eos bool
 	// EOS marker found
 	eosMarker bool
+	// eosOptional allows a stream of unknown size to end at the end
+	// of the input without EOS marker
+	eosOptional bool
 }
 
 // newDecoder creates a new decoder instance. The parameter size provides
@@ -197,6 +200,10 @@ func (d *decoder) decompress() error {
 		return io.EOF
 	}
 	for d.Dict.Available() >= maxMatchLen {
+		if d.eosOptional && d.size < 0 && d.rd.atEOF() {
+			d.eos = true
+			return io.EOF
+		}
 		op, err := d.readOp()
 		switch err {
 		case nil:
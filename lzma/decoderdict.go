@@ -33,6 +33,17 @@ func (d *decoderDict) Reset() {
 	d.head = 0
 }
 
+// preset puts the preset dictionary p into the dictionary. The data
+// will not be returned by Read. The head is moved by the length of p
+// as in the encoder dictionary.
+func (d *decoderDict) preset(p []byte) {
+	for len(p) > 0 {
+		n, _ := d.Write(p)
+		d.buf.Discard(n)
+		p = p[n:]
+	}
+}
+
 // WriteByte writes a single byte into the dictionary. It is used to
 // write literals into the dictionary.
 func (d *decoderDict) WriteByte(c byte) error {
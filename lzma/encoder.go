@@ -30,6 +30,8 @@ type encoderFlags uint32
 const (
 	// eosMarker requests an EOS marker to be written.
 	eosMarker encoderFlags = 1 << iota
+	// optimal requests the optimal parsing of the data.
+	optimal
 )
 
 // Encoder compresses data buffered in the encoder dictionary and writes
@@ -43,12 +45,15 @@ type encoder struct {
 	marker bool
 	limit  bool
 	margin int
+	// optimizer used for optimal parsing; nil for greedy parsing
+	opt *optimizer
 }
 
 // newEncoder creates a new encoder. If the byte writer must be
 // limited use LimitedByteWriter provided by this package. The flags
 // argument supports the eosMarker flag, controlling whether a
-// terminating end-of-stream marker must be written.
+// terminating end-of-stream marker must be written, and the optimal
+// flag, which selects the optimal parsing of the data.
 func newEncoder(bw io.ByteWriter, state *state, dict *encoderDict,
 	flags encoderFlags) (e *encoder, err error) {
 
@@ -67,6 +72,9 @@ func newEncoder(bw io.ByteWriter, state *state, dict *encoderDict,
 	if e.marker {
 		e.margin += 5
 	}
+	if flags&optimal != 0 {
+		e.opt = newOptimizer(dict.m.NiceLen())
+	}
 	return e, nil
 }
 
@@ -99,6 +107,19 @@ func (e *encoder) Reopen(bw io.ByteWriter) error {
 	return nil
 }
 
+// Reset puts the encoder into its initial state using the byte writer
+// bw. The dictionary must have been reset before.
+func (e *encoder) Reset(bw io.ByteWriter) error {
+	if err := e.Reopen(bw); err != nil {
+		return err
+	}
+	e.state.Reset()
+	if e.opt != nil {
+		e.opt.Reset()
+	}
+	return nil
+}
+
 // writeLiteral writes a literal into the LZMA stream
 func (e *encoder) writeLiteral(l lit) error {
 	var err error
@@ -231,8 +252,16 @@ func (e *encoder) compress(flags compressFlags) error {
 	d := e.dict
 	m := d.m
 	for d.Buffered() > n {
-		op := m.NextOp(e.state.rep)
+		var op operation
+		if e.opt != nil {
+			op = e.opt.NextOp(e, flags)
+		} else {
+			op = m.NextOp(e.state.rep)
+		}
 		if err := e.writeOp(op); err != nil {
+			if e.opt != nil {
+				e.opt.Unread()
+			}
 			return err
 		}
 		d.Discard(op.Len())
@@ -16,14 +16,20 @@ type matcher interface {
 	io.Writer
 	SetDict(d *encoderDict)
 	NextOp(rep [4]uint32) operation
+	FindMatches(ms []match, k int) []match
+	NiceLen() int
+	Reset()
 }
 
 // encoderDict provides the dictionary of the encoder. It includes an
 // addtional buffer atop of the actual dictionary.
 type encoderDict struct {
-	buf      buffer
-	m        matcher
-	head     int64
+	buf  buffer
+	m    matcher
+	head int64
+	// position up to which the data has been written into the
+	// matcher; the optimal parser moves it ahead of the head
+	mpos     int64
 	capacity int
 	// preallocated array
 	data [maxMatchLen]byte
@@ -49,6 +55,33 @@ func newEncoderDict(dictCap, bufSize int, m matcher) (d *encoderDict, err error)
 	return d, nil
 }
 
+// Reset clears the dictionary and resets the matcher. The buffer is
+// kept.
+func (d *encoderDict) Reset() {
+	d.buf.Reset()
+	d.head = 0
+	d.mpos = 0
+	d.m.Reset()
+}
+
+// preset puts the preset dictionary p into the dictionary and indexes
+// it with the matcher. The method must be called before any data has
+// been written.
+func (d *encoderDict) preset(p []byte) {
+	for len(p) > 0 {
+		n := len(p)
+		if n > maxMatchLen {
+			n = maxMatchLen
+		}
+		n, _ = d.Write(p[:n])
+		if n == 0 {
+			panic("lzma: no space for preset dictionary")
+		}
+		d.Discard(n)
+		p = p[n:]
+	}
+}
+
 // Discard discards n bytes. Note that n must not be larger than
 // MaxMatchLen.
 func (d *encoderDict) Discard(n int) {
@@ -58,7 +91,139 @@ func (d *encoderDict) Discard(n int) {
 		panic(fmt.Errorf("lzma: can't discard %d bytes", n))
 	}
 	d.head += int64(n)
-	d.m.Write(p)
+	if d.head > d.mpos {
+		d.m.Write(p[n-int(d.head-d.mpos):])
+		d.mpos = d.head
+	}
+}
+
+// feedMatcher writes the lookahead data up to offset k into the
+// matcher. So the matcher can find matches for the data at offset k.
+func (d *encoderDict) feedMatcher(k int) {
+	n := int(d.head + int64(k) - d.mpos)
+	if n <= 0 {
+		return
+	}
+	i := d.index(int(d.mpos - d.head))
+	if j := len(d.buf.data) - i; n > j {
+		d.m.Write(d.buf.data[i:])
+		n -= j
+		i = 0
+	}
+	d.m.Write(d.buf.data[i : i+n])
+	d.mpos = d.head + int64(k)
+}
+
+// index returns the index into the buffer data for the offset k
+// relative to the head. Negative offsets address the dictionary.
+func (d *encoderDict) index(k int) int {
+	i := d.buf.rear + k
+	if i < 0 {
+		i += len(d.buf.data)
+	} else if i >= len(d.buf.data) {
+		i -= len(d.buf.data)
+	}
+	return i
+}
+
+// peekByte returns the byte at offset k of the lookahead data.
+func (d *encoderDict) peekByte(k int) byte {
+	return d.buf.data[d.index(k)]
+}
+
+// byteAt returns the byte at the given distance in front of the
+// lookahead data at offset k. It returns zero if the distance is
+// outside of the dictionary.
+func (d *encoderDict) byteAt(k, distance int) byte {
+	if k >= distance {
+		return d.peekByte(k - distance)
+	}
+	return d.ByteAt(distance - k)
+}
+
+// maxDist returns the maximum distance supported for the lookahead data
+// at offset k.
+func (d *encoderDict) maxDist(k int) int {
+	n := d.DictLen() + k
+	if n > d.capacity {
+		return d.capacity
+	}
+	return n
+}
+
+// searchLimits returns the maximum length and the maximum distance of
+// matches for the lookahead data at offset k.
+func (d *encoderDict) searchLimits(k int) (maxLen, maxDist int) {
+	maxLen = d.Buffered() - k
+	if maxLen > maxMatchLen {
+		maxLen = maxMatchLen
+	}
+	return maxLen, d.maxDist(k)
+}
+
+// shortMatches appends the matches for the distances 1 to 3 to ms.
+// Matchers use them before searching their data structures.
+func (d *encoderDict) shortMatches(ms []match, k, maxLen, maxDist int,
+) []match {
+	for dist := 1; dist <= 3 && dist <= maxDist; dist++ {
+		ms = d.appendMatch(ms, k, dist, maxLen)
+	}
+	return ms
+}
+
+// matchesDone returns true if no better match needs to be searched.
+func matchesDone(ms []match, maxLen, niceLen int) bool {
+	if len(ms) == 0 {
+		return false
+	}
+	n := ms[len(ms)-1].n
+	return n >= maxLen || n >= niceLen
+}
+
+// appendMatch appends the match at the given distance for the lookahead
+// data at offset k to ms, if it is longer than the last match in ms.
+// The length is limited by max.
+func (d *encoderDict) appendMatch(ms []match, k, distance, max int) []match {
+	n := 1
+	if len(ms) > 0 {
+		n = ms[len(ms)-1].n
+	}
+	if n >= max {
+		return ms
+	}
+	// A longer match requires that the byte following the current
+	// length matches.
+	if d.peekByte(k+n) != d.byteAt(k+n, distance) {
+		return ms
+	}
+	if m := d.matchLen(k, distance, max); m > n {
+		ms = append(ms, match{int64(distance), m})
+	}
+	return ms
+}
+
+// matchLen returns the length of the match at the given distance for
+// the data at offset k. The length is limited by max, which must not
+// exceed the lookahead data available at offset k.
+func (d *encoderDict) matchLen(k, distance, max int) int {
+	data := d.buf.data
+	i := d.index(k)
+	j := i - distance
+	if j < 0 {
+		j += len(data)
+	}
+	for n := 0; n < max; n++ {
+		if data[i] != data[j] {
+			return n
+		}
+		if i++; i == len(data) {
+			i = 0
+		}
+		if j++; j == len(data) {
+			j = 0
+		}
+	}
+	return max
 }
 
 // Len returns the data available in the encoder dictionary.
@@ -16,8 +16,9 @@ import (
  * provide this capability.
  */
 
-// maxMatches limits the number of matches requested from the Matches
-// function. This controls the speed of the overall encoding.
+// maxMatches is the default for the number of matches requested from
+// the Matches function. This controls the speed of the overall
+// encoding.
 const maxMatches = 16
 
 // shortDists defines the number of short distances supported by the
@@ -58,9 +59,12 @@ type hashTable struct {
 	wr hash.Roller
 	// hash roller for computing arbitrary hashes
 	hr hash.Roller
-	// preallocated slices
-	p         [maxMatches]int64
-	distances [maxMatches + shortDists]int
+	// length of matches that stop the search
+	niceLen int
+	// preallocated slices; the length of p limits the number of
+	// positions checked
+	p         []int64
+	distances []int
 }
 
 // hashTableExponent derives the hash table exponent from the dictionary
@@ -100,11 +104,38 @@ func newHashTable(capacity int, wordLen int) (t *hashTable, err error) {
 		wr:      newRoller(wordLen),
 		hr:      newRoller(wordLen),
 	}
+	t.setParams(defaultNiceLen, 0)
 	return t, nil
 }
 
+// setParams sets the nice length and the number of positions checked.
+// The depth zero selects the default.
+func (t *hashTable) setParams(niceLen, depth int) error {
+	if depth == 0 {
+		depth = maxMatches
+	}
+	t.niceLen = niceLen
+	t.p = make([]int64, depth)
+	t.distances = make([]int, 0, depth+shortDists)
+	return nil
+}
+
+// NiceLen returns the length of matches that stop the search.
+func (t *hashTable) NiceLen() int { return t.niceLen }
+
 func (t *hashTable) SetDict(d *encoderDict) { t.dict = d }
 
+// Reset puts the hash table into its initial state. The allocated
+// arrays are kept.
+func (t *hashTable) Reset() {
+	for i := range t.t {
+		t.t[i] = 0
+	}
+	t.front = 0
+	t.hoff = -int64(t.wordLen)
+	t.wr = newRoller(t.wordLen)
+}
+
 // buffered returns the number of bytes that are currently hashed.
 func (t *hashTable) buffered() int {
 	n := t.hoff + 1
@@ -245,7 +276,7 @@ func (t *hashTable) NextOp(rep [4]uint32) operation {
 	if n < t.wordLen {
 		p = t.p[:0]
 	} else {
-		p = t.p[:maxMatches]
+		p = t.p
 		n = t.Matches(data[:t.wordLen], p)
 		p = p[:n]
 	}
@@ -295,8 +326,9 @@ func (t *hashTable) NextOp(rep [4]uint32) operation {
 		}
 		if n > m.n {
 			m = match{int64(dist), n}
-			if n == len(data) {
-				// No better match will be found.
+			if n == len(data) || n >= t.niceLen {
+				// No better match will be found or
+				// required.
 				break
 			}
 		}
@@ -307,3 +339,42 @@ func (t *hashTable) NextOp(rep [4]uint32) operation {
 	}
 	return m
 }
+
+// FindMatches appends the matches for the lookahead data at offset k
+// to ms. The matches have increasing lengths and for each length the
+// smallest distance is returned. The search stops if a match of the
+// nice length has been found.
+func (t *hashTable) FindMatches(ms []match, k int) []match {
+	d := t.dict
+	maxLen, maxDist := d.searchLimits(k)
+	if maxLen < minMatchLen {
+		return ms
+	}
+	ms = d.shortMatches(ms, k, maxLen, maxDist)
+	if matchesDone(ms, maxLen, t.niceLen) || maxLen < t.wordLen {
+		return ms
+	}
+	d.feedMatcher(k)
+	var word [4]byte
+	for i := 0; i < t.wordLen; i++ {
+		word[i] = d.peekByte(k + i)
+	}
+	p := t.p
+	n := t.Matches(word[:t.wordLen], p)
+	pos := d.head + int64(k)
+	// the positions are ordered by increasing distance
+	for _, q := range p[:n] {
+		dist := int(pos - q)
+		if dist <= 3 {
+			continue
+		}
+		if dist > maxDist {
+			break
+		}
+		ms = d.appendMatch(ms, k, dist, maxLen)
+		if matchesDone(ms, maxLen, t.niceLen) {
+			break
+		}
+	}
+	return ms
+}
@@ -10,16 +10,29 @@ import "errors"
 // dictionary.
 type MatchAlgorithm byte
 
-// Supported matcher algorithms.
+// Supported matcher algorithms. HC3 and HC4 are hash chains, BT2, BT3
+// and BT4 binary trees using a main hash over the given number of
+// bytes. They correspond to the match finders of the xz tool. The hash
+// chains are faster; the binary trees find more matches.
 const (
 	HashTable4 MatchAlgorithm = iota
 	BinaryTree
+	HC3
+	HC4
+	BT2
+	BT3
+	BT4
 )
 
 // maStrings are used by the String method.
 var maStrings = map[MatchAlgorithm]string{
 	HashTable4: "HashTable4",
 	BinaryTree: "BinaryTree",
+	HC3:        "HC3",
+	HC4:        "HC4",
+	BT2:        "BT2",
+	BT3:        "BT3",
+	BT4:        "BT4",
 }
 
 // String returns a string representation of the Matcher.
@@ -41,12 +54,40 @@ func (a MatchAlgorithm) verify() error {
 	return nil
 }
 
-func (a MatchAlgorithm) new(dictCap int) (m matcher, err error) {
+// new creates a matcher for the algorithm. The matcher stops the search
+// if a match of length niceLen has been found. The depth limits the
+// number of positions checked; the value zero selects the default of
+// the algorithm.
+func (a MatchAlgorithm) new(dictCap, niceLen, depth int) (m matcher,
+	err error) {
+
+	var p interface {
+		matcher
+		setParams(niceLen, depth int) error
+	}
 	switch a {
 	case HashTable4:
-		return newHashTable(dictCap, 4)
+		p, err = newHashTable(dictCap, 4)
 	case BinaryTree:
-		return newBinTree(dictCap)
+		p, err = newBinTree(dictCap)
+	case HC3:
+		p, err = newMatchFinder(dictCap, 3, false)
+	case HC4:
+		p, err = newMatchFinder(dictCap, 4, false)
+	case BT2:
+		p, err = newMatchFinder(dictCap, 2, true)
+	case BT3:
+		p, err = newMatchFinder(dictCap, 3, true)
+	case BT4:
+		p, err = newMatchFinder(dictCap, 4, true)
+	default:
+		return nil, errUnsupportedMatchAlgorithm
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err = p.setParams(niceLen, depth); err != nil {
+		return nil, err
 	}
-	return nil, errUnsupportedMatchAlgorithm
+	return p, nil
 }
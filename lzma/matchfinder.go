@@ -0,0 +1,568 @@
+// Copyright 2014-2017 Ulrich Kunitz. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package lzma
+
+import (
+	"errors"
+	"hash/crc32"
+	"math"
+)
+
+/* The hash chain and binary tree match finders follow the match finders
+ * of the xz tool. Hashes of the first two and three bytes locate the
+ * most recent position with the same prefix, so short matches near the
+ * head are found. The main hash of two, three or four bytes is the
+ * entry into the hash chains or binary trees, which link all positions
+ * of the dictionary with the same main hash.
+ *
+ * A binary tree node is cut at the nice length. Later searches rely on
+ * the bytes shared by the nodes of a subtree, so a node may only be
+ * inserted if at least nice length bytes follow the position. This is
+ * not the case for the last bytes written before a flush. These
+ * positions are searched without modifying the tree and remain pending
+ * until enough data has been written as done by the xz tool.
+ *
+ * Positions are stored as 32-bit values relative to a base position.
+ * The value zero marks an empty entry. Because the relative position of
+ * the first byte is the cyclic size, empty entries are never in the
+ * range of the dictionary.
+ */
+
+// Sizes of the hash tables for two and three bytes.
+const (
+	hash2Size = 1 << 10
+	hash3Size = 1 << 16
+)
+
+// maxFinderDictCap is the maximum dictionary capacity supported by the
+// match finders.
+const maxFinderDictCap = 3 << 29
+
+// crcTable provides the byte mixing for the hash functions.
+var crcTable = crc32.IEEETable
+
+// matchFinder implements the hash chain and binary tree match finders.
+type matchFinder struct {
+	dict *encoderDict
+	// number of bytes hashed by the main hash
+	hashBytes int
+	// tree selects binary trees instead of hash chains
+	tree bool
+	// hash tables for two and three bytes; nil if not used
+	hash2 []uint32
+	hash3 []uint32
+	// main hash table
+	hash     []uint32
+	hashMask uint32
+	// son stores the hash chains or two child nodes per position
+	son        []uint32
+	cyclicSize uint32
+	// index into son for the position pos
+	cyclicPos uint32
+	// absolute position for the relative position zero
+	base int64
+	// number of bytes written into the match finder
+	n int64
+	// next position to insert
+	pos int64
+	// relative position triggering the normalization
+	limit uint32
+	// number of positions checked in a chain or tree
+	depth int
+	// length of matches that stop the search
+	niceLen int
+	// preallocated slice
+	ms []match
+}
+
+// mainHashMask computes the mask for the main hash following the xz
+// tool.
+func mainHashMask(dictCap int, hashBytes int) uint32 {
+	if hashBytes == 2 {
+		return 0xffff
+	}
+	hs := uint32(dictCap - 1)
+	hs |= hs >> 1
+	hs |= hs >> 2
+	hs |= hs >> 4
+	hs |= hs >> 8
+	hs >>= 1
+	hs |= 0xffff
+	if hs > 1<<24 {
+		if hashBytes == 3 {
+			hs = 1<<24 - 1
+		} else {
+			hs >>= 1
+		}
+	}
+	return hs
+}
+
+// newMatchFinder creates a match finder for the given dictionary
+// capacity. The argument hashBytes gives the number of bytes used for
+// the main hash. The tree argument selects binary trees instead of
+// hash chains.
+func newMatchFinder(dictCap int, hashBytes int, tree bool,
+) (t *matchFinder, err error) {
+	if !(0 < dictCap && dictCap <= maxFinderDictCap) {
+		return nil, errors.New(
+			"lzma: dictionary capacity out of range for match finder")
+	}
+	if !(2 <= hashBytes && hashBytes <= 4) {
+		return nil, errors.New(
+			"lzma: unsupported number of hash bytes")
+	}
+	t = &matchFinder{
+		hashBytes:  hashBytes,
+		tree:       tree,
+		hashMask:   mainHashMask(dictCap, hashBytes),
+		cyclicSize: uint32(dictCap) + 1,
+		limit:      math.MaxUint32,
+		ms:         make([]match, 0, maxMatchLen),
+	}
+	t.base = -int64(t.cyclicSize)
+	t.setParams(defaultNiceLen, 0)
+	t.hash = make([]uint32, t.hashMask+1)
+	if hashBytes >= 3 {
+		t.hash2 = make([]uint32, hash2Size)
+	}
+	if hashBytes >= 4 {
+		t.hash3 = make([]uint32, hash3Size)
+	}
+	if tree {
+		t.son = make([]uint32, 2*t.cyclicSize)
+	} else {
+		t.son = make([]uint32, t.cyclicSize)
+	}
+	return t, nil
+}
+
+// setParams sets the nice length and the depth of the search. The
+// depth zero selects a default derived from the nice length as the xz
+// tool does.
+func (t *matchFinder) setParams(niceLen, depth int) error {
+	if niceLen < t.hashBytes {
+		return errors.New(
+			"lzma: nice length too small for match finder")
+	}
+	if depth == 0 {
+		if t.tree {
+			depth = 16 + niceLen/2
+		} else {
+			depth = 4 + niceLen/4
+		}
+	}
+	t.niceLen = niceLen
+	t.depth = depth
+	return nil
+}
+
+// NiceLen returns the length of matches that stop the search.
+func (t *matchFinder) NiceLen() int { return t.niceLen }
+
+// SetDict sets the dictionary of the match finder.
+func (t *matchFinder) SetDict(d *encoderDict) { t.dict = d }
+
+// Reset puts the match finder into its initial state. Only the hash
+// tables need to be cleared, because the chains and trees are only
+// accessed through them.
+func (t *matchFinder) Reset() {
+	for _, a := range [][]uint32{t.hash2, t.hash3, t.hash} {
+		for i := range a {
+			a[i] = 0
+		}
+	}
+	t.cyclicPos = 0
+	t.base = -int64(t.cyclicSize)
+	t.n = 0
+	t.pos = 0
+	t.limit = math.MaxUint32
+}
+
+// Write inserts the positions of the bytes written into the hash
+// chains or binary trees. Positions for which matches have been
+// searched already are skipped. The method never returns an error.
+func (t *matchFinder) Write(p []byte) (n int, err error) {
+	t.n += int64(len(p))
+	t.insertPending(t.n)
+	return len(p), nil
+}
+
+// avail returns the number of bytes available for matches at the next
+// position to insert.
+func (t *matchFinder) avail() int {
+	d := t.dict
+	avail := d.Buffered() - int(t.pos-d.head)
+	if avail > maxMatchLen {
+		avail = maxMatchLen
+	}
+	return avail
+}
+
+// insertPending inserts the positions in front of end. Positions of
+// the binary trees are only inserted if nice length bytes are
+// available.
+func (t *matchFinder) insertPending(end int64) {
+	for t.pos < end {
+		if t.tree && t.avail() < t.niceLen {
+			return
+		}
+		t.process(nil, false)
+	}
+}
+
+// wrap wraps an index into the dictionary buffer.
+func (t *matchFinder) wrap(i int) int {
+	if n := len(t.dict.buf.data); i >= n {
+		return i - n
+	}
+	return i
+}
+
+// back returns the index into the dictionary buffer that lies delta
+// bytes before index i.
+func (t *matchFinder) back(i int, delta uint32) int {
+	j := i - int(delta)
+	if j < 0 {
+		j += len(t.dict.buf.data)
+	}
+	return j
+}
+
+// matchLen returns the length of the match at distance delta for the
+// data at buffer index i. The first n bytes are known to be equal; the
+// length is limited by max.
+func (t *matchFinder) matchLen(i int, delta uint32, n, max int) int {
+	data := t.dict.buf.data
+	j := t.back(i, delta)
+	for ; n < max; n++ {
+		if data[t.wrap(i+n)] != data[t.wrap(j+n)] {
+			break
+		}
+	}
+	return n
+}
+
+// cyclicIndex returns the index into son for the position delta bytes
+// before pos.
+func (t *matchFinder) cyclicIndex(delta uint32) uint32 {
+	if delta > t.cyclicPos {
+		return t.cyclicPos - delta + t.cyclicSize
+	}
+	return t.cyclicPos - delta
+}
+
+// normalize reduces all stored positions, so that the relative
+// positions don't overflow.
+func (t *matchFinder) normalize() {
+	sub := uint32(t.pos-t.base) - t.cyclicSize
+	for _, a := range [][]uint32{t.hash2, t.hash3, t.hash, t.son} {
+		for i, v := range a {
+			if v <= sub {
+				a[i] = 0
+			} else {
+				a[i] = v - sub
+			}
+		}
+	}
+	t.base += int64(sub)
+}
+
+// process inserts the position pos into the hash tables and the hash
+// chains or binary trees. If find is set, the matches for the position
+// are appended to ms. Positions without enough data for the main hash
+// are not inserted. Binary tree positions with less than nice length
+// bytes available are only searched and remain pending.
+func (t *matchFinder) process(ms []match, find bool) []match {
+	d := t.dict
+	k := int(t.pos - d.head)
+	avail := t.avail()
+	lenLimit := avail
+	if lenLimit > t.niceLen {
+		lenLimit = t.niceLen
+	}
+	insert := !t.tree || lenLimit == t.niceLen
+	if lenLimit < t.hashBytes {
+		if insert {
+			t.advance()
+		}
+		return ms
+	}
+	if !insert && !find {
+		return ms
+	}
+	if insert && uint32(t.pos-t.base) >= t.limit {
+		t.normalize()
+	}
+	pos := uint32(t.pos - t.base)
+
+	data := d.buf.data
+	i := d.index(k)
+	b0, b1 := data[i], data[t.wrap(i+1)]
+	var h2, h3, hv uint32
+	switch t.hashBytes {
+	case 2:
+		hv = uint32(b0) | uint32(b1)<<8
+	case 3:
+		tmp := crcTable[b0] ^ uint32(b1)
+		h2 = tmp & (hash2Size - 1)
+		hv = (tmp ^ uint32(data[t.wrap(i+2)])<<8) & t.hashMask
+	default:
+		tmp := crcTable[b0] ^ uint32(b1)
+		h2 = tmp & (hash2Size - 1)
+		tmp ^= uint32(data[t.wrap(i+2)]) << 8
+		h3 = tmp & (hash3Size - 1)
+		hv = (tmp ^ crcTable[data[t.wrap(i+3)]]<<5) & t.hashMask
+	}
+
+	lenBest := 1
+	if t.hashBytes >= 3 {
+		delta2 := pos - t.hash2[h2]
+		delta3 := t.cyclicSize
+		if t.hash3 != nil {
+			delta3 = pos - t.hash3[h3]
+		}
+		if insert {
+			t.hash2[h2] = pos
+			if t.hash3 != nil {
+				t.hash3[h3] = pos
+			}
+		}
+		if find {
+			// The hashes ensure that the bytes following the
+			// first byte are equal.
+			var dist uint32
+			if delta2 < t.cyclicSize && data[t.back(i, delta2)] == b0 {
+				lenBest = 2
+				ms = append(ms, match{int64(delta2), 2})
+				dist = delta2
+			}
+			if delta3 != delta2 && delta3 < t.cyclicSize &&
+				data[t.back(i, delta3)] == b0 {
+				lenBest = 3
+				ms = append(ms, match{int64(delta3), 3})
+				dist = delta3
+			}
+			if dist != 0 {
+				lenBest = t.matchLen(i, dist, lenBest, lenLimit)
+				ms[len(ms)-1].n = lenBest
+				if lenBest == lenLimit {
+					find = false
+				}
+			}
+			if lenBest < t.hashBytes {
+				lenBest = t.hashBytes - 1
+			}
+		}
+	}
+	curMatch := t.hash[hv]
+	if insert {
+		t.hash[hv] = pos
+	}
+
+	switch {
+	case !insert:
+		if find {
+			ms = t.treeFind(ms, i, pos, curMatch, lenBest,
+				lenLimit)
+		}
+	case t.tree:
+		ms = t.treeSearch(ms, find, i, pos, curMatch, lenBest, lenLimit)
+	default:
+		t.son[t.cyclicPos] = curMatch
+		if find {
+			ms = t.chainSearch(ms, i, pos, curMatch, lenBest,
+				lenLimit)
+		}
+	}
+
+	// extend a match of length niceLen as far as possible
+	if n := len(ms); n > 0 && ms[n-1].n == lenLimit && lenLimit < avail {
+		m := &ms[n-1]
+		m.n = t.matchLen(i, uint32(m.distance), m.n, avail)
+	}
+	if insert {
+		t.advance()
+	}
+	return ms
+}
+
+// advance moves to the next position.
+func (t *matchFinder) advance() {
+	t.pos++
+	t.cyclicPos++
+	if t.cyclicPos == t.cyclicSize {
+		t.cyclicPos = 0
+	}
+}
+
+// chainSearch appends the matches found in the hash chain starting
+// with curMatch that are longer than lenBest.
+func (t *matchFinder) chainSearch(ms []match, i int, pos, curMatch uint32,
+	lenBest, lenLimit int) []match {
+
+	data := t.dict.buf.data
+	for depth := t.depth; depth > 0; depth-- {
+		delta := pos - curMatch
+		if delta >= t.cyclicSize {
+			break
+		}
+		curMatch = t.son[t.cyclicIndex(delta)]
+		j := t.back(i, delta)
+		if data[t.wrap(j+lenBest)] != data[t.wrap(i+lenBest)] ||
+			data[j] != data[i] {
+			continue
+		}
+		n := t.matchLen(i, delta, 1, lenLimit)
+		if n > lenBest {
+			lenBest = n
+			ms = append(ms, match{int64(delta), n})
+			if n == lenLimit {
+				break
+			}
+		}
+	}
+	return ms
+}
+
+// treeSearch inserts the position into the binary tree starting at
+// curMatch. If find is set, the matches longer than lenBest are
+// appended to ms.
+func (t *matchFinder) treeSearch(ms []match, find bool, i int,
+	pos, curMatch uint32, lenBest, lenLimit int) []match {
+
+	data := t.dict.buf.data
+	ptr0 := 2*t.cyclicPos + 1
+	ptr1 := 2 * t.cyclicPos
+	len0, len1 := 0, 0
+	for depth := t.depth; ; depth-- {
+		delta := pos - curMatch
+		if depth == 0 || delta >= t.cyclicSize {
+			t.son[ptr0] = 0
+			t.son[ptr1] = 0
+			return ms
+		}
+		pair := 2 * t.cyclicIndex(delta)
+		j := t.back(i, delta)
+		n := len0
+		if len1 < n {
+			n = len1
+		}
+		if data[t.wrap(j+n)] == data[t.wrap(i+n)] {
+			n = t.matchLen(i, delta, n+1, lenLimit)
+			if find && n > lenBest {
+				lenBest = n
+				ms = append(ms, match{int64(delta), n})
+			}
+			if n == lenLimit {
+				t.son[ptr1] = t.son[pair]
+				t.son[ptr0] = t.son[pair+1]
+				return ms
+			}
+		}
+		if data[t.wrap(j+n)] < data[t.wrap(i+n)] {
+			t.son[ptr1] = curMatch
+			ptr1 = pair + 1
+			curMatch = t.son[ptr1]
+			len1 = n
+		} else {
+			t.son[ptr0] = curMatch
+			ptr0 = pair
+			curMatch = t.son[ptr0]
+			len0 = n
+		}
+	}
+}
+
+// treeFind appends the matches longer than lenBest found in the binary
+// tree starting with curMatch to ms. The tree is not modified.
+func (t *matchFinder) treeFind(ms []match, i int, pos, curMatch uint32,
+	lenBest, lenLimit int) []match {
+
+	data := t.dict.buf.data
+	len0, len1 := 0, 0
+	for depth := t.depth; depth > 0; depth-- {
+		delta := pos - curMatch
+		if delta >= t.cyclicSize {
+			break
+		}
+		pair := 2 * t.cyclicIndex(delta)
+		j := t.back(i, delta)
+		n := len0
+		if len1 < n {
+			n = len1
+		}
+		if data[t.wrap(j+n)] == data[t.wrap(i+n)] {
+			n = t.matchLen(i, delta, n+1, lenLimit)
+			if n > lenBest {
+				lenBest = n
+				ms = append(ms, match{int64(delta), n})
+			}
+			if n == lenLimit {
+				break
+			}
+		}
+		if data[t.wrap(j+n)] < data[t.wrap(i+n)] {
+			curMatch = t.son[pair+1]
+			len1 = n
+		} else {
+			curMatch = t.son[pair]
+			len0 = n
+		}
+	}
+	return ms
+}
+
+// FindMatches appends the matches for the lookahead data at offset k
+// to ms. The matches have increasing lengths. The search stops at the
+// nice length of the match finder; a match of that length is extended
+// as far as possible. Matches can only be found for positions that
+// haven't been inserted into the match finder yet.
+func (t *matchFinder) FindMatches(ms []match, k int) []match {
+	d := t.dict
+	d.feedMatcher(k)
+	pos := d.head + int64(k)
+	t.insertPending(pos)
+	if pos != t.pos {
+		return ms
+	}
+	return t.process(ms, true)
+}
+
+// NextOp returns the longest match or repetition at the head of the
+// dictionary.
+func (t *matchFinder) NextOp(rep [4]uint32) operation {
+	d := t.dict
+	ms := t.FindMatches(t.ms[:0], 0)
+	var m match
+	if n := len(ms); n > 0 {
+		m = ms[n-1]
+		// A short match at a large distance is more expensive
+		// than literals.
+		if m.n == minMatchLen && m.distance > 128 {
+			m = match{}
+		}
+	}
+	maxLen, maxDist := d.searchLimits(0)
+	for _, r := range rep {
+		dist := int(r) + minDistance
+		if dist > maxDist {
+			continue
+		}
+		// repetitions are cheaper than matches of the same length
+		n := d.matchLen(0, dist, maxLen)
+		if n >= minMatchLen && n >= m.n {
+			m = match{int64(dist), n}
+		}
+	}
+	if m.n > 0 {
+		return m
+	}
+	dist := int(rep[0]) + minDistance
+	if dist <= maxDist && d.matchLen(0, dist, 1) == 1 {
+		return match{int64(dist), 1}
+	}
+	return lit{d.peekByte(0)}
+}
@@ -0,0 +1,98 @@
+// Copyright 2014-2017 Ulrich Kunitz. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package lzma
+
+import (
+	"bytes"
+	"io"
+	"io/ioutil"
+	"math/rand"
+	"testing"
+
+	"github.com/ulikunitz/xz/internal/randtxt"
+)
+
+func TestMatchFinder_FindMatches(t *testing.T) {
+	var buf bytes.Buffer
+	io.CopyN(&buf, randtxt.NewReader(rand.NewSource(7)), 20000)
+	txt := buf.Bytes()
+	for _, ma := range []MatchAlgorithm{HC3, HC4, BT2, BT3, BT4} {
+		m, err := ma.new(MinDictCap, defaultNiceLen, 0)
+		if err != nil {
+			t.Fatalf("%s: new error %s", ma, err)
+		}
+		d, err := newEncoderDict(MinDictCap, 4096, m)
+		if err != nil {
+			t.Fatalf("newEncoderDict error %s", err)
+		}
+		found := 0
+		for p := txt; len(p) > 0; {
+			n, _ := d.Write(p)
+			p = p[n:]
+			for d.Buffered() > 0 {
+				ms := m.FindMatches(nil, 0)
+				prev := 1
+				for _, x := range ms {
+					if x.n <= prev {
+						t.Fatalf("%s: lengths not increasing",
+							ma)
+					}
+					prev = x.n
+					if !(1 <= x.distance &&
+						x.distance <= int64(d.maxDist(0))) {
+						t.Fatalf("%s: distance %d out of range",
+							ma, x.distance)
+					}
+					k := d.matchLen(0, int(x.distance), x.n)
+					if k != x.n {
+						t.Fatalf("%s: match %+v has length %d",
+							ma, x, k)
+					}
+				}
+				found += len(ms)
+				d.Discard(1)
+			}
+		}
+		if found == 0 {
+			t.Fatalf("%s: no matches found", ma)
+		}
+	}
+}
+
+func TestMatchFinder_Normalize(t *testing.T) {
+	var tbuf bytes.Buffer
+	io.CopyN(&tbuf, randtxt.NewReader(rand.NewSource(42)), 100000)
+	txt := tbuf.Bytes()
+	for _, ma := range []MatchAlgorithm{HC4, BT4} {
+		var buf bytes.Buffer
+		w, err := Writer2Config{DictCap: MinDictCap, Matcher: ma,
+			Mode: ModeNormal}.NewWriter2(&buf)
+		if err != nil {
+			t.Fatalf("NewWriter2 error %s", err)
+		}
+		mf := w.encoder.dict.m.(*matchFinder)
+		mf.limit = 3 * mf.cyclicSize
+		if _, err = w.Write(txt); err != nil {
+			t.Fatalf("w.Write error %s", err)
+		}
+		if err = w.Close(); err != nil {
+			t.Fatalf("w.Close error %s", err)
+		}
+		if mf.base <= 0 {
+			t.Fatalf("%s: no normalization", ma)
+		}
+		r, err := Reader2Config{DictCap: MinDictCap}.NewReader2(&buf)
+		if err != nil {
+			t.Fatalf("NewReader2 error %s", err)
+		}
+		out, err := ioutil.ReadAll(r)
+		if err != nil {
+			t.Fatalf("ReadAll error %s", err)
+		}
+		if !bytes.Equal(out, txt) {
+			t.Fatalf("%s: decompressed data differs", ma)
+		}
+	}
+}
@@ -0,0 +1,45 @@
+// Copyright 2014-2017 Ulrich Kunitz. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package lzma
+
+import "fmt"
+
+// MemLimitError is returned by the readers if the estimated memory
+// required for decoding exceeds the configured memory limit.
+type MemLimitError struct {
+	// estimated memory requirement in bytes
+	Required int64
+	// memory limit in bytes
+	Limit int64
+}
+
+// Error returns the error message.
+func (e *MemLimitError) Error() string {
+	return fmt.Sprintf("lzma: decoding requires %d bytes of memory; "+
+		"limit is %d bytes", e.Required, e.Limit)
+}
+
+// decoderOverhead approximates the memory used by the decoder state
+// without the literal probabilities.
+const decoderOverhead = 1 << 14
+
+// decoderMemUsage estimates the memory in bytes required by a decoder
+// using a dictionary with the given capacity and the given sum of the
+// literal context and literal position bits.
+func decoderMemUsage(dictCap int, lclp int) int64 {
+	return int64(dictCap) + 1 + 2*(0x300<<uint(lclp)) + decoderOverhead
+}
+
+// checkMemLimit returns a MemLimitError if the decoder memory usage
+// exceeds the limit. A limit of zero means no limit.
+func checkMemLimit(limit int64, dictCap int, lclp int) error {
+	if limit <= 0 {
+		return nil
+	}
+	if m := decoderMemUsage(dictCap, lclp); m > limit {
+		return &MemLimitError{Required: m, Limit: limit}
+	}
+	return nil
+}
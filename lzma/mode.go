@@ -0,0 +1,50 @@
+// Copyright 2014-2017 Ulrich Kunitz. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package lzma
+
+import "errors"
+
+// Mode selects the method the encoder uses to choose the operations
+// for the LZMA stream.
+type Mode byte
+
+// Supported encoder modes. ModeFast selects the next operation
+// greedily. ModeNormal computes the cheapest sequence of operations
+// over a lookahead window using the prices derived from the current
+// probability values. It compresses better but is slower.
+const (
+	ModeFast Mode = iota
+	ModeNormal
+)
+
+// modeStrings are used by the String method.
+var modeStrings = map[Mode]string{
+	ModeFast:   "fast",
+	ModeNormal: "normal",
+}
+
+// String returns a string representation of the mode.
+func (m Mode) String() string {
+	if s, ok := modeStrings[m]; ok {
+		return s
+	}
+	return "unknown"
+}
+
+// verify checks whether the mode value is supported.
+func (m Mode) verify() error {
+	if _, ok := modeStrings[m]; !ok {
+		return errors.New("lzma: unsupported encoder mode")
+	}
+	return nil
+}
+
+// flags returns the encoder flags for the mode.
+func (m Mode) flags() encoderFlags {
+	if m == ModeNormal {
+		return optimal
+	}
+	return 0
+}
@@ -0,0 +1,395 @@
+// Copyright 2014-2017 Ulrich Kunitz. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package lzma
+
+/* The optimizer implements the optimal parsing of the LZMA SDK. It
+ * computes the cheapest sequence of operations for a window of the
+ * lookahead data. The prices of the operations are derived from the
+ * probability values of the encoder state at the start of the window.
+ * The window ends if a match of at least niceLen bytes has been found
+ * or no operation reaches beyond the current position.
+ */
+
+// Constants for the optimizer.
+const (
+	// maximum number of positions in the optimizer window
+	optWindow = 1 << 12
+	// default length of a match that is accepted without further
+	// optimization
+	defaultNiceLen = 64
+	// number of operations after which the price tables are
+	// recomputed
+	priceUpdateInterval = 128
+	// number of full distances with prices in the distance table
+	fullDistances = 1 << (endPosModel >> 1)
+)
+
+// optNode describes the cheapest sequence of operations found to reach a
+// position of the window.
+type optNode struct {
+	price uint32
+	// position of the predecessor
+	prev int
+	// operation leading to this node; a zero distance denotes a
+	// literal
+	op match
+	// state and repetition distances after the operation
+	state uint32
+	rep   [4]uint32
+}
+
+// optimizer computes operation sequences for the encoder.
+type optimizer struct {
+	nodes   []optNode
+	matches []match
+	niceLen int
+	// plan contains the operations computed; next is the index of
+	// the next operation to be written
+	plan []operation
+	next int
+	// operations written since the last update of the price tables
+	count int
+	// matches found at position savedPos, where the last window
+	// ended; they can't be searched again
+	saved    []match
+	savedPos int64
+
+	lenPrices     [1 << maxPosBits][maxMatchLen - minMatchLen + 1]uint32
+	repLenPrices  [1 << maxPosBits][maxMatchLen - minMatchLen + 1]uint32
+	posSlotPrices [lenStates][maxPosSlot + 1]uint32
+	distPrices    [lenStates][fullDistances]uint32
+	alignPrices   [1 << alignBits]uint32
+}
+
+// newOptimizer creates a new optimizer. Matches of length niceLen are
+// accepted without further optimization.
+func newOptimizer(niceLen int) *optimizer {
+	return &optimizer{
+		nodes:    make([]optNode, optWindow+maxMatchLen+1),
+		matches:  make([]match, 0, maxMatchLen),
+		saved:    make([]match, 0, maxMatchLen),
+		savedPos: -1,
+		niceLen:  niceLen,
+		count:    priceUpdateInterval,
+	}
+}
+
+// Reset puts the optimizer into its initial state. The planned
+// operations and the saved matches are discarded.
+func (o *optimizer) Reset() {
+	o.plan = o.plan[:0]
+	o.next = 0
+	o.count = priceUpdateInterval
+	o.saved = o.saved[:0]
+	o.savedPos = -1
+}
+
+// NextOp returns the next operation for the encoder. A new sequence of
+// operations is computed if no operation is planned anymore. If the
+// all flag is set the window may extend to the end of the buffered
+// data.
+//
+// The planned operations stay valid if the encoder state is reset,
+// because the encoder derives repetitions from the distances. Only a
+// short repetition must be replaced by a literal if the distance
+// doesn't match anymore.
+func (o *optimizer) NextOp(e *encoder, flags compressFlags) operation {
+	if o.next >= len(o.plan) {
+		o.optimize(e, flags)
+	}
+	op := o.plan[o.next]
+	o.next++
+	o.count++
+	if m, ok := op.(match); ok && m.n == 1 &&
+		uint32(m.distance-minDistance) != e.state.rep[0] {
+		return lit{e.dict.peekByte(0)}
+	}
+	return op
+}
+
+// Unread returns the last operation to the plan. It is used if the
+// operation couldn't be written.
+func (o *optimizer) Unread() {
+	o.next--
+	o.count--
+}
+
+// updatePrices recomputes the price tables from the probability values
+// of the encoder state.
+func (o *optimizer) updatePrices(s *state) {
+	posStates := uint32(1) << uint(s.Properties.PB)
+	for ps := uint32(0); ps < posStates; ps++ {
+		for l := range o.lenPrices[ps] {
+			o.lenPrices[ps][l] = s.lenCodec.price(uint32(l), ps)
+			o.repLenPrices[ps][l] = s.repLenCodec.price(uint32(l), ps)
+		}
+	}
+	dc := &s.distCodec
+	for ls := range o.posSlotPrices {
+		for slot := range o.posSlotPrices[ls] {
+			price := dc.posSlotCodecs[ls].price(uint32(slot))
+			if slot >= endPosModel {
+				bits := (slot >> 1) - 1 - alignBits
+				price += uint32(bits) * directBitPrice
+			}
+			o.posSlotPrices[ls][slot] = price
+		}
+		for dist := range o.distPrices[ls] {
+			slot := posSlot(uint32(dist))
+			price := o.posSlotPrices[ls][slot]
+			if slot >= startPosModel {
+				tc := &dc.posModel[slot-startPosModel]
+				price += tc.price(uint32(dist))
+			}
+			o.distPrices[ls][dist] = price
+		}
+	}
+	for i := range o.alignPrices {
+		o.alignPrices[i] = dc.alignCodec.price(uint32(i))
+	}
+	o.count = 0
+}
+
+// distPrice returns the price of the distance value dist for the
+// encoded length l.
+func (o *optimizer) distPrice(dist uint32, l uint32) uint32 {
+	ls := lenState(l)
+	if dist < fullDistances {
+		return o.distPrices[ls][dist]
+	}
+	return o.posSlotPrices[ls][posSlot(dist)] +
+		o.alignPrices[dist&(1<<alignBits-1)]
+}
+
+// repPrice returns the price for the selection of repetition distance
+// g. The length price is not included.
+func repPrice(s *state, g int, state, state2 uint32) uint32 {
+	switch g {
+	case 0:
+		return s.isRepG0[state].price(0) + s.isRepG0Long[state2].price(1)
+	case 1:
+		return s.isRepG0[state].price(1) + s.isRepG1[state].price(0)
+	case 2:
+		return s.isRepG0[state].price(1) + s.isRepG1[state].price(1) +
+			s.isRepG2[state].price(0)
+	}
+	return s.isRepG0[state].price(1) + s.isRepG1[state].price(1) +
+		s.isRepG2[state].price(1)
+}
+
+// stateLiteral returns the state following a literal.
+func stateLiteral(s uint32) uint32 {
+	switch {
+	case s < 4:
+		return 0
+	case s < 10:
+		return s - 3
+	}
+	return s - 6
+}
+
+// stateAfter returns the state following a match, repetition or short
+// repetition.
+func stateAfter(s uint32, short, long uint32) uint32 {
+	if s < 7 {
+		return short
+	}
+	return long
+}
+
+// update records a cheaper way to reach position i of the window.
+func (o *optimizer) update(i int, price uint32, prev int, op match) {
+	if n := &o.nodes[i]; price < n.price {
+		n.price, n.prev, n.op = price, prev, op
+	}
+}
+
+// extend makes the positions up to i available in the window.
+func (o *optimizer) extend(end, i int) int {
+	for ; end < i; end++ {
+		o.nodes[end+1].price = infinitePrice
+	}
+	return end
+}
+
+// setState computes the state and the repetition distances of the node
+// at position i from its predecessor. The computation mirrors the
+// writeMatch method of the encoder.
+func (o *optimizer) setState(i int) {
+	n := &o.nodes[i]
+	p := &o.nodes[n.prev]
+	n.state, n.rep = p.state, p.rep
+	if n.op.distance == 0 {
+		n.state = stateLiteral(n.state)
+		return
+	}
+	dist := uint32(n.op.distance - minDistance)
+	g := 0
+	for ; g < 4; g++ {
+		if n.rep[g] == dist {
+			break
+		}
+	}
+	switch {
+	case g == 4:
+		n.rep = [4]uint32{dist, n.rep[0], n.rep[1], n.rep[2]}
+		n.state = stateAfter(n.state, 7, 10)
+	case g == 0 && n.op.n == 1:
+		n.state = stateAfter(n.state, 9, 11)
+	default:
+		copy(n.rep[1:g+1], n.rep[:g])
+		n.rep[0] = dist
+		n.state = stateAfter(n.state, 8, 11)
+	}
+}
+
+// optimize computes the operation sequence for the next window of the
+// lookahead data.
+func (o *optimizer) optimize(e *encoder, flags compressFlags) {
+	o.plan = o.plan[:0]
+	o.next = 0
+	if o.count >= priceUpdateInterval {
+		o.updatePrices(e.state)
+	}
+	d := e.dict
+	limit := d.Buffered()
+	if flags&all == 0 {
+		limit -= maxMatchLen - 1
+	}
+	if limit > optWindow {
+		limit = optWindow
+	}
+
+	o.nodes[0] = optNode{state: e.state.state, rep: e.state.rep}
+	var ms []match
+	if o.savedPos == d.head {
+		ms = append(o.matches[:0], o.saved...)
+	} else {
+		ms = d.m.FindMatches(o.matches[:0], 0)
+	}
+	o.savedPos = -1
+
+	// Long repetitions and matches are accepted directly.
+	maxLen, maxDist := d.searchLimits(0)
+	for _, r := range e.state.rep {
+		dist := int(r) + minDistance
+		if dist > maxDist {
+			continue
+		}
+		if n := d.matchLen(0, dist, maxLen); n >= o.niceLen {
+			o.plan = append(o.plan, match{int64(dist), n})
+			return
+		}
+	}
+	if k := len(ms); k > 0 && ms[k-1].n >= o.niceLen {
+		o.plan = append(o.plan, ms[k-1])
+		return
+	}
+
+	end := o.relax(e, 0, 0, ms)
+	cur := 1
+	for ; cur < end && cur < limit; cur++ {
+		o.setState(cur)
+		ms = d.m.FindMatches(o.matches[:0], cur)
+		if k := len(ms); k > 0 && ms[k-1].n >= o.niceLen {
+			o.saved = append(o.saved[:0], ms...)
+			o.savedPos = d.head + int64(cur)
+			break
+		}
+		end = o.relax(e, cur, end, ms)
+	}
+	o.backtrack(d, cur)
+}
+
+// relax updates the nodes reachable by a single operation from the
+// node at position cur and returns the new end of the window.
+func (o *optimizer) relax(e *encoder, cur, end int, ms []match) int {
+	d := e.dict
+	s := e.state
+	n := &o.nodes[cur]
+	pos := d.head + int64(cur)
+	posState := uint32(pos) & s.posBitMask
+	state := n.state
+	state2 := state<<maxPosBits | posState
+	maxLen, maxDist := d.searchLimits(cur)
+	if cur > 0 && maxLen > o.niceLen {
+		maxLen = o.niceLen
+	}
+
+	// literal
+	end = o.extend(end, cur+1)
+	c := d.peekByte(cur)
+	rep0 := int(n.rep[0]) + minDistance
+	matchByte := d.byteAt(cur, rep0)
+	litState := s.litState(d.byteAt(cur, 1), pos)
+	matchPrice := n.price + s.isMatch[state2].price(1)
+	o.update(cur+1, n.price+s.isMatch[state2].price(0)+
+		s.litCodec.price(c, state, matchByte, litState),
+		cur, match{})
+
+	// short repetition
+	repPrice0 := matchPrice + s.isRep[state].price(1)
+	if rep0 <= maxDist && c == matchByte {
+		o.update(cur+1, repPrice0+s.isRepG0[state].price(0)+
+			s.isRepG0Long[state2].price(0),
+			cur, match{int64(rep0), 1})
+	}
+
+	// repetitions
+	for g, r := range n.rep {
+		dist := int(r) + minDistance
+		if dist > maxDist {
+			continue
+		}
+		l := d.matchLen(cur, dist, maxLen)
+		if l < minMatchLen {
+			continue
+		}
+		end = o.extend(end, cur+l)
+		price := repPrice0 + repPrice(s, g, state, state2)
+		for ; l >= minMatchLen; l-- {
+			o.update(cur+l, price+o.repLenPrices[posState][l-minMatchLen],
+				cur, match{int64(dist), l})
+		}
+	}
+
+	// matches
+	price := matchPrice + s.isRep[state].price(0)
+	l := minMatchLen
+	for _, m := range ms {
+		if m.n > maxLen {
+			m.n = maxLen
+		}
+		end = o.extend(end, cur+m.n)
+		dist := uint32(m.distance - minDistance)
+		for ; l <= m.n; l++ {
+			k := uint32(l - minMatchLen)
+			o.update(cur+l, price+o.lenPrices[posState][k]+
+				o.distPrice(dist, k), cur, match{m.distance, l})
+		}
+	}
+	return end
+}
+
+// backtrack converts the operations leading to position cur into the
+// plan.
+func (o *optimizer) backtrack(d *encoderDict, cur int) {
+	k := 0
+	for i := cur; i > 0; i = o.nodes[i].prev {
+		k++
+	}
+	for len(o.plan) < k {
+		o.plan = append(o.plan, nil)
+	}
+	for i := cur; i > 0; i = o.nodes[i].prev {
+		k--
+		n := &o.nodes[i]
+		if n.op.distance == 0 {
+			o.plan[k] = lit{d.peekByte(n.prev)}
+		} else {
+			o.plan[k] = n.op
+		}
+	}
+}
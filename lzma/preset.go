@@ -0,0 +1,116 @@
+// Copyright 2014-2017 Ulrich Kunitz. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package lzma
+
+import "errors"
+
+// MinPreset and MaxPreset provide the range of the supported
+// compression presets.
+const (
+	MinPreset = 0
+	MaxPreset = 9
+)
+
+// presetDictCapExps maps the preset levels to the exponents of the
+// dictionary capacities.
+var presetDictCapExps = [MaxPreset + 1]uint{
+	18, 20, 21, 22, 22, 23, 23, 24, 25, 26}
+
+// encoderParams contains the encoder parameters defined by a preset.
+type encoderParams struct {
+	dictCap int
+	matcher MatchAlgorithm
+	mode    Mode
+	niceLen int
+	depth   int
+}
+
+// presetParams computes the encoder parameters for a preset level. The
+// values follow the presets of the xz-utils. The extreme flag selects
+// the slower variant of the preset that searches more thoroughly.
+func presetParams(level int, extreme bool) (p encoderParams, err error) {
+	if !(MinPreset <= level && level <= MaxPreset) {
+		return p, errors.New("lzma: preset level out of range")
+	}
+	p.dictCap = 1 << presetDictCapExps[level]
+	switch {
+	case extreme:
+		p.matcher = BT4
+		p.mode = ModeNormal
+		if level == 3 || level == 5 {
+			p.niceLen = 192
+		} else {
+			p.niceLen = 273
+			p.depth = 512
+		}
+	case level <= 3:
+		p.matcher = HC4
+		if level == 0 {
+			p.matcher = HC3
+		}
+		p.mode = ModeFast
+		p.niceLen = 273
+		if level <= 1 {
+			p.niceLen = 128
+		}
+		p.depth = [4]int{4, 8, 24, 48}[level]
+	default:
+		p.matcher = BT4
+		p.mode = ModeNormal
+		switch level {
+		case 4:
+			p.niceLen = 16
+		case 5:
+			p.niceLen = 32
+		default:
+			p.niceLen = 64
+		}
+	}
+	return p, nil
+}
+
+// WriterConfigForPreset returns the writer configuration for the
+// given preset level between MinPreset and MaxPreset. The extreme flag
+// selects a slower variant of the preset that may compress better.
+// The presets are compatible with the presets of the xz-utils.
+func WriterConfigForPreset(level int, extreme bool) (c WriterConfig,
+	err error) {
+
+	p, err := presetParams(level, extreme)
+	if err != nil {
+		return c, err
+	}
+	c = WriterConfig{
+		Properties: &Properties{LC: 3, LP: 0, PB: 2},
+		DictCap:    p.dictCap,
+		Matcher:    p.matcher,
+		Mode:       p.mode,
+		NiceLen:    p.niceLen,
+		Depth:      p.depth,
+	}
+	return c, nil
+}
+
+// Writer2ConfigForPreset returns the LZMA2 writer configuration for
+// the given preset level between MinPreset and MaxPreset. The extreme
+// flag selects a slower variant of the preset that may compress better.
+// The presets are compatible with the presets of the xz-utils.
+func Writer2ConfigForPreset(level int, extreme bool) (c Writer2Config,
+	err error) {
+
+	p, err := presetParams(level, extreme)
+	if err != nil {
+		return c, err
+	}
+	c = Writer2Config{
+		Properties: &Properties{LC: 3, LP: 0, PB: 2},
+		DictCap:    p.dictCap,
+		Matcher:    p.matcher,
+		Mode:       p.mode,
+		NiceLen:    p.niceLen,
+		Depth:      p.depth,
+	}
+	return c, nil
+}
@@ -0,0 +1,75 @@
+// Copyright 2014-2017 Ulrich Kunitz. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package lzma
+
+import (
+	"bytes"
+	"io"
+	"io/ioutil"
+	"math/rand"
+	"testing"
+
+	"github.com/ulikunitz/xz/internal/randtxt"
+)
+
+func TestWriter2ConfigForPreset(t *testing.T) {
+	const txtlen = 30000
+	var buf bytes.Buffer
+	io.CopyN(&buf, randtxt.NewReader(rand.NewSource(41)), txtlen)
+	txt := buf.Bytes()
+	for level := MinPreset; level <= MaxPreset; level++ {
+		for _, extreme := range []bool{false, true} {
+			cfg, err := Writer2ConfigForPreset(level, extreme)
+			if err != nil {
+				t.Fatalf("preset %d extreme %t: error %s",
+					level, extreme, err)
+			}
+			if err = cfg.Verify(); err != nil {
+				t.Fatalf("preset %d extreme %t: Verify error %s",
+					level, extreme, err)
+			}
+			if extreme && cfg.Mode != ModeNormal {
+				t.Errorf("preset %d extreme: mode %s",
+					level, cfg.Mode)
+			}
+			if level > 3 {
+				continue
+			}
+			var cbuf bytes.Buffer
+			w, err := cfg.NewWriter2(&cbuf)
+			if err != nil {
+				t.Fatalf("NewWriter2 error %s", err)
+			}
+			if _, err = w.Write(txt); err != nil {
+				t.Fatalf("w.Write error %s", err)
+			}
+			if err = w.Close(); err != nil {
+				t.Fatalf("w.Close error %s", err)
+			}
+			r, err := Reader2Config{DictCap: cfg.DictCap}.NewReader2(
+				&cbuf)
+			if err != nil {
+				t.Fatalf("NewReader2 error %s", err)
+			}
+			out, err := ioutil.ReadAll(r)
+			if err != nil {
+				t.Fatalf("ReadAll error %s", err)
+			}
+			if !bytes.Equal(out, txt) {
+				t.Fatalf("preset %d extreme %t: data differs",
+					level, extreme)
+			}
+		}
+	}
+	cfg, _ := Writer2ConfigForPreset(6, false)
+	if cfg.DictCap != 8<<20 || cfg.Matcher != BT4 || cfg.NiceLen != 64 {
+		t.Errorf("preset 6: unexpected configuration %+v", cfg)
+	}
+	for _, level := range []int{MinPreset - 1, MaxPreset + 1} {
+		if _, err := WriterConfigForPreset(level, false); err == nil {
+			t.Errorf("preset %d accepted", level)
+		}
+	}
+}
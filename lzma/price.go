@@ -0,0 +1,131 @@
+// Copyright 2014-2017 Ulrich Kunitz. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package lzma
+
+/* Prices estimate the number of bits required to encode a bit with a
+ * given probability value. The price of a bit is stored in 1/16 bits.
+ * The computation of the price table follows the LZMA SDK.
+ */
+
+// Constants for the price computation.
+const (
+	// number of fractional bits of a price
+	priceShiftBits = 4
+	// number of low probability bits ignored by the price table
+	priceReduceBits = 4
+	// price of a bit encoded directly
+	directBitPrice = 1 << priceShiftBits
+	// infinitePrice is larger than any sum of prices computed by
+	// the encoder.
+	infinitePrice = 1 << 30
+)
+
+// probPrices stores the price for encoding a zero bit depending on the
+// probability value shifted right by priceReduceBits.
+var probPrices = initProbPrices()
+
+// initProbPrices computes the price table. It uses integer arithmetic
+// to compute the binary logarithm of the probability values.
+func initProbPrices() []uint32 {
+	const n = 1 << (probbits - priceReduceBits)
+	prices := make([]uint32, n)
+	for i := uint32(1<<priceReduceBits) / 2; i < 1<<probbits; i += 1 << priceReduceBits {
+		w := i
+		bitCount := uint32(0)
+		for j := 0; j < priceShiftBits; j++ {
+			w *= w
+			bitCount <<= 1
+			for w >= 1<<16 {
+				w >>= 1
+				bitCount++
+			}
+		}
+		prices[i>>priceReduceBits] =
+			(probbits << priceShiftBits) - 15 - bitCount
+	}
+	return prices
+}
+
+// price returns the price for encoding the least-significant bit of v.
+func (p prob) price(v uint32) uint32 {
+	return probPrices[(uint32(p)^(-(v&1)&(1<<probbits-1)))>>priceReduceBits]
+}
+
+// price returns the price for encoding the value v.
+func (tc *treeCodec) price(v uint32) (price uint32) {
+	m := uint32(1)
+	for i := int(tc.bits) - 1; i >= 0; i-- {
+		b := (v >> uint(i)) & 1
+		price += tc.probs[m].price(b)
+		m = (m << 1) | b
+	}
+	return price
+}
+
+// price returns the price for encoding the value v.
+func (tc *treeReverseCodec) price(v uint32) (price uint32) {
+	m := uint32(1)
+	for i := uint(0); i < uint(tc.bits); i++ {
+		b := (v >> i) & 1
+		price += tc.probs[m].price(b)
+		m = (m << 1) | b
+	}
+	return price
+}
+
+// price returns the price for encoding the length value l.
+func (lc *lengthCodec) price(l uint32, posState uint32) uint32 {
+	if l < 8 {
+		return lc.choice[0].price(0) + lc.low[posState].price(l)
+	}
+	p := lc.choice[0].price(1)
+	if l < 16 {
+		return p + lc.choice[1].price(0) + lc.mid[posState].price(l-8)
+	}
+	return p + lc.choice[1].price(1) + lc.high.price(l-16)
+}
+
+// price returns the price for encoding the literal s. The arguments
+// have the same meaning as for the Encode method.
+func (c *literalCodec) price(s byte, state uint32, match byte,
+	litState uint32) (price uint32) {
+
+	k := litState * 0x300
+	probs := c.probs[k : k+0x300]
+	symbol := uint32(1)
+	r := uint32(s)
+	if state >= 7 {
+		m := uint32(match)
+		for {
+			matchBit := (m >> 7) & 1
+			m <<= 1
+			bit := (r >> 7) & 1
+			r <<= 1
+			i := ((1 + matchBit) << 8) | symbol
+			price += probs[i].price(bit)
+			symbol = (symbol << 1) | bit
+			if matchBit != bit || symbol >= 0x100 {
+				break
+			}
+		}
+	}
+	for symbol < 0x100 {
+		bit := (r >> 7) & 1
+		r <<= 1
+		price += probs[symbol].price(bit)
+		symbol = (symbol << 1) | bit
+	}
+	return price
+}
+
+// posSlot computes the position slot for the distance value dist as
+// the Encode method of the distance codec does.
+func posSlot(dist uint32) uint32 {
+	if dist < startPosModel {
+		return dist
+	}
+	bits := uint32(30 - nlz32(dist))
+	return startPosModel - 2 + (bits << 1) + (dist>>bits)&1
+}
@@ -0,0 +1,36 @@
+// Copyright 2014-2017 Ulrich Kunitz. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package lzma
+
+import "testing"
+
+func TestProbPrices(t *testing.T) {
+	if p := probInit.price(0); p != directBitPrice {
+		t.Fatalf("probInit.price(0) = %d; want %d", p, directBitPrice)
+	}
+	// The price table rounds the probability value.
+	if p := probInit.price(1); p-directBitPrice > 1 {
+		t.Fatalf("probInit.price(1) = %d; want %d or %d", p,
+			directBitPrice, directBitPrice+1)
+	}
+	for i := 1; i < len(probPrices); i++ {
+		if probPrices[i] > probPrices[i-1] {
+			t.Fatalf("probPrices[%d] = %d larger than "+
+				"probPrices[%d] = %d", i, probPrices[i],
+				i-1, probPrices[i-1])
+		}
+	}
+}
+
+func TestTreeCodecPrice(t *testing.T) {
+	tc := makeTreeCodec(6)
+	for v := uint32(0); v < 64; v++ {
+		p := tc.price(v)
+		if !(6*directBitPrice <= p && p <= 6*(directBitPrice+1)) {
+			t.Fatalf("tc.price(%d) = %d; want about %d", v, p,
+				6*directBitPrice)
+		}
+	}
+}
@@ -188,6 +188,24 @@ func (d *rangeDecoder) possiblyAtEnd() bool {
 	return d.code == 0
 }
 
+// atEOF checks whether the decoder may be at the end of the stream and
+// the byte reader has no more data. The byte reader must support
+// io.ByteScanner to allow the check.
+func (d *rangeDecoder) atEOF() bool {
+	if !d.possiblyAtEnd() {
+		return false
+	}
+	s, ok := d.br.(io.ByteScanner)
+	if !ok {
+		return false
+	}
+	if _, err := s.ReadByte(); err != nil {
+		return err == io.EOF
+	}
+	s.UnreadByte()
+	return false
+}
+
 // DirectDecodeBit decodes a bit with probability 1/2. The return value b will
 // contain the bit at the least-significant position. All other bits will be
 // zero.
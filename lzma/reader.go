@@ -11,6 +11,7 @@
 package lzma
 
 import (
+	"bufio"
 	"errors"
 	"io"
 )
@@ -19,12 +20,63 @@ import (
 // format.
 type ReaderConfig struct {
 	DictCap int
+	// MemLimit limits the memory in bytes used by the decoder. The
+	// memory usage is estimated from the dictionary capacity in the
+	// header before any memory is allocated. If the limit is
+	// exceeded a *MemLimitError is returned. The value zero means no
+	// limit.
+	MemLimit int64
+	// MaxOutput limits the number of uncompressed bytes. If the
+	// stream contains more data, ErrOutputLimit is returned. The
+	// value zero means no limit.
+	MaxOutput int64
+	// MaxRatio limits the ratio of uncompressed to compressed bytes
+	// consumed. The ratio is checked only after MinRatioCheckSize
+	// bytes have been decompressed. If the ratio is exceeded,
+	// ErrRatioLimit is returned. The value zero means no limit.
+	MaxRatio int
+	// Dict provides the preset dictionary used by the writer.
+	Dict []byte
+	// EOSOptional allows a raw stream of unknown size to end without
+	// an end-of-stream marker at the end of the input. The end is
+	// accepted if the range decoder may have finished. The reader
+	// buffers the input unless it supports io.ByteScanner.
+	EOSOptional bool
+}
+
+// MinRatioCheckSize defines the number of uncompressed bytes after which
+// the readers start to check the compression ratio against MaxRatio.
+const MinRatioCheckSize = 1 << 20
+
+// Errors returned if the output limits of the reader are exceeded.
+var (
+	ErrOutputLimit = errors.New("lzma: output limit exceeded")
+	ErrRatioLimit  = errors.New("lzma: compression ratio limit exceeded")
+)
+
+// checkOutputLimits checks n uncompressed bytes against the output
+// limits given the compressed bytes consumed.
+func (c *ReaderConfig) checkOutputLimits(n, compressed int64) error {
+	if c.MaxOutput > 0 && n > c.MaxOutput {
+		return ErrOutputLimit
+	}
+	// n > MaxRatio*compressed without the risk of an overflow
+	if c.MaxRatio > 0 && n >= MinRatioCheckSize &&
+		(n-1)/int64(c.MaxRatio) >= compressed {
+		return ErrRatioLimit
+	}
+	return nil
 }
 
 // fill converts the zero values of the configuration to the default values.
 func (c *ReaderConfig) fill() {
 	if c.DictCap == 0 {
-		c.DictCap = 8 * 1024 * 1024
+		if c.MemLimit > 0 {
+			// use only the dictionary capacity of the header
+			c.DictCap = MinDictCap
+		} else {
+			c.DictCap = 8 * 1024 * 1024
+		}
 	}
 }
 
@@ -35,6 +87,15 @@ func (c *ReaderConfig) Verify() error {
 	if !(MinDictCap <= c.DictCap && int64(c.DictCap) <= MaxDictCap) {
 		return errors.New("lzma: dictionary capacity is out of range")
 	}
+	if c.MemLimit < 0 {
+		return errors.New("lzma: memory limit is negative")
+	}
+	if c.MaxOutput < 0 {
+		return errors.New("lzma: output limit is negative")
+	}
+	if c.MaxRatio < 0 {
+		return errors.New("lzma: ratio limit is negative")
+	}
 	return nil
 }
 
@@ -43,6 +104,12 @@ type Reader struct {
 	lzma io.Reader
 	h    header
 	d    *decoder
+
+	// fields used for the output limits
+	config ReaderConfig
+	cr     *countingByteReader
+	n      int64
+	err    error
 }
 
 // NewReader creates a new reader for an LZMA stream using the classic
@@ -76,19 +143,75 @@ func (c ReaderConfig) NewReader(lzma io.Reader) (r *Reader, err error) {
 	if c.DictCap > dictCap {
 		dictCap = c.DictCap
 	}
+	// the header has been consumed already
+	if err = r.init(c, dictCap, HeaderLen); err != nil {
+		return nil, err
+	}
+	return r, nil
+}
 
-	state := newState(r.h.properties)
-	dict, err := newDecoderDict(dictCap)
-	if err != nil {
+// NewRawReader creates a reader for a raw LZMA stream that has no
+// header. The properties and the size of the uncompressed data must be
+// provided by the caller. A negative size requires the stream to be
+// terminated by an end-of-stream marker. The dictionary capacity is
+// given by the configuration. The reader doesn't read beyond the end
+// of the LZMA stream, so containers can read their trailers from the
+// same reader.
+func (c ReaderConfig) NewRawReader(lzma io.Reader, p Properties,
+	size int64) (r *Reader, err error) {
+
+	if err = c.Verify(); err != nil {
 		return nil, err
 	}
-	r.d, err = newDecoder(ByteReader(lzma), state, dict, r.h.size)
-	if err != nil {
+	if err = p.verify(); err != nil {
+		return nil, err
+	}
+	if size < 0 {
+		size = -1
+	}
+	r = &Reader{
+		lzma: lzma,
+		h:    header{properties: p, dictCap: c.DictCap, size: size},
+	}
+	if err = r.init(c, c.DictCap, 0); err != nil {
 		return nil, err
 	}
 	return r, nil
 }
 
+// init creates the decoder for the reader. The argument consumed gives
+// the number of bytes read from the stream already.
+func (r *Reader) init(c ReaderConfig, dictCap int, consumed int64) error {
+	err := checkMemLimit(c.MemLimit, dictCap,
+		r.h.properties.LC+r.h.properties.LP)
+	if err != nil {
+		return err
+	}
+
+	state := newState(r.h.properties)
+	dict, err := newDecoderDict(dictCap)
+	if err != nil {
+		return err
+	}
+	dict.preset(c.Dict)
+	br := ByteReader(r.lzma)
+	if c.EOSOptional {
+		if _, ok := br.(io.ByteScanner); !ok {
+			br = bufio.NewReader(r.lzma)
+		}
+	}
+	if c.MaxOutput > 0 || c.MaxRatio > 0 {
+		r.config = c
+		r.cr = &countingByteReader{br: br, n: consumed}
+		br = r.cr
+	}
+	if r.d, err = newDecoder(br, state, dict, r.h.size); err != nil {
+		return err
+	}
+	r.d.eosOptional = c.EOSOptional
+	return nil
+}
+
 // EOSMarker indicates that an EOS marker has been encountered.
 func (r *Reader) EOSMarker() bool {
 	return r.d.eosMarker
@@ -96,5 +219,25 @@ func (r *Reader) EOSMarker() bool {
 
 // Read returns uncompressed data.
 func (r *Reader) Read(p []byte) (n int, err error) {
-	return r.d.Read(p)
+	if r.cr == nil {
+		return r.d.Read(p)
+	}
+	if r.err != nil {
+		return 0, r.err
+	}
+	if m := r.config.MaxOutput; m > 0 && int64(len(p)) > m-r.n {
+		// one additional byte detects the excess
+		p = p[:m-r.n+1]
+	}
+	n, err = r.d.Read(p)
+	r.n += int64(n)
+	if lerr := r.config.checkOutputLimits(r.n, r.cr.n); lerr != nil {
+		if m := r.config.MaxOutput; m > 0 && r.n > m {
+			n -= int(r.n - m)
+			r.n = m
+		}
+		r.err = lerr
+		return n, lerr
+	}
+	return n, err
 }
@@ -15,6 +15,13 @@ import (
 // format.
 type Reader2Config struct {
 	DictCap int
+	// MemLimit limits the memory in bytes used by the decoder. LZMA2
+	// streams don't store the dictionary capacity, so the estimate
+	// is based on DictCap. If the limit is exceeded a *MemLimitError
+	// is returned. The value zero means no limit.
+	MemLimit int64
+	// Dict provides the preset dictionary used by the writer.
+	Dict []byte
 }
 
 // fill converts the zero values of the configuration to the default values.
@@ -31,13 +38,16 @@ func (c *Reader2Config) Verify() error {
 	if !(MinDictCap <= c.DictCap && int64(c.DictCap) <= MaxDictCap) {
 		return errors.New("lzma: dictionary capacity is out of range")
 	}
+	if c.MemLimit < 0 {
+		return errors.New("lzma: memory limit is negative")
+	}
 	return nil
 }
 
-// Reader2 supports the reading of LZMA2 chunk sequences. Note that the
-// first chunk should have a dictionary reset and the first compressed
-// chunk a properties reset. The chunk sequence may not be terminated by
-// an end-of-stream chunk.
+// Reader2 supports the reading of LZMA2 chunk sequences. The first
+// chunk should have a dictionary reset unless a preset dictionary is
+// used. The first compressed chunk should have a properties reset. The
+// chunk sequence may not be terminated by an end-of-stream chunk.
 type Reader2 struct {
 	r   io.Reader
 	err error
@@ -49,6 +59,9 @@ type Reader2 struct {
 
 	cstate chunkState
 	ctype  chunkType
+
+	// preset dictionary
+	presetDict []byte
 }
 
 // NewReader2 creates a reader for an LZMA2 chunk sequence.
@@ -61,15 +74,36 @@ func (c Reader2Config) NewReader2(lzma2 io.Reader) (r *Reader2, err error) {
 	if err = c.Verify(); err != nil {
 		return nil, err
 	}
-	r = &Reader2{r: lzma2, cstate: start}
+	// LZMA2 requires lc+lp <= 4.
+	if err = checkMemLimit(c.MemLimit, c.DictCap, 4); err != nil {
+		return nil, err
+	}
+	r = &Reader2{presetDict: c.Dict}
 	r.dict, err = newDecoderDict(c.DictCap)
 	if err != nil {
 		return nil, err
 	}
-	if err = r.startChunk(); err != nil {
+	r.Reset(lzma2)
+	return r, nil
+}
+
+// Reset puts the reader into its initial state for reading the LZMA2
+// chunk sequence from lzma2. The dictionary is reused. Errors of the
+// first chunk header are reported by the following Read call.
+func (r *Reader2) Reset(lzma2 io.Reader) {
+	r.r = lzma2
+	r.err = nil
+	r.cstate = start
+	r.dict.buf.Reset()
+	r.dict.Reset()
+	if len(r.presetDict) > 0 {
+		// The first chunk doesn't need to reset the dictionary.
+		r.cstate = 'R'
+		r.dict.preset(r.presetDict)
+	}
+	if err := r.startChunk(); err != nil {
 		r.err = err
 	}
-	return r, nil
 }
 
 // uncompressed tests whether the chunk type specifies an uncompressed
@@ -132,7 +166,10 @@ func (r *Reader2) startChunk() error {
 	return nil
 }
 
-// Read reads data from the LZMA2 chunk sequence.
+// Read reads data from the LZMA2 chunk sequence. If data has been read,
+// Read returns at the end of a chunk instead of waiting for the header
+// of the next chunk. So data flushed by the writer can be read from a
+// stream.
 func (r *Reader2) Read(p []byte) (n int, err error) {
 	if r.err != nil {
 		return 0, r.err
@@ -143,6 +180,11 @@ func (r *Reader2) Read(p []byte) (n int, err error) {
 		n += k
 		if err != nil {
 			if err == io.EOF {
+				if n > 0 {
+					// The chunk reader will report
+					// io.EOF again.
+					return n, nil
+				}
 				err = r.startChunk()
 				if err == nil {
 					continue
@@ -310,3 +310,77 @@ func TestReaderErrAgain(t *testing.T) {
 		}
 	}
 }
+
+func TestReaderMemLimit(t *testing.T) {
+	var buf bytes.Buffer
+	w, err := WriterConfig{DictCap: 1 << 20}.NewWriter(&buf)
+	if err != nil {
+		t.Fatalf("NewWriter error %s", err)
+	}
+	if _, err = io.WriteString(w, "The quick brown fox."); err != nil {
+		t.Fatalf("WriteString error %s", err)
+	}
+	if err = w.Close(); err != nil {
+		t.Fatalf("w.Close error %s", err)
+	}
+	data := buf.Bytes()
+
+	_, err = ReaderConfig{MemLimit: 1 << 19}.NewReader(
+		bytes.NewReader(data))
+	e, ok := err.(*MemLimitError)
+	if !ok {
+		t.Fatalf("NewReader returned error %v; want *MemLimitError",
+			err)
+	}
+	if e.Limit != 1<<19 || e.Required <= 1<<20 {
+		t.Fatalf("got limit %d, required %d", e.Limit, e.Required)
+	}
+	r, err := ReaderConfig{MemLimit: 1 << 21}.NewReader(
+		bytes.NewReader(data))
+	if err != nil {
+		t.Fatalf("NewReader error %s", err)
+	}
+	if _, err = ioutil.ReadAll(r); err != nil {
+		t.Fatalf("ReadAll error %s", err)
+	}
+
+	_, err = Reader2Config{DictCap: 1 << 20, MemLimit: 1 << 20}.NewReader2(
+		bytes.NewReader(nil))
+	if _, ok = err.(*MemLimitError); !ok {
+		t.Fatalf("NewReader2 returned error %v; want *MemLimitError",
+			err)
+	}
+}
+
+func TestReaderOutputLimits(t *testing.T) {
+	const size = 1 << 21
+	var buf bytes.Buffer
+	w, err := NewWriter(&buf)
+	if err != nil {
+		t.Fatalf("NewWriter error %s", err)
+	}
+	if _, err = w.Write(make([]byte, size)); err != nil {
+		t.Fatalf("w.Write error %s", err)
+	}
+	if err = w.Close(); err != nil {
+		t.Fatalf("w.Close error %s", err)
+	}
+	data := buf.Bytes()
+
+	r, err := ReaderConfig{MaxOutput: 100}.NewReader(bytes.NewReader(data))
+	if err != nil {
+		t.Fatalf("NewReader error %s", err)
+	}
+	out, err := ioutil.ReadAll(r)
+	if err != ErrOutputLimit || len(out) != 100 {
+		t.Fatalf("got %d bytes and error %v; want 100 bytes and %v",
+			len(out), err, ErrOutputLimit)
+	}
+	r, err = ReaderConfig{MaxRatio: 10}.NewReader(bytes.NewReader(data))
+	if err != nil {
+		t.Fatalf("NewReader error %s", err)
+	}
+	if _, err = ioutil.ReadAll(r); err != ErrRatioLimit {
+		t.Fatalf("got error %v; want %v", err, ErrRatioLimit)
+	}
+}
@@ -17,6 +17,10 @@ const (
 	MaxDictCap = 1<<32 - 1
 )
 
+// MaxDepth is the largest depth supported by the match algorithms. The
+// matchers allocate buffers proportional to the depth.
+const MaxDepth = 1 << 16
+
 // WriterConfig defines the configuration parameter for a writer.
 type WriterConfig struct {
 	// Properties for the encoding. If the it is nil the value
@@ -30,6 +34,21 @@ type WriterConfig struct {
 	BufSize int
 	// Match algorithm
 	Matcher MatchAlgorithm
+	// Mode selects the parsing of the data. The zero value ModeFast
+	// chooses the operations greedily; ModeNormal computes optimal
+	// sequences of operations using price tables.
+	Mode Mode
+	// NiceLen gives the length of a match that is accepted without
+	// searching for longer matches. The value zero selects 64.
+	NiceLen int
+	// Depth limits the number of positions checked by the match
+	// algorithm. The value zero selects the default of the
+	// algorithm; the maximum is MaxDepth.
+	Depth int
+	// Dict provides a preset dictionary. The reader requires the
+	// same preset dictionary. Only the last DictCap bytes of Dict
+	// are used.
+	Dict []byte
 	// SizeInHeader indicates that the header will contain an
 	// explicit size.
 	SizeInHeader bool
@@ -53,6 +72,9 @@ func (c *WriterConfig) fill() {
 	if c.BufSize == 0 {
 		c.BufSize = 4096
 	}
+	if c.NiceLen == 0 {
+		c.NiceLen = defaultNiceLen
+	}
 	if c.Size > 0 {
 		c.SizeInHeader = true
 	}
@@ -91,6 +113,15 @@ func (c *WriterConfig) Verify() error {
 	if err = c.Matcher.verify(); err != nil {
 		return err
 	}
+	if err = c.Mode.verify(); err != nil {
+		return err
+	}
+	if !(minMatchLen <= c.NiceLen && c.NiceLen <= maxMatchLen) {
+		return errors.New("lzma: nice length out of range")
+	}
+	if !(0 <= c.Depth && c.Depth <= MaxDepth) {
+		return errors.New("lzma: depth out of range")
+	}
 
 	return nil
 }
@@ -119,6 +150,25 @@ type Writer struct {
 // NewWriter creates a new LZMA writer for the classic format. The
 // method will write the header to the underlying stream.
 func (c WriterConfig) NewWriter(lzma io.Writer) (w *Writer, err error) {
+	if w, err = c.newWriter(lzma); err != nil {
+		return nil, err
+	}
+	if err = w.writeHeader(); err != nil {
+		return nil, err
+	}
+	return w, nil
+}
+
+// NewRawWriter creates a writer for a raw LZMA stream without header.
+// The reader of the stream must know the properties, the dictionary
+// capacity and, if no end-of-stream marker is written, the size of the
+// uncompressed data.
+func (c WriterConfig) NewRawWriter(lzma io.Writer) (w *Writer, err error) {
+	return c.newWriter(lzma)
+}
+
+// newWriter creates the writer without writing the header.
+func (c WriterConfig) newWriter(lzma io.Writer) (w *Writer, err error) {
 	if err = c.Verify(); err != nil {
 		return nil, err
 	}
@@ -131,7 +181,7 @@ func (c WriterConfig) NewWriter(lzma io.Writer) (w *Writer, err error) {
 		w.bw = w.buf
 	}
 	state := newState(w.h.properties)
-	m, err := c.Matcher.new(w.h.dictCap)
+	m, err := c.Matcher.new(w.h.dictCap, c.NiceLen, c.Depth)
 	if err != nil {
 		return nil, err
 	}
@@ -139,17 +189,14 @@ func (c WriterConfig) NewWriter(lzma io.Writer) (w *Writer, err error) {
 	if err != nil {
 		return nil, err
 	}
-	var flags encoderFlags
+	dict.preset(c.Dict)
+	flags := c.Mode.flags()
 	if c.EOSMarker {
-		flags = eosMarker
+		flags |= eosMarker
 	}
 	if w.e, err = newEncoder(w.bw, state, dict, flags); err != nil {
 		return nil, err
 	}
-
-	if err = w.writeHeader(); err != nil {
-		return nil, err
-	}
 	return w, nil
 }
 
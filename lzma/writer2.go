@@ -23,6 +23,21 @@ type Writer2Config struct {
 	BufSize int
 	// Match algorithm
 	Matcher MatchAlgorithm
+	// Mode selects the parsing of the data. The zero value ModeFast
+	// chooses the operations greedily; ModeNormal computes optimal
+	// sequences of operations using price tables.
+	Mode Mode
+	// NiceLen gives the length of a match that is accepted without
+	// searching for longer matches. The value zero selects 64.
+	NiceLen int
+	// Depth limits the number of positions checked by the match
+	// algorithm. The value zero selects the default of the
+	// algorithm; the maximum is MaxDepth.
+	Depth int
+	// Dict provides a preset dictionary. The reader requires the
+	// same preset dictionary. Only the last DictCap bytes of Dict
+	// are used.
+	Dict []byte
 }
 
 // fill replaces zero values with default values.
@@ -36,6 +51,9 @@ func (c *Writer2Config) fill() {
 	if c.BufSize == 0 {
 		c.BufSize = 4096
 	}
+	if c.NiceLen == 0 {
+		c.NiceLen = defaultNiceLen
+	}
 }
 
 // Verify checks the Writer2Config for correctness. Zero values will be
@@ -64,6 +82,15 @@ func (c *Writer2Config) Verify() error {
 	if err = c.Matcher.verify(); err != nil {
 		return err
 	}
+	if err = c.Mode.verify(); err != nil {
+		return err
+	}
+	if !(minMatchLen <= c.NiceLen && c.NiceLen <= maxMatchLen) {
+		return errors.New("lzma: nice length out of range")
+	}
+	if !(0 <= c.Depth && c.Depth <= MaxDepth) {
+		return errors.New("lzma: depth out of range")
+	}
 	return nil
 }
 
@@ -87,6 +114,9 @@ type Writer2 struct {
 
 	buf bytes.Buffer
 	lbw LimitedByteWriter
+
+	// preset dictionary
+	dict []byte
 }
 
 // NewWriter2 creates an LZMA2 chunk sequence writer with the default
@@ -101,14 +131,14 @@ func (c Writer2Config) NewWriter2(lzma2 io.Writer) (w *Writer2, err error) {
 		return nil, err
 	}
 	w = &Writer2{
-		w:      lzma2,
-		start:  newState(*c.Properties),
-		cstate: start,
-		ctype:  start.defaultChunkType(),
+		w:     lzma2,
+		start: newState(*c.Properties),
+		dict:  c.Dict,
 	}
+	w.resetChunkState()
 	w.buf.Grow(maxCompressed)
 	w.lbw = LimitedByteWriter{BW: &w.buf, N: maxCompressed}
-	m, err := c.Matcher.new(c.DictCap)
+	m, err := c.Matcher.new(c.DictCap, c.NiceLen, c.Depth)
 	if err != nil {
 		return nil, err
 	}
@@ -116,13 +146,44 @@ func (c Writer2Config) NewWriter2(lzma2 io.Writer) (w *Writer2, err error) {
 	if err != nil {
 		return nil, err
 	}
-	w.encoder, err = newEncoder(&w.lbw, cloneState(w.start), d, 0)
+	d.preset(c.Dict)
+	w.encoder, err = newEncoder(&w.lbw, cloneState(w.start), d,
+		c.Mode.flags())
 	if err != nil {
 		return nil, err
 	}
 	return w, nil
 }
 
+// resetChunkState sets the chunk state for the first chunk.
+func (w *Writer2) resetChunkState() {
+	w.cstate = start
+	if len(w.dict) > 0 {
+		// The first chunk must not reset the dictionary.
+		w.cstate = 'R'
+	}
+	w.ctype = w.cstate.defaultChunkType()
+}
+
+// Reset discards the buffered data and puts the writer into its initial
+// state. The following data will be written to lzma2. The dictionary
+// and the matcher arrays are reused, so Reset avoids the allocations of
+// a new writer.
+func (w *Writer2) Reset(lzma2 io.Writer) error {
+	w.w = lzma2
+	w.buf.Reset()
+	w.lbw.N = maxCompressed
+	w.resetChunkState()
+	d := w.encoder.dict
+	d.Reset()
+	d.preset(w.dict)
+	if err := w.encoder.Reset(&w.lbw); err != nil {
+		return err
+	}
+	w.start.deepcopy(w.encoder.state)
+	return nil
+}
+
 // written returns the number of bytes written to the current chunk
 func (w *Writer2) written() int {
 	if w.encoder == nil {
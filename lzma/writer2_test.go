@@ -7,6 +7,7 @@ package lzma
 import (
 	"bytes"
 	"io"
+	"io/ioutil"
 	"math/rand"
 	"strings"
 	"testing"
@@ -107,3 +108,260 @@ func TestCycle2(t *testing.T) {
 		t.Fatal("decompressed data differs from original")
 	}
 }
+
+func TestWriter2Mode(t *testing.T) {
+	const txtlen = 100000
+	var buf bytes.Buffer
+	io.CopyN(&buf, randtxt.NewReader(rand.NewSource(42)), txtlen)
+	txt := buf.Bytes()
+	for _, ma := range []MatchAlgorithm{HashTable4, BinaryTree, HC3, HC4,
+		BT2, BT3, BT4} {
+		var sizes [2]int
+		for i, mode := range []Mode{ModeFast, ModeNormal} {
+			var cbuf bytes.Buffer
+			cfg := Writer2Config{DictCap: 1 << 16, Matcher: ma,
+				Mode: mode}
+			w, err := cfg.NewWriter2(&cbuf)
+			if err != nil {
+				t.Fatalf("NewWriter2 error %s", err)
+			}
+			if _, err = w.Write(txt); err != nil {
+				t.Fatalf("w.Write error %s", err)
+			}
+			if err = w.Close(); err != nil {
+				t.Fatalf("w.Close error %s", err)
+			}
+			sizes[i] = cbuf.Len()
+			r, err := Reader2Config{DictCap: 1 << 16}.NewReader2(&cbuf)
+			if err != nil {
+				t.Fatalf("NewReader2 error %s", err)
+			}
+			out, err := ioutil.ReadAll(r)
+			if err != nil {
+				t.Fatalf("%s %s: ReadAll error %s", ma, mode, err)
+			}
+			if !bytes.Equal(out, txt) {
+				t.Fatalf("%s %s: decompressed data differs",
+					ma, mode)
+			}
+		}
+		t.Logf("%s: fast %d normal %d", ma, sizes[0], sizes[1])
+		if sizes[1] >= sizes[0] {
+			t.Errorf("%s: mode normal compressed to %d bytes;"+
+				" want less than %d for mode fast",
+				ma, sizes[1], sizes[0])
+		}
+	}
+}
+
+func TestWriter2Params(t *testing.T) {
+	const txtlen = 50000
+	var buf bytes.Buffer
+	io.CopyN(&buf, randtxt.NewReader(rand.NewSource(43)), txtlen)
+	txt := buf.Bytes()
+	tests := []Writer2Config{
+		{Matcher: HashTable4, NiceLen: 8, Depth: 2},
+		{Matcher: BinaryTree, NiceLen: 273, Depth: 100},
+		{Matcher: HC4, Mode: ModeNormal, NiceLen: 4, Depth: 1},
+		{Matcher: BT2, Mode: ModeNormal, NiceLen: 2},
+		{Matcher: BT4, Mode: ModeNormal, NiceLen: 273, Depth: 1000},
+	}
+	for _, cfg := range tests {
+		cfg.DictCap = 1 << 16
+		var cbuf bytes.Buffer
+		w, err := cfg.NewWriter2(&cbuf)
+		if err != nil {
+			t.Fatalf("%+v: NewWriter2 error %s", cfg, err)
+		}
+		if _, err = w.Write(txt); err != nil {
+			t.Fatalf("w.Write error %s", err)
+		}
+		if err = w.Close(); err != nil {
+			t.Fatalf("w.Close error %s", err)
+		}
+		r, err := Reader2Config{DictCap: 1 << 16}.NewReader2(&cbuf)
+		if err != nil {
+			t.Fatalf("NewReader2 error %s", err)
+		}
+		out, err := ioutil.ReadAll(r)
+		if err != nil {
+			t.Fatalf("%+v: ReadAll error %s", cfg, err)
+		}
+		if !bytes.Equal(out, txt) {
+			t.Fatalf("%+v: decompressed data differs", cfg)
+		}
+	}
+
+	cfg := Writer2Config{Matcher: BT4, NiceLen: 3}
+	if _, err := cfg.NewWriter2(&buf); err == nil {
+		t.Fatal("NewWriter2 accepted nice length 3 for BT4")
+	}
+	for _, depth := range []int{-1, MaxDepth + 1, 1 << 62} {
+		cfg := Writer2Config{Depth: depth}
+		if err := cfg.Verify(); err == nil {
+			t.Errorf("Verify accepted depth %d", depth)
+		}
+	}
+}
+
+func TestWriter2Dict(t *testing.T) {
+	var buf bytes.Buffer
+	io.CopyN(&buf, randtxt.NewReader(rand.NewSource(17)), 10000)
+	dict := append([]byte(nil), buf.Bytes()...)
+	msg := append([]byte(nil), dict[6000:7000]...)
+	msg = append(msg, dict[3000:4000]...)
+
+	compress := func(cfg Writer2Config) []byte {
+		var cbuf bytes.Buffer
+		w, err := cfg.NewWriter2(&cbuf)
+		if err != nil {
+			t.Fatalf("NewWriter2 error %s", err)
+		}
+		if _, err = w.Write(msg); err != nil {
+			t.Fatalf("w.Write error %s", err)
+		}
+		if err = w.Close(); err != nil {
+			t.Fatalf("w.Close error %s", err)
+		}
+		return cbuf.Bytes()
+	}
+	plain := compress(Writer2Config{DictCap: 1 << 16})
+	for _, m := range []MatchAlgorithm{HashTable4, BinaryTree, HC4, BT4} {
+		for _, mode := range []Mode{ModeFast, ModeNormal} {
+			// The dictionary capacity is smaller than the
+			// preset dictionary.
+			cfg := Writer2Config{DictCap: 1 << 13, Matcher: m,
+				Mode: mode, Dict: dict}
+			data := compress(cfg)
+			if len(data) >= len(plain)/4 {
+				t.Errorf("%s %s: compressed size %d; plain %d",
+					m, mode, len(data), len(plain))
+			}
+			r, err := Reader2Config{DictCap: 1 << 16,
+				Dict: dict}.NewReader2(bytes.NewReader(data))
+			if err != nil {
+				t.Fatalf("NewReader2 error %s", err)
+			}
+			out, err := ioutil.ReadAll(r)
+			if err != nil {
+				t.Fatalf("%s %s: ReadAll error %s", m, mode, err)
+			}
+			if !bytes.Equal(out, msg) {
+				t.Fatalf("%s %s: decompressed data differs",
+					m, mode)
+			}
+		}
+	}
+}
+
+func TestWriter2Reset(t *testing.T) {
+	var buf bytes.Buffer
+	io.CopyN(&buf, randtxt.NewReader(rand.NewSource(23)), 40000)
+	txt := buf.Bytes()
+	compress := func(w *Writer2, out io.Writer, p []byte) {
+		if err := w.Reset(out); err != nil {
+			t.Fatalf("w.Reset error %s", err)
+		}
+		if _, err := w.Write(p); err != nil {
+			t.Fatalf("w.Write error %s", err)
+		}
+		if err := w.Close(); err != nil {
+			t.Fatalf("w.Close error %s", err)
+		}
+	}
+	for _, m := range []MatchAlgorithm{HashTable4, BinaryTree, HC4, BT4} {
+		for _, mode := range []Mode{ModeFast, ModeNormal} {
+			cfg := Writer2Config{DictCap: 1 << 14, Matcher: m,
+				Mode: mode, Dict: txt[:5000]}
+			var want bytes.Buffer
+			w, err := cfg.NewWriter2(&want)
+			if err != nil {
+				t.Fatalf("NewWriter2 error %s", err)
+			}
+			compress(w, &want, txt[10000:])
+
+			// use the writer for a stream not closed
+			if err = w.Reset(ioutil.Discard); err != nil {
+				t.Fatalf("w.Reset error %s", err)
+			}
+			if _, err = w.Write(txt[:20000]); err != nil {
+				t.Fatalf("w.Write error %s", err)
+			}
+			var got bytes.Buffer
+			compress(w, &got, txt[10000:])
+			if !bytes.Equal(got.Bytes(), want.Bytes()) {
+				t.Fatalf("%s %s: output after Reset differs",
+					m, mode)
+			}
+
+			r, err := Reader2Config{Dict: txt[:5000]}.NewReader2(
+				&want)
+			if err != nil {
+				t.Fatalf("NewReader2 error %s", err)
+			}
+			for i := 0; i < 2; i++ {
+				if i > 0 {
+					r.Reset(&got)
+				}
+				out, err := ioutil.ReadAll(r)
+				if err != nil {
+					t.Fatalf("ReadAll error %s", err)
+				}
+				if !bytes.Equal(out, txt[10000:]) {
+					t.Fatalf("%s %s: decompressed data differs",
+						m, mode)
+				}
+			}
+		}
+	}
+}
+
+func TestWriter2Flush(t *testing.T) {
+	// Data from a small alphabet produces long matches in the binary
+	// trees.
+	rnd := rand.New(rand.NewSource(5))
+	txt := make([]byte, 70000)
+	for i := range txt {
+		txt[i] = "ab"[rnd.Intn(2)]
+	}
+	for _, ma := range []MatchAlgorithm{HashTable4, BinaryTree, HC3, HC4,
+		BT2, BT3, BT4} {
+		for _, mode := range []Mode{ModeFast, ModeNormal} {
+			var buf bytes.Buffer
+			cfg := Writer2Config{DictCap: 1 << 16, Matcher: ma,
+				Mode: mode}
+			w, err := cfg.NewWriter2(&buf)
+			if err != nil {
+				t.Fatalf("NewWriter2 error %s", err)
+			}
+			for p := txt; len(p) > 0; {
+				n := 1 + rnd.Intn(3000)
+				if n > len(p) {
+					n = len(p)
+				}
+				if _, err = w.Write(p[:n]); err != nil {
+					t.Fatalf("w.Write error %s", err)
+				}
+				if err = w.Flush(); err != nil {
+					t.Fatalf("w.Flush error %s", err)
+				}
+				p = p[n:]
+			}
+			if err = w.Close(); err != nil {
+				t.Fatalf("w.Close error %s", err)
+			}
+			r, err := Reader2Config{DictCap: 1 << 16}.NewReader2(&buf)
+			if err != nil {
+				t.Fatalf("NewReader2 error %s", err)
+			}
+			out, err := ioutil.ReadAll(r)
+			if err != nil {
+				t.Fatalf("%s %s: ReadAll error %s", ma, mode, err)
+			}
+			if !bytes.Equal(out, txt) {
+				t.Fatalf("%s %s: decompressed data differs",
+					ma, mode)
+			}
+		}
+	}
+}
@@ -247,3 +247,121 @@ func BenchmarkWriter(b *testing.B) {
 		}
 	}
 }
+
+func TestWriterDict(t *testing.T) {
+	dict := []byte("The quick brown fox jumps over the lazy dog.\n")
+	const text = "The lazy dog jumps over the quick brown fox.\n"
+	var buf bytes.Buffer
+	w, err := WriterConfig{Dict: dict}.NewWriter(&buf)
+	if err != nil {
+		t.Fatalf("NewWriter error %s", err)
+	}
+	if _, err = io.WriteString(w, text); err != nil {
+		t.Fatalf("WriteString error %s", err)
+	}
+	if err = w.Close(); err != nil {
+		t.Fatalf("w.Close error %s", err)
+	}
+	r, err := ReaderConfig{Dict: dict}.NewReader(&buf)
+	if err != nil {
+		t.Fatalf("NewReader error %s", err)
+	}
+	out, err := ioutil.ReadAll(r)
+	if err != nil {
+		t.Fatalf("ReadAll error %s", err)
+	}
+	if string(out) != text {
+		t.Fatalf("got %q; want %q", out, text)
+	}
+}
+
+func TestRawWriter(t *testing.T) {
+	const text = "The quick brown fox jumps over the lazy dog. " +
+		"The quick brown fox jumps over the lazy dog."
+	props := Properties{LC: 0, LP: 2, PB: 1}
+	for _, size := range []int64{-1, int64(len(text))} {
+		var buf bytes.Buffer
+		cfg := WriterConfig{Properties: &props, DictCap: 1 << 16}
+		if size >= 0 {
+			cfg.Size = size
+		}
+		w, err := cfg.NewRawWriter(&buf)
+		if err != nil {
+			t.Fatalf("NewRawWriter error %s", err)
+		}
+		if _, err = io.WriteString(w, text); err != nil {
+			t.Fatalf("WriteString error %s", err)
+		}
+		if err = w.Close(); err != nil {
+			t.Fatalf("w.Close error %s", err)
+		}
+		// The reader must not read the trailing data.
+		buf.WriteString("trailer")
+		r, err := ReaderConfig{DictCap: 1 << 16}.NewRawReader(&buf,
+			props, size)
+		if err != nil {
+			t.Fatalf("NewRawReader error %s", err)
+		}
+		out, err := ioutil.ReadAll(r)
+		if err != nil {
+			t.Fatalf("ReadAll error %s", err)
+		}
+		if string(out) != text {
+			t.Fatalf("got %q; want %q", out, text)
+		}
+		if buf.String() != "trailer" {
+			t.Fatalf("size %d: reader consumed trailing data", size)
+		}
+	}
+}
+
+func TestReaderEOSOptional(t *testing.T) {
+	const txtlen = 20000
+	var buf bytes.Buffer
+	io.CopyN(&buf, randtxt.NewReader(rand.NewSource(48)), txtlen)
+	txt := buf.Bytes()
+	props := Properties{LC: 3, LP: 0, PB: 2}
+	for _, eos := range []bool{false, true} {
+		cfg := WriterConfig{Properties: &props, DictCap: 1 << 16,
+			EOSMarker: eos}
+		if !eos {
+			cfg.Size = txtlen
+		}
+		var lz bytes.Buffer
+		w, err := cfg.NewRawWriter(&lz)
+		if err != nil {
+			t.Fatalf("NewRawWriter error %s", err)
+		}
+		if _, err = w.Write(txt); err != nil {
+			t.Fatalf("w.Write error %s", err)
+		}
+		if err = w.Close(); err != nil {
+			t.Fatalf("w.Close error %s", err)
+		}
+		data := lz.Bytes()
+		rc := ReaderConfig{DictCap: 1 << 16, EOSOptional: true}
+		r, err := rc.NewRawReader(bytes.NewReader(data), props, -1)
+		if err != nil {
+			t.Fatalf("NewRawReader error %s", err)
+		}
+		out, err := ioutil.ReadAll(r)
+		if err != nil {
+			t.Fatalf("eos %t: ReadAll error %s", eos, err)
+		}
+		if !bytes.Equal(out, txt) {
+			t.Fatalf("eos %t: decompressed data differs", eos)
+		}
+		if eos {
+			continue
+		}
+		// A truncated stream must not be accepted.
+		r, err = rc.NewRawReader(bytes.NewReader(data[:len(data)-1]),
+			props, -1)
+		if err != nil {
+			t.Fatalf("NewRawReader error %s", err)
+		}
+		if _, err = ioutil.ReadAll(r); err == nil {
+			t.Fatalf("truncated stream: no error")
+		}
+	}
+}
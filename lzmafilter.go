@@ -59,28 +59,59 @@ func (f *lzmaFilter) UnmarshalBinary(data []byte) error {
 	return nil
 }
 
-// reader creates a new reader for the LZMA2 filter.
-func (f lzmaFilter) reader(r io.Reader, c *ReaderConfig) (fr io.Reader,
+// readerConfig returns the configuration of the LZMA2 reader for the
+// filter.
+func (f lzmaFilter) readerConfig(c *ReaderConfig) (config lzma.Reader2Config,
 	err error) {
 
-	config := new(lzma.Reader2Config)
 	if c != nil {
 		config.DictCap = c.DictCap
+		config.MemLimit = c.MemLimit
 	}
 	dc := int(f.dictCap)
 	if dc < 1 {
-		return nil, errors.New("xz: LZMA2 filter parameter " +
+		return config, errors.New("xz: LZMA2 filter parameter " +
 			"dictionary capacity overflow")
 	}
 	if dc > config.DictCap {
 		config.DictCap = dc
 	}
+	return config, nil
+}
 
-	fr, err = config.NewReader2(r)
+// reader creates a new reader for the LZMA2 filter.
+func (f lzmaFilter) reader(r io.Reader, c *ReaderConfig) (fr io.Reader,
+	err error) {
+
+	config, err := f.readerConfig(c)
+	if err != nil {
+		return nil, err
+	}
+	lr, err := config.NewReader2(r)
 	if err != nil {
 		return nil, err
 	}
-	return fr, nil
+	return &lzma2Reader{Reader2: lr, dictCap: config.DictCap}, nil
+}
+
+// lzma2Reader is the reader for the LZMA2 filter. The xz reader keeps
+// it for the following blocks, so the dictionary can be reused.
+type lzma2Reader struct {
+	*lzma.Reader2
+	dictCap int
+}
+
+// reuse resets the reader for reading the LZMA2 data of the filter f
+// from r. It returns false if the dictionary of the reader is too small
+// for the filter.
+func (lr *lzma2Reader) reuse(f *lzmaFilter, r io.Reader, c *ReaderConfig,
+) bool {
+	config, err := f.readerConfig(c)
+	if err != nil || config.DictCap > lr.dictCap {
+		return false
+	}
+	lr.Reset(r)
+	return true
 }
 
 // writeCloser creates a io.WriteCloser for the LZMA2 filter.
@@ -93,17 +124,20 @@ func (f lzmaFilter) writeCloser(w io.WriteCloser, c *WriterConfig,
 			DictCap:    c.DictCap,
 			BufSize:    c.BufSize,
 			Matcher:    c.Matcher,
+			Mode:       c.Mode,
+			NiceLen:    c.NiceLen,
+			Depth:      c.Depth,
 		}
 	}
 
+	// The dictionary capacity of the filter defines the capacity
+	// stored in the block header; the encoder must not exceed it.
 	dc := int(f.dictCap)
 	if dc < 1 {
 		return nil, errors.New("xz: LZMA2 filter parameter " +
 			"dictionary capacity overflow")
 	}
-	if dc > config.DictCap {
-		config.DictCap = dc
-	}
+	config.DictCap = dc
 
 	fw, err = config.NewWriter2(w)
 	if err != nil {
@@ -3,8 +3,9 @@
 // license that can be found in the LICENSE file.
 
 // Package xz supports the compression and decompression of xz files. It
-// supports version 1.0.4 of the specification without the non-LZMA2
-// filters. See http://tukaani.org/xz/xz-file-format-1.0.4.txt
+// supports version 1.0.4 of the specification with the LZMA2 filter, the
+// delta filter and the branch/call/jump converter filters. See
+// http://tukaani.org/xz/xz-file-format-1.0.4.txt
 package xz
 
 import (
@@ -23,6 +24,59 @@ import (
 type ReaderConfig struct {
 	DictCap      int
 	SingleStream bool
+	// Workers defines the number of goroutines decoding blocks in
+	// parallel. The values 0 and 1 select sequential decoding.
+	// Parallel decoding requires that the compressed size of a block
+	// is known, either from the block header or from the index,
+	// which is read from the end of the input if it supports
+	// io.Seeker. Blocks with unknown size are decoded sequentially.
+	Workers int
+	// SkipUnknownCheck allows the reading of streams with check types
+	// reserved by the specification. The check fields are read but
+	// not verified; the method SkippedCheck of the Reader reports
+	// such streams. Without it ErrUnsupportedCheck is returned for
+	// such streams.
+	SkipUnknownCheck bool
+	// MemLimit limits the memory in bytes used for decoding a block.
+	// The memory usage is estimated from the dictionary capacity of
+	// the LZMA2 filter in the block header before the dictionary is
+	// allocated. If the limit is exceeded, an error of type
+	// *lzma.MemLimitError is returned. For parallel decoding the
+	// limit covers the dictionaries and the buffered compressed and
+	// uncompressed data of all jobs. Fewer blocks are decoded in
+	// parallel if required and a block exceeding the limit on its
+	// own is decoded sequentially. The value zero means no limit.
+	MemLimit int64
+	// MaxOutput limits the number of uncompressed bytes. If the input
+	// contains more data, ErrOutputLimit is returned. The value zero
+	// means no limit.
+	MaxOutput int64
+	// MaxRatio limits the ratio of uncompressed to compressed bytes
+	// consumed. The ratio is checked only after
+	// lzma.MinRatioCheckSize bytes have been decompressed. If the
+	// ratio is exceeded, ErrRatioLimit is returned. The value zero
+	// means no limit.
+	MaxRatio int
+}
+
+// Errors returned if the output limits of the reader are exceeded.
+var (
+	ErrOutputLimit = errors.New("xz: output limit exceeded")
+	ErrRatioLimit  = errors.New("xz: compression ratio limit exceeded")
+)
+
+// checkOutputLimits checks n uncompressed bytes against the output
+// limits given the compressed bytes consumed.
+func (c *ReaderConfig) checkOutputLimits(n, compressed int64) error {
+	if c.MaxOutput > 0 && n > c.MaxOutput {
+		return ErrOutputLimit
+	}
+	// n > MaxRatio*compressed without the risk of an overflow
+	if c.MaxRatio > 0 && n >= lzma.MinRatioCheckSize &&
+		(n-1)/int64(c.MaxRatio) >= compressed {
+		return ErrRatioLimit
+	}
+	return nil
 }
 
 // fill replaces all zero values with their default values.
@@ -42,15 +96,53 @@ func (c *ReaderConfig) Verify() error {
 	if err := lc.Verify(); err != nil {
 		return err
 	}
+	if c.Workers < 0 {
+		return errors.New("xz: number of workers out of range")
+	}
+	if c.MemLimit < 0 {
+		return errors.New("xz: memory limit is negative")
+	}
+	if c.MaxOutput < 0 {
+		return errors.New("xz: output limit is negative")
+	}
+	if c.MaxRatio < 0 {
+		return errors.New("xz: ratio limit is negative")
+	}
 	return nil
 }
 
+// newHashFunc returns a function that creates hash instances for the
+// check type in flags. Unsupported checks are skipped if requested.
+func (c *ReaderConfig) newHashFunc(flags byte) (newHash func() hash.Hash,
+	err error) {
+
+	newHash, err = newHashFunc(flags)
+	if err == ErrUnsupportedCheck && c.SkipUnknownCheck {
+		size := checkSize(flags)
+		return func() hash.Hash { return skipHash{size} }, nil
+	}
+	return newHash, err
+}
+
 // Reader supports the reading of one or multiple xz streams.
 type Reader struct {
 	ReaderConfig
 
 	xz io.Reader
 	sr *streamReader
+	// stream indexes read from the end of a seekable input
+	streams []streamIndex
+	// LZMA2 reader reused by the following streams
+	lzma2 *lzma2Reader
+
+	// fields used for the output limits
+	cxz *countingReader
+	n   int64
+	err error
+
+	// reserved check type of the last stream with skipped checks
+	skippedCheck byte
+	skipped      bool
 }
 
 // streamReader decodes a single xz stream
@@ -62,6 +154,25 @@ type streamReader struct {
 	newHash func() hash.Hash
 	h       header
 	index   []record
+	// LZMA2 reader reused by the following blocks
+	lzma2 *lzma2Reader
+
+	// fields used for parallel decoding
+	records    []record
+	jobs       []*decodeJob
+	out        []byte
+	bh         *blockHeader
+	hlen       int
+	indexFound bool
+	// header of the next block waiting for memory
+	next    *blockHeader
+	nextLen int
+	// estimated memory usage of the jobs
+	mem int64
+	// uncompressed bytes returned by the Reader and its counting
+	// reader; used to limit the output of the decoding jobs
+	n   int64
+	cxz *countingReader
 }
 
 // NewReader creates a new xz reader using the default parameters.
@@ -78,23 +189,104 @@ func (c ReaderConfig) NewReader(xz io.Reader) (r *Reader, err error) {
 	if err = c.Verify(); err != nil {
 		return nil, err
 	}
-	r = &Reader{
-		ReaderConfig: c,
-		xz:           xz,
+	r = &Reader{ReaderConfig: c}
+	if err = r.Reset(xz); err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+// Reset discards the state of the reader and reads the xz streams from
+// xz. The header of the first stream is read and checked. The LZMA2
+// dictionary of the previous block is reused, so Reset avoids the
+// allocations of a new reader.
+func (r *Reader) Reset(xz io.Reader) error {
+	if r.sr != nil {
+		r.lzma2 = r.sr.lzma2
+	}
+	r.xz = xz
+	r.sr = nil
+	r.streams = nil
+	r.cxz = nil
+	r.n = 0
+	r.err = nil
+	r.skipped = false
+	if r.Workers > 1 {
+		if rs, ok := xz.(io.ReadSeeker); ok {
+			// Without the indexes only the block headers
+			// can provide the block sizes.
+			r.streams, _ = scanIndexes(rs)
+		}
+	}
+	if r.MaxOutput > 0 || r.MaxRatio > 0 {
+		r.cxz = &countingReader{r: xz}
+		r.xz = r.cxz
 	}
-	if r.sr, err = c.newStreamReader(xz); err != nil {
+	var err error
+	if r.sr, err = r.newStreamReader(); err != nil {
 		if err == io.EOF {
 			err = io.ErrUnexpectedEOF
 		}
+		return err
+	}
+	return nil
+}
+
+// newStreamReader creates the reader for the next stream and provides
+// it with the index records if they are known.
+func (r *Reader) newStreamReader() (sr *streamReader, err error) {
+	if sr, err = r.ReaderConfig.newStreamReader(r.xz); err != nil {
 		return nil, err
 	}
-	return r, nil
+	if len(r.streams) > 0 {
+		sr.records = r.streams[0].records
+		r.streams = r.streams[1:]
+	}
+	sr.lzma2 = r.lzma2
+	sr.n, sr.cxz = r.n, r.cxz
+	if _, err = newHashFunc(sr.h.flags); err == ErrUnsupportedCheck {
+		r.skippedCheck, r.skipped = sr.h.flags, true
+	}
+	return sr, nil
+}
+
+// SkippedCheck returns the reserved check ID of the last stream read
+// whose checks haven't been verified, because SkipUnknownCheck is set.
+// The value ok is false if all streams read so far use supported check
+// types. Callers may use it to warn that the integrity isn't verified.
+func (r *Reader) SkippedCheck() (check byte, ok bool) {
+	return r.skippedCheck, r.skipped
 }
 
 var errUnexpectedData = errors.New("xz: unexpected data after stream")
 
 // Read reads uncompressed data from the stream.
 func (r *Reader) Read(p []byte) (n int, err error) {
+	if r.cxz == nil {
+		return r.read(p)
+	}
+	if r.err != nil {
+		return 0, r.err
+	}
+	if m := r.MaxOutput; m > 0 && int64(len(p)) > m-r.n {
+		// one additional byte detects the excess
+		p = p[:m-r.n+1]
+	}
+	n, err = r.read(p)
+	r.n += int64(n)
+	if lerr := r.checkOutputLimits(r.n, r.cxz.n); lerr != nil {
+		if m := r.MaxOutput; m > 0 && r.n > m {
+			n -= int(r.n - m)
+			r.n = m
+		}
+		r.err = lerr
+		return n, lerr
+	}
+	return n, err
+}
+
+// read reads uncompressed data from the streams.
+func (r *Reader) read(p []byte) (n int, err error) {
 	for n < len(p) {
 		if r.sr == nil {
 			if r.SingleStream {
@@ -106,7 +298,7 @@ func (r *Reader) Read(p []byte) (n int, err error) {
 				return n, io.EOF
 			}
 			for {
-				r.sr, err = r.ReaderConfig.newStreamReader(r.xz)
+				r.sr, err = r.newStreamReader()
 				if err != errPadding {
 					break
 				}
@@ -118,11 +310,14 @@ func (r *Reader) Read(p []byte) (n int, err error) {
 		k, err := r.sr.Read(p[n:])
 		n += k
 		if err != nil {
-			if err == io.EOF {
-				r.sr = nil
-				continue
+			if err != io.EOF {
+				return n, err
 			}
-			return n, err
+			r.lzma2 = r.sr.lzma2
+			r.sr = nil
+		}
+		if n > 0 {
+			return n, nil
 		}
 	}
 	return n, nil
@@ -157,7 +352,7 @@ func (c ReaderConfig) newStreamReader(xz io.Reader) (r *streamReader, err error)
 	if err = r.h.UnmarshalBinary(data); err != nil {
 		return nil, err
 	}
-	if r.newHash, err = newHashFunc(r.h.flags); err != nil {
+	if r.newHash, err = c.newHashFunc(r.h.flags); err != nil {
 		return nil, err
 	}
 	return r, nil
@@ -208,6 +403,11 @@ func (r *streamReader) readTail() error {
 
 // Read reads actual data from the xz stream.
 func (r *streamReader) Read(p []byte) (n int, err error) {
+	if r.Workers > 1 {
+		n, err = r.readParallel(p)
+		r.n += int64(n)
+		return n, err
+	}
 	for n < len(p) {
 		if r.br == nil {
 			bh, hlen, err := readBlockHeader(r.xz)
@@ -221,20 +421,25 @@ func (r *streamReader) Read(p []byte) (n int, err error) {
 				return n, err
 			}
 			r.br, err = r.ReaderConfig.newBlockReader(r.xz, bh,
-				hlen, r.newHash())
+				hlen, r.newHash(), r.lzma2)
 			if err != nil {
 				return n, err
 			}
+			r.lzma2 = r.br.lzma2
 		}
 		k, err := r.br.Read(p[n:])
 		n += k
 		if err != nil {
-			if err == io.EOF {
-				r.index = append(r.index, r.br.record())
-				r.br = nil
-			} else {
+			if err != io.EOF {
 				return n, err
 			}
+			r.index = append(r.index, r.br.record())
+			r.br = nil
+		}
+		if n > 0 {
+			// Return the data without waiting for more input,
+			// since the writer may have flushed the stream.
+			return n, nil
 		}
 	}
 	return n, nil
@@ -262,11 +467,15 @@ type blockReader struct {
 	hash      hash.Hash
 	r         io.Reader
 	err       error
+	// LZMA2 reader of the filter chain
+	lzma2 *lzma2Reader
 }
 
-// newBlockReader creates a new block reader.
+// newBlockReader creates a new block reader. The LZMA2 reader lr of a
+// previous block is reused if it is not nil.
 func (c *ReaderConfig) newBlockReader(xz io.Reader, h *blockHeader,
-	hlen int, hash hash.Hash) (br *blockReader, err error) {
+	hlen int, hash hash.Hash, lr *lzma2Reader) (br *blockReader,
+	err error) {
 
 	br = &blockReader{
 		lxz:       countingReader{r: xz},
@@ -275,10 +484,11 @@ func (c *ReaderConfig) newBlockReader(xz io.Reader, h *blockHeader,
 		hash:      hash,
 	}
 
-	fr, err := c.newFilterReader(&br.lxz, h.filters)
+	fr, lzma2, err := c.newFilterReader(&br.lxz, h.filters, lr)
 	if err != nil {
 		return nil, err
 	}
+	br.lzma2 = lzma2
 	br.r = io.TeeReader(fr, br.hash)
 
 	return br, nil
@@ -343,6 +553,9 @@ func (br *blockReader) Read(p []byte) (n int, err error) {
 	if !allZeros(q[:k]) {
 		return n, errors.New("xz: non-zero block padding")
 	}
+	if _, ok := br.hash.(skipHash); ok {
+		return n, io.EOF
+	}
 	checkSum := q[k:]
 	computedSum := br.hash.Sum(checkSum[s:])
 	if !bytes.Equal(checkSum, computedSum) {
@@ -351,19 +564,312 @@ func (br *blockReader) Read(p []byte) (n int, err error) {
 	return n, io.EOF
 }
 
-func (c *ReaderConfig) newFilterReader(r io.Reader, f []filter) (fr io.Reader,
-	err error) {
+// newFilterReader creates the reader for the filter chain f. The LZMA2
+// reader lr is reused if it is not nil and its dictionary is large
+// enough. The LZMA2 reader of the chain is returned as lzma2.
+func (c *ReaderConfig) newFilterReader(r io.Reader, f []filter,
+	lr *lzma2Reader) (fr io.Reader, lzma2 *lzma2Reader, err error) {
 
 	if err = verifyFilters(f); err != nil {
-		return nil, err
+		return nil, nil, err
 	}
 
 	fr = r
-	for i := len(f) - 1; i >= 0; i-- {
+	i := len(f) - 1
+	if lf, ok := f[i].(*lzmaFilter); ok && lr != nil &&
+		lr.reuse(lf, r, c) {
+		fr, lzma2 = lr, lr
+		i--
+	}
+	for ; i >= 0; i-- {
 		fr, err = f[i].reader(fr, c)
 		if err != nil {
-			return nil, err
+			return nil, nil, err
+		}
+		if lzma2 == nil {
+			lzma2, _ = fr.(*lzma2Reader)
+		}
+	}
+	return fr, lzma2, nil
+}
+
+// decodeJob holds a complete block that is decoded by its own
+// goroutine. The goroutine decodes at most limit bytes into out and
+// closes the channel done. If the block has not been decoded
+// completely, err is nil and decode must be called again after out
+// has been consumed. The field err is io.EOF at the end of the block.
+type decodeJob struct {
+	data  []byte
+	mem   int64
+	lxz   *bytes.Reader
+	br    *blockReader
+	limit int
+	out   []byte
+	rec   record
+	err   error
+	done  chan struct{}
+}
+
+// jobOutSize limits the number of bytes a job decodes in advance.
+const jobOutSize = 1 << 20
+
+// jobLimit returns the number of bytes a job may decode before it is
+// suspended. The argument u gives the uncompressed bytes remaining in
+// the block and is negative if unknown. The job doesn't decode more
+// data than Reader.Read accepts under the output limits.
+func (r *streamReader) jobLimit(u int64) int {
+	n := int64(jobOutSize)
+	if 0 <= u && u < n {
+		// one additional byte reads the end of the block
+		n = u + 1
+	}
+	if r.cxz == nil {
+		return int(n)
+	}
+	if m := r.MaxOutput; m > 0 && m-r.n+1 < n {
+		// one additional byte detects the excess
+		n = m - r.n + 1
+	}
+	// Read accepts max(lzma.MinRatioCheckSize, MaxRatio*c) bytes
+	// for c compressed bytes.
+	if q := int64(r.MaxRatio); q > 0 && r.cxz.n <= (r.n+n)/q {
+		k := q * r.cxz.n
+		if k < lzma.MinRatioCheckSize {
+			k = lzma.MinRatioCheckSize
+		}
+		if k-r.n+1 < n {
+			n = k - r.n + 1
+		}
+	}
+	if n < 1 {
+		n = 1
+	}
+	return int(n)
+}
+
+// minJobBufSize is the initial capacity of the output buffer of a job.
+const minJobBufSize = 1 << 16
+
+// decodeBlock creates the block reader for the data of the job and
+// starts decoding. The data must contain the compressed data, the block
+// padding and the check.
+func (c *ReaderConfig) decodeBlock(job *decodeJob, h *blockHeader, hlen int,
+	hash hash.Hash) {
+
+	job.lxz = bytes.NewReader(job.data)
+	job.br, job.err = c.newBlockReader(job.lxz, h, hlen, hash, nil)
+	if job.err != nil {
+		close(job.done)
+		return
+	}
+	job.decode()
+}
+
+// decode decodes at most job.limit bytes of the block into a new
+// output buffer. The buffer grows with the data actually decoded.
+func (job *decodeJob) decode() {
+	defer close(job.done)
+	n := job.limit
+	if n > minJobBufSize {
+		n = minJobBufSize
+	}
+	out := make([]byte, 0, n)
+	for len(out) < job.limit {
+		if len(out) == cap(out) {
+			n = 2 * cap(out)
+			if n > job.limit {
+				n = job.limit
+			}
+			out = append(make([]byte, 0, n), out...)
+		}
+		k, err := job.br.Read(out[len(out):cap(out)])
+		out = out[:len(out)+k]
+		if err != nil {
+			if err == io.EOF {
+				if job.lxz.Len() != 0 {
+					err = errors.New(
+						"xz: wrong compressed size for block")
+				} else {
+					job.rec = job.br.record()
+				}
+			}
+			job.err = err
+			break
+		}
+	}
+	job.out = out
+}
+
+// compressedBlockSize returns the size of the compressed data of the
+// next block. If the size is unknown a negative value will be returned.
+func (r *streamReader) compressedBlockSize(h *blockHeader, hlen int) int64 {
+	if h.compressedSize >= 0 {
+		return h.compressedSize
+	}
+	i := len(r.index) + len(r.jobs)
+	if i >= len(r.records) {
+		return -1
+	}
+	n := r.records[i].unpaddedSize - int64(hlen) -
+		int64(r.newHash().Size())
+	if n <= 0 {
+		return -1
+	}
+	return n
+}
+
+// lzma2Overhead approximates the memory used by the LZMA2 decoder
+// without the dictionary.
+const lzma2Overhead = 1 << 16
+
+// jobMemUsage estimates the memory required by a job decoding a block
+// with header h and k bytes of block data. The output of the previous
+// run of the job is counted, because it may still be read.
+func (r *streamReader) jobMemUsage(h *blockHeader, k int64, limit int) int64 {
+	m := k + 2*int64(limit)
+	for _, f := range h.filters {
+		if lf, ok := f.(*lzmaFilter); ok {
+			// errors are reported by the job
+			config, _ := lf.readerConfig(&r.ReaderConfig)
+			m += int64(config.DictCap) + lzma2Overhead
+		}
+	}
+	return m
+}
+
+// maxPrealloc limits the size of the buffer allocated for the
+// compressed data of a block before the data is read.
+const maxPrealloc = 1 << 26
+
+// startJobs reads blocks and starts decoding jobs until all workers are
+// busy, the index is found or a block with an unknown size is
+// encountered. The header of such a block is stored in the field bh.
+// A block is only started if the memory usage of all jobs stays below
+// MemLimit. A block that exceeds the limit on its own is stored in bh
+// as well.
+func (r *streamReader) startJobs() error {
+	for len(r.jobs) < r.Workers && r.bh == nil && !r.indexFound {
+		if r.next == nil {
+			bh, hlen, err := readBlockHeader(r.xz)
+			if err != nil {
+				if err == errIndexIndicator {
+					r.indexFound = true
+					return nil
+				}
+				return err
+			}
+			r.next, r.nextLen = bh, hlen
+		}
+		bh, hlen := r.next, r.nextLen
+		c := r.compressedBlockSize(bh, hlen)
+		if c < 0 || int64(int(c)) != c {
+			r.bh, r.hlen = bh, hlen
+			r.next = nil
+			return nil
 		}
+		hash := r.newHash()
+		k := c + int64(padLen(c)+hash.Size())
+		limit := r.jobLimit(bh.uncompressedSize)
+		m := r.jobMemUsage(bh, k, limit)
+		if r.MemLimit > 0 && r.mem+m > r.MemLimit {
+			if len(r.jobs) == 0 {
+				r.bh, r.hlen = bh, hlen
+				r.next = nil
+			}
+			return nil
+		}
+		r.next = nil
+		// The buffer grows with the data actually read to protect
+		// against wrong sizes in damaged input.
+		var data bytes.Buffer
+		if k <= maxPrealloc {
+			data.Grow(int(k))
+		}
+		if _, err := io.CopyN(&data, r.xz, k); err != nil {
+			if err == io.EOF {
+				err = io.ErrUnexpectedEOF
+			}
+			return err
+		}
+		job := &decodeJob{
+			data:  data.Bytes(),
+			mem:   m,
+			limit: limit,
+			done:  make(chan struct{}),
+		}
+		r.jobs = append(r.jobs, job)
+		r.mem += m
+		go r.decodeBlock(job, bh, hlen, hash)
 	}
-	return fr, nil
+	return nil
+}
+
+// readParallel reads the data of the stream using multiple goroutines
+// for decoding. The blocks are delivered in order.
+func (r *streamReader) readParallel(p []byte) (n int, err error) {
+	for n < len(p) {
+		if len(r.out) > 0 {
+			k := copy(p[n:], r.out)
+			r.out = r.out[k:]
+			n += k
+			continue
+		}
+		if r.br != nil {
+			k, err := r.br.Read(p[n:])
+			n += k
+			if err != nil {
+				if err != io.EOF {
+					return n, err
+				}
+				r.index = append(r.index, r.br.record())
+				r.br = nil
+			}
+			continue
+		}
+		if r.bh != nil && len(r.jobs) == 0 {
+			r.br, err = r.ReaderConfig.newBlockReader(r.xz, r.bh,
+				r.hlen, r.newHash(), nil)
+			if err != nil {
+				return n, err
+			}
+			r.bh = nil
+			continue
+		}
+		if err = r.startJobs(); err != nil {
+			return n, err
+		}
+		if len(r.jobs) > 0 {
+			job := r.jobs[0]
+			<-job.done
+			switch job.err {
+			case nil:
+				// Continue decoding while the output is read.
+				r.out, job.out = job.out, nil
+				u := job.br.header.uncompressedSize
+				if u >= 0 {
+					u -= job.br.uncompressedSize()
+				}
+				job.limit = r.jobLimit(u)
+				job.done = make(chan struct{})
+				go job.decode()
+			case io.EOF:
+				copy(r.jobs, r.jobs[1:])
+				r.jobs = r.jobs[:len(r.jobs)-1]
+				r.mem -= job.mem
+				r.index = append(r.index, job.rec)
+				r.out = job.out
+			default:
+				return n, job.err
+			}
+			continue
+		}
+		if r.bh != nil {
+			continue
+		}
+		if err = r.readTail(); err != nil {
+			return n, err
+		}
+		return n, io.EOF
+	}
+	return n, nil
 }
@@ -8,8 +8,13 @@ import (
 	"bytes"
 	"io"
 	"io/ioutil"
+	"math/rand"
 	"os"
+	"runtime"
 	"testing"
+
+	"github.com/ulikunitz/xz/internal/randtxt"
+	"github.com/ulikunitz/xz/lzma"
 )
 
 func TestReaderSimple(t *testing.T) {
@@ -79,3 +84,355 @@ func TestReaaderMultipleStreams(t *testing.T) {
 		t.Fatalf("io.Copy error %s", err)
 	}
 }
+
+func TestReaderWorkers(t *testing.T) {
+	const txtlen = 100000
+	var buf bytes.Buffer
+	io.CopyN(&buf, randtxt.NewReader(rand.NewSource(44)), txtlen)
+	txt := buf.String()
+
+	// two streams, the first without sizes in the block headers
+	buf.Reset()
+	for _, workers := range []int{1, 3} {
+		cfg := WriterConfig{
+			DictCap:   1 << 12,
+			BlockSize: 1 << 13,
+			Workers:   workers,
+		}
+		w, err := cfg.NewWriter(&buf)
+		if err != nil {
+			t.Fatalf("NewWriter error %s", err)
+		}
+		if _, err = io.WriteString(w, txt); err != nil {
+			t.Fatalf("WriteString error %s", err)
+		}
+		if err = w.Close(); err != nil {
+			t.Fatalf("w.Close error %s", err)
+		}
+	}
+	xz := buf.Bytes()
+
+	tests := []struct {
+		name string
+		r    io.Reader
+	}{
+		{"seekable", bytes.NewReader(xz)},
+		{"stream", struct{ io.Reader }{bytes.NewReader(xz)}},
+	}
+	for _, tc := range tests {
+		r, err := ReaderConfig{Workers: 4}.NewReader(tc.r)
+		if err != nil {
+			t.Fatalf("%s: NewReader error %s", tc.name, err)
+		}
+		var out bytes.Buffer
+		if _, err = io.Copy(&out, r); err != nil {
+			t.Fatalf("%s: io.Copy error %s", tc.name, err)
+		}
+		if out.String() != txt+txt {
+			t.Fatalf("%s: decompressed data differs from original",
+				tc.name)
+		}
+	}
+}
+
+// zeroBlocks returns an xz stream with n blocks of zeros with the given
+// block size.
+func zeroBlocks(t *testing.T, blockSize int64, n int) []byte {
+	var buf bytes.Buffer
+	cfg := WriterConfig{DictCap: 1 << 16, BlockSize: blockSize}
+	w, err := cfg.NewWriter(&buf)
+	if err != nil {
+		t.Fatalf("NewWriter error %s", err)
+	}
+	zeros := make([]byte, blockSize)
+	for i := 0; i < n; i++ {
+		if _, err = w.Write(zeros); err != nil {
+			t.Fatalf("w.Write error %s", err)
+		}
+	}
+	if err = w.Close(); err != nil {
+		t.Fatalf("w.Close error %s", err)
+	}
+	return buf.Bytes()
+}
+
+func TestReaderWorkersBufferedOutput(t *testing.T) {
+	const (
+		blockSize = 1 << 24
+		blocks    = 4
+	)
+	data := zeroBlocks(t, blockSize, blocks)
+
+	var before, after runtime.MemStats
+	runtime.ReadMemStats(&before)
+	r, err := ReaderConfig{DictCap: 1 << 16, Workers: blocks}.NewReader(
+		bytes.NewReader(data))
+	if err != nil {
+		t.Fatalf("NewReader error %s", err)
+	}
+	p := make([]byte, 1<<20)
+	if _, err = io.ReadFull(r, p); err != nil {
+		t.Fatalf("io.ReadFull error %s", err)
+	}
+	runtime.ReadMemStats(&after)
+	// The jobs must not decode complete blocks in advance.
+	if m := after.TotalAlloc - before.TotalAlloc; m > blockSize {
+		t.Fatalf("reading %d bytes allocated %d bytes", len(p), m)
+	}
+}
+
+func TestReaderWorkersOutputLimit(t *testing.T) {
+	const (
+		blockSize = 1 << 24
+		blocks    = 4
+		maxOutput = 1 << 12
+	)
+	data := zeroBlocks(t, blockSize, blocks)
+
+	var before, after runtime.MemStats
+	runtime.ReadMemStats(&before)
+	cfg := ReaderConfig{
+		DictCap:   1 << 16,
+		Workers:   blocks,
+		MaxOutput: maxOutput,
+	}
+	r, err := cfg.NewReader(bytes.NewReader(data))
+	if err != nil {
+		t.Fatalf("NewReader error %s", err)
+	}
+	out, err := ioutil.ReadAll(r)
+	if err != ErrOutputLimit {
+		t.Fatalf("got error %v; want %v", err, ErrOutputLimit)
+	}
+	if len(out) != maxOutput {
+		t.Fatalf("got %d bytes; want %d", len(out), maxOutput)
+	}
+	runtime.ReadMemStats(&after)
+	// The jobs must not decode more than the limit allows.
+	if m := after.TotalAlloc - before.TotalAlloc; m > 1<<20 {
+		t.Fatalf("reading %d bytes allocated %d bytes", len(out), m)
+	}
+}
+
+func TestReaderCheckNone(t *testing.T) {
+	const text = "The quick brown fox jumps over the lazy dog."
+	var buf bytes.Buffer
+	w, err := WriterConfig{NoCheckSum: true}.NewWriter(&buf)
+	if err != nil {
+		t.Fatalf("NewWriter error %s", err)
+	}
+	if _, err = io.WriteString(w, text); err != nil {
+		t.Fatalf("WriteString error %s", err)
+	}
+	if err = w.Close(); err != nil {
+		t.Fatalf("w.Close error %s", err)
+	}
+	if flags := buf.Bytes()[7]; flags != None {
+		t.Fatalf("stream flags %#02x; want %#02x", flags, None)
+	}
+	r, err := NewReader(&buf)
+	if err != nil {
+		t.Fatalf("NewReader error %s", err)
+	}
+	out, err := ioutil.ReadAll(r)
+	if err != nil {
+		t.Fatalf("ReadAll error %s", err)
+	}
+	if string(out) != text {
+		t.Fatalf("got %q; want %q", out, text)
+	}
+}
+
+func TestReaderUnknownCheck(t *testing.T) {
+	const text = "The quick brown fox jumps over the lazy dog."
+	var buf bytes.Buffer
+	w, err := WriterConfig{CheckSum: CRC32}.NewWriter(&buf)
+	if err != nil {
+		t.Fatalf("NewWriter error %s", err)
+	}
+	if _, err = io.WriteString(w, text); err != nil {
+		t.Fatalf("WriteString error %s", err)
+	}
+	if err = w.Close(); err != nil {
+		t.Fatalf("w.Close error %s", err)
+	}
+
+	// Change the check ID to the reserved ID 2, which has the same
+	// size as CRC-32, and recompute the CRCs of header and footer.
+	xz := buf.Bytes()
+	h := header{flags: 2}
+	p, err := h.MarshalBinary()
+	if err != nil {
+		t.Fatalf("header.MarshalBinary error %s", err)
+	}
+	copy(xz, p)
+	var f footer
+	if err = f.UnmarshalBinary(xz[len(xz)-footerLen:]); err != nil {
+		t.Fatalf("footer.UnmarshalBinary error %s", err)
+	}
+	f.flags = 2
+	if p, err = f.MarshalBinary(); err != nil {
+		t.Fatalf("footer.MarshalBinary error %s", err)
+	}
+	copy(xz[len(xz)-footerLen:], p)
+
+	r, err := NewReader(bytes.NewReader(xz))
+	if err != ErrUnsupportedCheck {
+		t.Fatalf("NewReader returned error %v; want %v", err,
+			ErrUnsupportedCheck)
+	}
+	r, err = ReaderConfig{SkipUnknownCheck: true}.NewReader(
+		bytes.NewReader(xz))
+	if err != nil {
+		t.Fatalf("NewReader error %s", err)
+	}
+	if check, ok := r.SkippedCheck(); !ok || check != 2 {
+		t.Fatalf("r.SkippedCheck() returned %d, %t; want 2, true",
+			check, ok)
+	}
+	out, err := ioutil.ReadAll(r)
+	if err != nil {
+		t.Fatalf("ReadAll error %s", err)
+	}
+	if string(out) != text {
+		t.Fatalf("got %q; want %q", out, text)
+	}
+}
+
+func TestReaderMemLimit(t *testing.T) {
+	const text = "The quick brown fox jumps over the lazy dog."
+	var buf bytes.Buffer
+	w, err := WriterConfig{DictCap: 1 << 20}.NewWriter(&buf)
+	if err != nil {
+		t.Fatalf("NewWriter error %s", err)
+	}
+	if _, err = io.WriteString(w, text); err != nil {
+		t.Fatalf("WriteString error %s", err)
+	}
+	if err = w.Close(); err != nil {
+		t.Fatalf("w.Close error %s", err)
+	}
+	data := buf.Bytes()
+
+	r, err := ReaderConfig{MemLimit: 1 << 20}.NewReader(
+		bytes.NewReader(data))
+	if err == nil {
+		_, err = ioutil.ReadAll(r)
+	}
+	if _, ok := err.(*lzma.MemLimitError); !ok {
+		t.Fatalf("got error %v; want *lzma.MemLimitError", err)
+	}
+	r, err = ReaderConfig{MemLimit: 1 << 21}.NewReader(
+		bytes.NewReader(data))
+	if err != nil {
+		t.Fatalf("NewReader error %s", err)
+	}
+	out, err := ioutil.ReadAll(r)
+	if err != nil {
+		t.Fatalf("ReadAll error %s", err)
+	}
+	if string(out) != text {
+		t.Fatalf("got %q; want %q", out, text)
+	}
+}
+
+func TestReaderWorkersMemLimit(t *testing.T) {
+	const txtlen = 1 << 20
+	var buf bytes.Buffer
+	io.CopyN(&buf, randtxt.NewReader(rand.NewSource(45)), txtlen)
+	txt := buf.String()
+	buf.Reset()
+	cfg := WriterConfig{DictCap: 8 << 20, BlockSize: 1 << 17}
+	w, err := cfg.NewWriter(&buf)
+	if err != nil {
+		t.Fatalf("NewWriter error %s", err)
+	}
+	if _, err = io.WriteString(w, txt); err != nil {
+		t.Fatalf("WriteString error %s", err)
+	}
+	if err = w.Close(); err != nil {
+		t.Fatalf("w.Close error %s", err)
+	}
+	data := buf.Bytes()
+
+	tests := []struct {
+		memLimit int64
+		fail     bool
+	}{
+		{20 << 20, false},
+		// the blocks must be decoded sequentially
+		{8<<20 + 1<<17, false},
+		{1 << 20, true},
+	}
+	for _, tc := range tests {
+		cfg := ReaderConfig{Workers: 4, MemLimit: tc.memLimit}
+		r, err := cfg.NewReader(bytes.NewReader(data))
+		if err != nil {
+			t.Fatalf("NewReader error %s", err)
+		}
+		out, err := ioutil.ReadAll(r)
+		if tc.fail {
+			if _, ok := err.(*lzma.MemLimitError); !ok {
+				t.Errorf("MemLimit %d: got error %v; "+
+					"want *lzma.MemLimitError",
+					tc.memLimit, err)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("MemLimit %d: ReadAll error %s",
+				tc.memLimit, err)
+			continue
+		}
+		if string(out) != txt {
+			t.Errorf("MemLimit %d: decompressed data differs",
+				tc.memLimit)
+		}
+	}
+}
+
+func TestReaderOutputLimits(t *testing.T) {
+	const size = 1 << 21
+	var buf bytes.Buffer
+	w, err := NewWriter(&buf)
+	if err != nil {
+		t.Fatalf("NewWriter error %s", err)
+	}
+	if _, err = w.Write(make([]byte, size)); err != nil {
+		t.Fatalf("w.Write error %s", err)
+	}
+	if err = w.Close(); err != nil {
+		t.Fatalf("w.Close error %s", err)
+	}
+	data := buf.Bytes()
+
+	tests := []struct {
+		cfg ReaderConfig
+		n   int
+		err error
+	}{
+		{ReaderConfig{MaxOutput: 1000}, 1000, ErrOutputLimit},
+		{ReaderConfig{MaxOutput: size}, size, nil},
+		{ReaderConfig{MaxRatio: 10}, -1, ErrRatioLimit},
+		{ReaderConfig{MaxRatio: 1 << 20}, size, nil},
+		{ReaderConfig{MaxOutput: 1000, Workers: 4}, 1000, ErrOutputLimit},
+		{ReaderConfig{MaxOutput: size, Workers: 4}, size, nil},
+		{ReaderConfig{MaxRatio: 10, Workers: 4}, -1, ErrRatioLimit},
+		{ReaderConfig{MaxRatio: 1 << 20, Workers: 4}, size, nil},
+	}
+	for _, tc := range tests {
+		r, err := tc.cfg.NewReader(bytes.NewReader(data))
+		if err != nil {
+			t.Fatalf("NewReader error %s", err)
+		}
+		out, err := ioutil.ReadAll(r)
+		if err != tc.err {
+			t.Errorf("%+v: got error %v; want %v", tc.cfg, err,
+				tc.err)
+		}
+		if tc.n >= 0 && len(out) != tc.n {
+			t.Errorf("%+v: got %d bytes; want %d", tc.cfg,
+				len(out), tc.n)
+		}
+	}
+}
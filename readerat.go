@@ -0,0 +1,267 @@
+// Copyright 2014-2017 Ulrich Kunitz. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package xz
+
+import (
+	"bufio"
+	"errors"
+	"io"
+	"io/ioutil"
+	"sort"
+)
+
+// blockEntry describes the position of a block in the compressed and
+// uncompressed data.
+type blockEntry struct {
+	// offset of the block header in the xz file
+	offset int64
+	// offset of the block data in the uncompressed data
+	uoffset int64
+	// index record of the block
+	rec record
+	// flags of the stream containing the block
+	flags byte
+}
+
+// ReaderAt provides random access to the uncompressed data of an xz
+// file. The footers and indexes of all streams are read at creation
+// time; afterwards only the blocks containing the requested data are
+// decoded. The random access is efficient only if the data has been
+// compressed into multiple blocks.
+//
+// The method ReadAt may be called concurrently. The methods Read and
+// Seek share an offset and must not be called concurrently.
+type ReaderAt struct {
+	ReaderConfig
+
+	ra     io.ReaderAt
+	blocks []blockEntry
+	size   int64
+
+	// offset used by Read and Seek
+	pos int64
+	// block reader used by Read, bi is the index of the block and
+	// bpos the offset of the next byte in the uncompressed data
+	br   *blockReader
+	bi   int
+	bpos int64
+}
+
+// NewReaderAt creates a ReaderAt for the xz file provided by ra with
+// the given size using the default configuration.
+func NewReaderAt(ra io.ReaderAt, size int64) (r *ReaderAt, err error) {
+	return ReaderConfig{}.NewReaderAt(ra, size)
+}
+
+// NewReaderAt creates a ReaderAt for the xz file provided by ra with
+// the given size. The fields SingleStream and Workers of the
+// configuration are not used. The output limits MaxOutput and MaxRatio
+// are not supported by ReaderAt; an error is returned if they are set.
+func (c ReaderConfig) NewReaderAt(ra io.ReaderAt, size int64) (r *ReaderAt,
+	err error) {
+
+	if err = c.Verify(); err != nil {
+		return nil, err
+	}
+	if c.MaxOutput != 0 || c.MaxRatio != 0 {
+		return nil, errors.New(
+			"xz: ReaderAt doesn't support MaxOutput and MaxRatio")
+	}
+	streams, err := readStreamIndexes(ra, size)
+	if err != nil {
+		return nil, err
+	}
+	r = &ReaderAt{ReaderConfig: c, ra: ra}
+	for _, s := range streams {
+		if _, err = c.newHashFunc(s.flags); err != nil {
+			return nil, err
+		}
+		off := s.offset + HeaderLen
+		for _, rec := range s.records {
+			if rec.uncompressedSize > maxInt64-r.size {
+				return nil, errors.New(
+					"xz: uncompressed size overflow")
+			}
+			r.blocks = append(r.blocks, blockEntry{
+				offset:  off,
+				uoffset: r.size,
+				rec:     rec,
+				flags:   s.flags,
+			})
+			off += rec.paddedSize()
+			r.size += rec.uncompressedSize
+		}
+	}
+	return r, nil
+}
+
+// Size returns the size of the uncompressed data.
+func (r *ReaderAt) Size() int64 {
+	return r.size
+}
+
+// blockIndex returns the index of the block containing the byte at
+// offset off of the uncompressed data. If off is outside of the data
+// the number of blocks is returned.
+func (r *ReaderAt) blockIndex(off int64) int {
+	return sort.Search(len(r.blocks), func(i int) bool {
+		b := &r.blocks[i]
+		return b.uoffset+b.rec.uncompressedSize > off
+	})
+}
+
+// openBlock returns a reader for block i that has already skipped the
+// first skip bytes of the uncompressed block data.
+func (r *ReaderAt) openBlock(i int, skip int64) (br *blockReader, err error) {
+	b := &r.blocks[i]
+	xz := bufio.NewReader(io.NewSectionReader(r.ra, b.offset,
+		b.rec.paddedSize()))
+	h, hlen, err := readBlockHeader(xz)
+	if err != nil {
+		if err == errIndexIndicator {
+			err = errIndex
+		}
+		return nil, err
+	}
+	if h.uncompressedSize >= 0 &&
+		h.uncompressedSize != b.rec.uncompressedSize {
+		return nil, errIndex
+	}
+	newHash, err := r.ReaderConfig.newHashFunc(b.flags)
+	if err != nil {
+		return nil, err
+	}
+	br, err = r.ReaderConfig.newBlockReader(xz, h, hlen, newHash(),
+		nil)
+	if err != nil {
+		return nil, err
+	}
+	if _, err = io.CopyN(ioutil.Discard, br, skip); err != nil {
+		if err == io.EOF {
+			err = io.ErrUnexpectedEOF
+		}
+		return nil, err
+	}
+	return br, nil
+}
+
+// readBlock reads data from the reader for block i. It reads at most
+// up to the end of the block. If the end of the block is reached, the
+// padding and the check of the block are verified.
+func (r *ReaderAt) readBlock(br *blockReader, i int, p []byte) (n int,
+	err error) {
+
+	rec := r.blocks[i].rec
+	if k := rec.uncompressedSize - br.uncompressedSize(); k < int64(len(p)) {
+		p = p[:k]
+	}
+	n, err = io.ReadFull(br, p)
+	if err != nil {
+		if err == io.EOF {
+			err = io.ErrUnexpectedEOF
+		}
+		return n, err
+	}
+	if br.uncompressedSize() < rec.uncompressedSize {
+		return n, nil
+	}
+	var q [1]byte
+	k, err := br.Read(q[:])
+	if err != io.EOF {
+		if err == nil && k > 0 {
+			err = errIndex
+		}
+		return n, err
+	}
+	if br.record() != rec {
+		return n, errIndex
+	}
+	return n, nil
+}
+
+// ReadAt reads len(p) bytes of the uncompressed data starting at offset
+// off. It decodes only the blocks containing the data. If fewer than
+// len(p) bytes are available, io.EOF is returned. A read ending exactly
+// at the end of the data returns a nil error.
+func (r *ReaderAt) ReadAt(p []byte, off int64) (n int, err error) {
+	if off < 0 {
+		return 0, errors.New("xz: negative offset")
+	}
+	for i := r.blockIndex(off); n < len(p) && i < len(r.blocks); i++ {
+		br, err := r.openBlock(i, off+int64(n)-r.blocks[i].uoffset)
+		if err != nil {
+			return n, err
+		}
+		k, err := r.readBlock(br, i, p[n:])
+		n += k
+		if err != nil {
+			return n, err
+		}
+	}
+	if n < len(p) {
+		return n, io.EOF
+	}
+	return n, nil
+}
+
+// Read reads uncompressed data starting at the current offset. A single
+// call doesn't read beyond the end of a block.
+func (r *ReaderAt) Read(p []byte) (n int, err error) {
+	if r.pos >= r.size {
+		return 0, io.EOF
+	}
+	if r.br != nil && r.bpos != r.pos {
+		b := &r.blocks[r.bi]
+		if r.bpos < r.pos && r.pos < b.uoffset+b.rec.uncompressedSize {
+			_, err = io.CopyN(ioutil.Discard, r.br, r.pos-r.bpos)
+			if err != nil {
+				r.br = nil
+				if err == io.EOF {
+					err = io.ErrUnexpectedEOF
+				}
+				return 0, err
+			}
+			r.bpos = r.pos
+		} else {
+			r.br = nil
+		}
+	}
+	if r.br == nil {
+		i := r.blockIndex(r.pos)
+		r.br, err = r.openBlock(i, r.pos-r.blocks[i].uoffset)
+		if err != nil {
+			r.br = nil
+			return 0, err
+		}
+		r.bi, r.bpos = i, r.pos
+	}
+	n, err = r.readBlock(r.br, r.bi, p)
+	r.pos += int64(n)
+	r.bpos = r.pos
+	b := &r.blocks[r.bi]
+	if err != nil || r.pos >= b.uoffset+b.rec.uncompressedSize {
+		r.br = nil
+	}
+	return n, err
+}
+
+// Seek sets the offset for the next Read. Offsets beyond the end of the
+// uncompressed data are allowed; Read will return io.EOF for them.
+func (r *ReaderAt) Seek(offset int64, whence int) (int64, error) {
+	switch whence {
+	case io.SeekStart:
+	case io.SeekCurrent:
+		offset += r.pos
+	case io.SeekEnd:
+		offset += r.size
+	default:
+		return r.pos, errors.New("xz: invalid whence")
+	}
+	if offset < 0 {
+		return r.pos, errors.New("xz: negative position")
+	}
+	r.pos = offset
+	return offset, nil
+}
@@ -0,0 +1,115 @@
+// Copyright 2014-2017 Ulrich Kunitz. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package xz
+
+import (
+	"bytes"
+	"io"
+	"io/ioutil"
+	"math/rand"
+	"sync"
+	"testing"
+
+	"github.com/ulikunitz/xz/internal/randtxt"
+)
+
+func TestReaderAt(t *testing.T) {
+	const txtlen = 50000
+	var buf bytes.Buffer
+	io.CopyN(&buf, randtxt.NewReader(rand.NewSource(45)), 2*txtlen)
+	txt := append([]byte(nil), buf.Bytes()...)
+
+	// two streams with multiple blocks and stream padding
+	buf.Reset()
+	for i := 0; i < 2; i++ {
+		cfg := WriterConfig{DictCap: 1 << 12, BlockSize: 1 << 12}
+		w, err := cfg.NewWriter(&buf)
+		if err != nil {
+			t.Fatalf("NewWriter error %s", err)
+		}
+		if _, err = w.Write(txt[i*txtlen : (i+1)*txtlen]); err != nil {
+			t.Fatalf("w.Write error %s", err)
+		}
+		if err = w.Close(); err != nil {
+			t.Fatalf("w.Close error %s", err)
+		}
+		buf.Write(make([]byte, 8))
+	}
+
+	xz := bytes.NewReader(buf.Bytes())
+	r, err := NewReaderAt(xz, int64(xz.Len()))
+	if err != nil {
+		t.Fatalf("NewReaderAt error %s", err)
+	}
+	if r.Size() != int64(len(txt)) {
+		t.Fatalf("r.Size() returned %d; want %d", r.Size(), len(txt))
+	}
+
+	var wg sync.WaitGroup
+	for g := 0; g < 4; g++ {
+		wg.Add(1)
+		go func(seed int64) {
+			defer wg.Done()
+			rnd := rand.New(rand.NewSource(seed))
+			for i := 0; i < 20; i++ {
+				off := rnd.Int63n(int64(len(txt)))
+				p := make([]byte, rnd.Intn(10000))
+				n, err := r.ReadAt(p, off)
+				want := txt[off:]
+				var wantErr error
+				if len(want) > len(p) {
+					want = want[:len(p)]
+				} else if len(want) < len(p) {
+					wantErr = io.EOF
+				}
+				if err != wantErr {
+					t.Errorf("ReadAt(%d, len %d) error %v; "+
+						"want %v", off, len(p), err,
+						wantErr)
+					return
+				}
+				if !bytes.Equal(p[:n], want) {
+					t.Errorf("ReadAt(%d) returned wrong data", off)
+					return
+				}
+			}
+		}(int64(g))
+	}
+	wg.Wait()
+
+	// A read ending exactly at the end of the data succeeds.
+	p := make([]byte, 100)
+	n, err := r.ReadAt(p, int64(len(txt)-len(p)))
+	if n != len(p) || err != nil {
+		t.Fatalf("ReadAt at end returned %d, %v; want %d, nil",
+			n, err, len(p))
+	}
+
+	const off = 30000
+	if _, err = r.Seek(off, io.SeekStart); err != nil {
+		t.Fatalf("Seek error %s", err)
+	}
+	if _, err = io.ReadFull(r, p); err != nil {
+		t.Fatalf("ReadFull error %s", err)
+	}
+	if _, err = r.Seek(1000, io.SeekCurrent); err != nil {
+		t.Fatalf("Seek error %s", err)
+	}
+	rest, err := ioutil.ReadAll(r)
+	if err != nil {
+		t.Fatalf("ReadAll error %s", err)
+	}
+	if !bytes.Equal(p, txt[off:off+100]) ||
+		!bytes.Equal(rest, txt[off+1100:]) {
+		t.Fatalf("Read after Seek returned wrong data")
+	}
+
+	for _, cfg := range []ReaderConfig{{MaxOutput: 1 << 20},
+		{MaxRatio: 10}} {
+		if _, err = cfg.NewReaderAt(xz, int64(xz.Len())); err == nil {
+			t.Errorf("NewReaderAt accepted %+v", cfg)
+		}
+	}
+}
@@ -0,0 +1,135 @@
+// Copyright 2014-2017 Ulrich Kunitz. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package sevenzip
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+
+	"github.com/ulikunitz/xz/internal/bcj"
+	"github.com/ulikunitz/xz/internal/delta"
+	"github.com/ulikunitz/xz/lzma"
+)
+
+// Method IDs of the coders supported by the package.
+const (
+	methodCopy     = 0x00
+	methodDelta    = 0x03
+	methodX86      = 0x03030103
+	methodPowerPC  = 0x03030205
+	methodIA64     = 0x03030401
+	methodARM      = 0x03030501
+	methodARMThumb = 0x03030701
+	methodSPARC    = 0x03030805
+	methodARM64    = 0x0a
+	methodRISCV    = 0x0b
+	methodLZMA     = 0x030101
+	methodLZMA2    = 0x21
+	methodAES      = 0x06f10701
+)
+
+// ErrEncrypted indicates that the archive contains encrypted data,
+// which is not supported.
+var ErrEncrypted = errors.New("sevenzip: encrypted archives not supported")
+
+// UnsupportedMethodError reports a coder method that is not supported
+// by the package.
+type UnsupportedMethodError struct {
+	ID uint64
+}
+
+// Error returns the error message.
+func (e *UnsupportedMethodError) Error() string {
+	return fmt.Sprintf("sevenzip: unsupported coder method %#x", e.ID)
+}
+
+// bcjConverters maps the method IDs of the branch/call/jump filters to
+// the converters.
+var bcjConverters = map[uint64]func(encoder bool) bcj.Converter{
+	methodX86:      bcj.NewX86,
+	methodPowerPC:  bcj.NewPowerPC,
+	methodIA64:     bcj.NewIA64,
+	methodARM:      bcj.NewARM,
+	methodARMThumb: bcj.NewARMThumb,
+	methodSPARC:    bcj.NewSPARC,
+	methodARM64:    bcj.NewARM64,
+	methodRISCV:    bcj.NewRISCV,
+}
+
+// newCoderReader creates the reader that decodes the input streams of
+// the coder. The argument size gives the size of the output. The
+// dictionary capacities of the LZMA coders are checked against the
+// memory limit of the config.
+func newCoderReader(c *coder, in []io.Reader, size uint64,
+	config *ReaderConfig) (r io.Reader, err error) {
+
+	if c.id == methodAES {
+		return nil, ErrEncrypted
+	}
+	if len(in) != 1 {
+		return nil, &UnsupportedMethodError{c.id}
+	}
+	if size >= 1<<63 {
+		return nil, errHeader
+	}
+	switch c.id {
+	case methodCopy:
+		return io.LimitReader(in[0], int64(size)), nil
+	case methodDelta:
+		if len(c.props) != 1 {
+			return nil, errHeader
+		}
+		return delta.NewReader(in[0], int(c.props[0])+1), nil
+	case methodLZMA:
+		if len(c.props) != 5 {
+			return nil, errHeader
+		}
+		p, err := lzma.PropertiesForCode(c.props[0])
+		if err != nil {
+			return nil, err
+		}
+		dictCap := int64(binary.LittleEndian.Uint32(c.props[1:]))
+		if dictCap < lzma.MinDictCap {
+			dictCap = lzma.MinDictCap
+		}
+		lc := lzma.ReaderConfig{
+			DictCap:  int(dictCap),
+			MemLimit: config.MemLimit,
+		}
+		return lc.NewRawReader(bufio.NewReader(in[0]), p, int64(size))
+	case methodLZMA2:
+		if len(c.props) != 1 {
+			return nil, errHeader
+		}
+		dictCap, err := lzma.DecodeDictCap(c.props[0])
+		if err != nil {
+			return nil, err
+		}
+		if dictCap < lzma.MinDictCap {
+			dictCap = lzma.MinDictCap
+		}
+		lc := lzma.Reader2Config{
+			DictCap:  int(dictCap),
+			MemLimit: config.MemLimit,
+		}
+		return lc.NewReader2(bufio.NewReader(in[0]))
+	}
+	newConverter, ok := bcjConverters[c.id]
+	if !ok {
+		return nil, &UnsupportedMethodError{c.id}
+	}
+	var start uint32
+	switch len(c.props) {
+	case 0:
+	case 4:
+		start = binary.LittleEndian.Uint32(c.props)
+	default:
+		return nil, errHeader
+	}
+	return bcj.NewReader(in[0], newConverter(false), start), nil
+}
@@ -0,0 +1,924 @@
+// Copyright 2014-2017 Ulrich Kunitz. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package sevenzip
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"hash/crc32"
+	"io"
+	"time"
+	"unicode/utf16"
+)
+
+// signature stores the magic bytes at the start of a 7z archive.
+var signature = []byte{'7', 'z', 0xbc, 0xaf, 0x27, 0x1c}
+
+// signatureHeaderLen is the length of the signature header. All
+// offsets in the archive are relative to its end.
+const signatureHeaderLen = 32
+
+// Property IDs used in the archive headers.
+const (
+	idEnd                   = 0x00
+	idHeader                = 0x01
+	idArchiveProperties     = 0x02
+	idAdditionalStreamsInfo = 0x03
+	idMainStreamsInfo       = 0x04
+	idFilesInfo             = 0x05
+	idPackInfo              = 0x06
+	idUnpackInfo            = 0x07
+	idSubStreamsInfo        = 0x08
+	idSize                  = 0x09
+	idCRC                   = 0x0a
+	idFolder                = 0x0b
+	idCodersUnpackSize      = 0x0c
+	idNumUnpackStream       = 0x0d
+	idEmptyStream           = 0x0e
+	idEmptyFile             = 0x0f
+	idName                  = 0x11
+	idCTime                 = 0x12
+	idATime                 = 0x13
+	idMTime                 = 0x14
+	idWinAttributes         = 0x15
+	idEncodedHeader         = 0x17
+)
+
+// errHeader indicates a corrupt archive header.
+var errHeader = errors.New("sevenzip: corrupt header")
+
+// startHeader describes the position of the header at the end of the
+// archive.
+type startHeader struct {
+	offset int64
+	size   int64
+	crc    uint32
+}
+
+// UnmarshalBinary reads the signature header. The length of data must
+// be signatureHeaderLen.
+func (h *startHeader) UnmarshalBinary(data []byte) error {
+	if len(data) != signatureHeaderLen {
+		return errors.New("sevenzip: wrong signature header length")
+	}
+	if !bytes.Equal(data[:len(signature)], signature) {
+		return errors.New("sevenzip: invalid signature")
+	}
+	if data[6] != 0 {
+		return errors.New("sevenzip: unsupported format version")
+	}
+	if crc32.ChecksumIEEE(data[12:]) != binary.LittleEndian.Uint32(data[8:]) {
+		return errors.New("sevenzip: start header checksum error")
+	}
+	offset := binary.LittleEndian.Uint64(data[12:])
+	size := binary.LittleEndian.Uint64(data[20:])
+	if offset >= 1<<62 || size >= 1<<62 {
+		return errHeader
+	}
+	h.offset = int64(offset)
+	h.size = int64(size)
+	h.crc = binary.LittleEndian.Uint32(data[28:])
+	return nil
+}
+
+// headerReader reads the data types of the archive header from a byte
+// slice.
+type headerReader struct {
+	p []byte
+}
+
+// readByte reads a single byte.
+func (h *headerReader) readByte() (b byte, err error) {
+	if len(h.p) == 0 {
+		return 0, errHeader
+	}
+	b = h.p[0]
+	h.p = h.p[1:]
+	return b, nil
+}
+
+// readBytes reads n bytes. The returned slice shares the memory with
+// the header.
+func (h *headerReader) readBytes(n uint64) (p []byte, err error) {
+	if n > uint64(len(h.p)) {
+		return nil, errHeader
+	}
+	p = h.p[:n]
+	h.p = h.p[n:]
+	return p, nil
+}
+
+// readNumber reads a number in the variable-length encoding of the 7z
+// format. The number of leading one bits in the first byte gives the
+// number of bytes following in little-endian order; the remaining bits
+// of the first byte provide the most significant bits.
+func (h *headerReader) readNumber() (x uint64, err error) {
+	first, err := h.readByte()
+	if err != nil {
+		return 0, err
+	}
+	mask := byte(0x80)
+	for i := uint(0); i < 8; i++ {
+		if first&mask == 0 {
+			hi := uint64(first & (mask - 1))
+			return x | hi<<(8*i), nil
+		}
+		b, err := h.readByte()
+		if err != nil {
+			return 0, err
+		}
+		x |= uint64(b) << (8 * i)
+		mask >>= 1
+	}
+	return x, nil
+}
+
+// readCount reads a number giving the count of the following items. The
+// count is checked against the remaining header length, which protects
+// against huge allocations.
+func (h *headerReader) readCount() (n int, err error) {
+	x, err := h.readNumber()
+	if err != nil {
+		return 0, err
+	}
+	if x > uint64(len(h.p)) {
+		return 0, errHeader
+	}
+	return int(x), nil
+}
+
+// readUint32 reads a little-endian uint32 value.
+func (h *headerReader) readUint32() (x uint32, err error) {
+	p, err := h.readBytes(4)
+	if err != nil {
+		return 0, err
+	}
+	return binary.LittleEndian.Uint32(p), nil
+}
+
+// readUint64 reads a little-endian uint64 value.
+func (h *headerReader) readUint64() (x uint64, err error) {
+	p, err := h.readBytes(8)
+	if err != nil {
+		return 0, err
+	}
+	return binary.LittleEndian.Uint64(p), nil
+}
+
+// expect reads the next property ID and checks it against id.
+func (h *headerReader) expect(id uint64) error {
+	x, err := h.readNumber()
+	if err != nil {
+		return err
+	}
+	if x != id {
+		return errHeader
+	}
+	return nil
+}
+
+// skipProperty skips the data of a property whose ID has already been
+// read.
+func (h *headerReader) skipProperty() error {
+	n, err := h.readNumber()
+	if err != nil {
+		return err
+	}
+	_, err = h.readBytes(n)
+	return err
+}
+
+// readBits reads a bit vector of n bits. The most significant bit of
+// a byte comes first.
+func (h *headerReader) readBits(n int) (v []bool, err error) {
+	p, err := h.readBytes(uint64(n+7) / 8)
+	if err != nil {
+		return nil, err
+	}
+	v = make([]bool, n)
+	for i := range v {
+		v[i] = p[i/8]&(0x80>>uint(i%8)) != 0
+	}
+	return v, nil
+}
+
+// readDefined reads the vector of defined items that starts with a byte
+// indicating whether all items are defined.
+func (h *headerReader) readDefined(n int) (v []bool, err error) {
+	all, err := h.readByte()
+	if err != nil {
+		return nil, err
+	}
+	if all == 0 {
+		return h.readBits(n)
+	}
+	v = make([]bool, n)
+	for i := range v {
+		v[i] = true
+	}
+	return v, nil
+}
+
+// readDigests reads n CRC32 values. The returned vector records which
+// values are defined.
+func (h *headerReader) readDigests(n int) (crcs []uint32, defined []bool,
+	err error) {
+
+	if defined, err = h.readDefined(n); err != nil {
+		return nil, nil, err
+	}
+	crcs = make([]uint32, n)
+	for i, d := range defined {
+		if !d {
+			continue
+		}
+		if crcs[i], err = h.readUint32(); err != nil {
+			return nil, nil, err
+		}
+	}
+	return crcs, defined, nil
+}
+
+// coder describes a single coder of a folder.
+type coder struct {
+	id     uint64
+	numIn  int
+	numOut int
+	props  []byte
+}
+
+// bindPair connects the output stream of a coder with the input stream
+// of another coder.
+type bindPair struct {
+	in  int
+	out int
+}
+
+// folder describes a combination of coders that creates a single
+// output stream from one or more packed streams. A folder may contain
+// the data of several files, which is called a solid block.
+type folder struct {
+	coders    []coder
+	bindPairs []bindPair
+	// input streams that read the packed streams
+	packed      []int
+	unpackSizes []uint64
+	crc         uint32
+	hasCRC      bool
+	// index of the first packed stream of the folder
+	firstPack int
+	// number of files in the folder
+	numSubstreams int
+}
+
+// numInOut returns the total number of input and output streams of the
+// coders.
+func (f *folder) numInOut() (in, out int) {
+	for _, c := range f.coders {
+		in += c.numIn
+		out += c.numOut
+	}
+	return in, out
+}
+
+// mainOut returns the index of the output stream that is not bound to
+// an input stream. It provides the output of the folder.
+func (f *folder) mainOut() int {
+	_, out := f.numInOut()
+outer:
+	for i := 0; i < out; i++ {
+		for _, bp := range f.bindPairs {
+			if bp.out == i {
+				continue outer
+			}
+		}
+		return i
+	}
+	return -1
+}
+
+// size returns the size of the uncompressed folder data.
+func (f *folder) size() uint64 {
+	return f.unpackSizes[f.mainOut()]
+}
+
+// maxCoders limits the number of coders in a folder.
+const maxCoders = 64
+
+// readFolder reads the description of a folder.
+func (h *headerReader) readFolder() (f *folder, err error) {
+	n, err := h.readCount()
+	if err != nil {
+		return nil, err
+	}
+	if !(1 <= n && n <= maxCoders) {
+		return nil, errHeader
+	}
+	f = &folder{coders: make([]coder, n)}
+	for i := range f.coders {
+		c := &f.coders[i]
+		flags, err := h.readByte()
+		if err != nil {
+			return nil, err
+		}
+		if flags&0xc0 != 0 {
+			return nil, errors.New(
+				"sevenzip: alternative coder methods not supported")
+		}
+		id, err := h.readBytes(uint64(flags & 0x0f))
+		if err != nil {
+			return nil, err
+		}
+		if len(id) > 8 {
+			return nil, errHeader
+		}
+		for _, b := range id {
+			c.id = c.id<<8 | uint64(b)
+		}
+		c.numIn, c.numOut = 1, 1
+		if flags&0x10 != 0 {
+			if c.numIn, err = h.readCount(); err != nil {
+				return nil, err
+			}
+			if c.numOut, err = h.readCount(); err != nil {
+				return nil, err
+			}
+			if c.numIn > maxCoders || c.numOut != 1 {
+				return nil, errors.New(
+					"sevenzip: unsupported coder streams")
+			}
+		}
+		if flags&0x20 != 0 {
+			m, err := h.readNumber()
+			if err != nil {
+				return nil, err
+			}
+			if c.props, err = h.readBytes(m); err != nil {
+				return nil, err
+			}
+		}
+	}
+	in, out := f.numInOut()
+	f.bindPairs = make([]bindPair, out-1)
+	for i := range f.bindPairs {
+		bp := &f.bindPairs[i]
+		if bp.in, err = h.readCount(); err != nil {
+			return nil, err
+		}
+		if bp.out, err = h.readCount(); err != nil {
+			return nil, err
+		}
+		if bp.in >= in || bp.out >= out {
+			return nil, errHeader
+		}
+	}
+	numPacked := in - len(f.bindPairs)
+	if numPacked < 1 {
+		return nil, errHeader
+	}
+	if numPacked == 1 {
+		for i := 0; i < in; i++ {
+			if f.bindPairForIn(i) < 0 {
+				f.packed = []int{i}
+				break
+			}
+		}
+		if f.packed == nil {
+			return nil, errHeader
+		}
+	} else {
+		f.packed = make([]int, numPacked)
+		for i := range f.packed {
+			if f.packed[i], err = h.readCount(); err != nil {
+				return nil, err
+			}
+			if f.packed[i] >= in {
+				return nil, errHeader
+			}
+		}
+	}
+	if f.mainOut() < 0 {
+		return nil, errHeader
+	}
+	return f, nil
+}
+
+// bindPairForIn returns the index of the bind pair for the input stream
+// or -1 if there is none.
+func (f *folder) bindPairForIn(in int) int {
+	for i, bp := range f.bindPairs {
+		if bp.in == in {
+			return i
+		}
+	}
+	return -1
+}
+
+// streamsInfo describes the packed streams, the folders and the
+// substreams of the archive.
+type streamsInfo struct {
+	packPos   uint64
+	packSizes []uint64
+	folders   []*folder
+	// sizes and checksums of the substreams
+	subSizes  []uint64
+	subCRCs   []uint32
+	subHasCRC []bool
+}
+
+// readPackInfo reads the information about the packed streams.
+func (h *headerReader) readPackInfo(si *streamsInfo) (err error) {
+	if si.packPos, err = h.readNumber(); err != nil {
+		return err
+	}
+	n, err := h.readCount()
+	if err != nil {
+		return err
+	}
+	for {
+		id, err := h.readNumber()
+		if err != nil {
+			return err
+		}
+		switch id {
+		case idEnd:
+			if si.packSizes == nil && n > 0 {
+				return errHeader
+			}
+			return nil
+		case idSize:
+			si.packSizes = make([]uint64, n)
+			for i := range si.packSizes {
+				si.packSizes[i], err = h.readNumber()
+				if err != nil {
+					return err
+				}
+			}
+		case idCRC:
+			// The packed streams are checked by the coders.
+			if _, _, err = h.readDigests(n); err != nil {
+				return err
+			}
+		default:
+			if err = h.skipProperty(); err != nil {
+				return err
+			}
+		}
+	}
+}
+
+// readUnpackInfo reads the folders.
+func (h *headerReader) readUnpackInfo(si *streamsInfo) error {
+	if err := h.expect(idFolder); err != nil {
+		return err
+	}
+	n, err := h.readCount()
+	if err != nil {
+		return err
+	}
+	external, err := h.readByte()
+	if err != nil {
+		return err
+	}
+	if external != 0 {
+		return errors.New("sevenzip: external folders not supported")
+	}
+	si.folders = make([]*folder, n)
+	packIndex := 0
+	for i := range si.folders {
+		f, err := h.readFolder()
+		if err != nil {
+			return err
+		}
+		f.firstPack = packIndex
+		packIndex += len(f.packed)
+		f.numSubstreams = 1
+		si.folders[i] = f
+	}
+	if packIndex > len(si.packSizes) {
+		return errHeader
+	}
+	if err = h.expect(idCodersUnpackSize); err != nil {
+		return err
+	}
+	for _, f := range si.folders {
+		_, out := f.numInOut()
+		f.unpackSizes = make([]uint64, out)
+		for j := range f.unpackSizes {
+			if f.unpackSizes[j], err = h.readNumber(); err != nil {
+				return err
+			}
+		}
+	}
+	for {
+		id, err := h.readNumber()
+		if err != nil {
+			return err
+		}
+		switch id {
+		case idEnd:
+			return nil
+		case idCRC:
+			crcs, defined, err := h.readDigests(n)
+			if err != nil {
+				return err
+			}
+			for i, f := range si.folders {
+				f.crc, f.hasCRC = crcs[i], defined[i]
+			}
+		default:
+			if err = h.skipProperty(); err != nil {
+				return err
+			}
+		}
+	}
+}
+
+// readSubStreamsInfo reads the sizes and checksums of the files stored
+// in the folders.
+func (h *headerReader) readSubStreamsInfo(si *streamsInfo) error {
+	id, err := h.readNumber()
+	if err != nil {
+		return err
+	}
+	if id == idNumUnpackStream {
+		total := 0
+		for _, f := range si.folders {
+			if f.numSubstreams, err = h.readCount(); err != nil {
+				return err
+			}
+			total += f.numSubstreams
+			if total > len(h.p)+len(si.folders) {
+				return errHeader
+			}
+		}
+		if id, err = h.readNumber(); err != nil {
+			return err
+		}
+	}
+	si.subSizes = si.subSizes[:0]
+	for _, f := range si.folders {
+		if f.numSubstreams == 0 {
+			continue
+		}
+		var sum uint64
+		if id == idSize {
+			for j := 1; j < f.numSubstreams; j++ {
+				s, err := h.readNumber()
+				if err != nil {
+					return err
+				}
+				si.subSizes = append(si.subSizes, s)
+				sum += s
+				if sum < s || sum > f.size() {
+					return errHeader
+				}
+			}
+		} else if f.numSubstreams > 1 {
+			return errHeader
+		}
+		si.subSizes = append(si.subSizes, f.size()-sum)
+	}
+	if id == idSize {
+		if id, err = h.readNumber(); err != nil {
+			return err
+		}
+	}
+	si.setFolderCRCs()
+	numDigests := 0
+	for _, f := range si.folders {
+		if !(f.numSubstreams == 1 && f.hasCRC) {
+			numDigests += f.numSubstreams
+		}
+	}
+	for {
+		switch id {
+		case idEnd:
+			return nil
+		case idCRC:
+			crcs, defined, err := h.readDigests(numDigests)
+			if err != nil {
+				return err
+			}
+			k, j := 0, 0
+			for _, f := range si.folders {
+				if f.numSubstreams == 1 && f.hasCRC {
+					k++
+					continue
+				}
+				for i := 0; i < f.numSubstreams; i++ {
+					si.subCRCs[k] = crcs[j]
+					si.subHasCRC[k] = defined[j]
+					k++
+					j++
+				}
+			}
+		default:
+			if err = h.skipProperty(); err != nil {
+				return err
+			}
+		}
+		if id, err = h.readNumber(); err != nil {
+			return err
+		}
+	}
+}
+
+// setFolderCRCs initializes the checksums of the substreams using the
+// checksums of the folders containing a single substream.
+func (si *streamsInfo) setFolderCRCs() {
+	n := len(si.subSizes)
+	si.subCRCs = make([]uint32, n)
+	si.subHasCRC = make([]bool, n)
+	k := 0
+	for _, f := range si.folders {
+		if f.numSubstreams == 1 {
+			si.subCRCs[k], si.subHasCRC[k] = f.crc, f.hasCRC
+		}
+		k += f.numSubstreams
+	}
+}
+
+// readStreamsInfo reads the streams information.
+func (h *headerReader) readStreamsInfo() (si *streamsInfo, err error) {
+	si = new(streamsInfo)
+	subStreams := false
+	for {
+		id, err := h.readNumber()
+		if err != nil {
+			return nil, err
+		}
+		switch id {
+		case idEnd:
+			if !subStreams {
+				for _, f := range si.folders {
+					si.subSizes = append(si.subSizes,
+						f.size())
+				}
+				si.setFolderCRCs()
+			}
+			return si, nil
+		case idPackInfo:
+			err = h.readPackInfo(si)
+		case idUnpackInfo:
+			err = h.readUnpackInfo(si)
+		case idSubStreamsInfo:
+			subStreams = true
+			err = h.readSubStreamsInfo(si)
+		default:
+			err = errHeader
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+}
+
+// fileInfo contains the file properties stored in the header.
+type fileInfo struct {
+	name        string
+	emptyStream bool
+	emptyFile   bool
+	attributes  uint32
+	hasAttrib   bool
+	created     time.Time
+	accessed    time.Time
+	modified    time.Time
+}
+
+// readFilesInfo reads the properties of the files.
+func (h *headerReader) readFilesInfo() (files []fileInfo, err error) {
+	n, err := h.readCount()
+	if err != nil {
+		return nil, err
+	}
+	files = make([]fileInfo, n)
+	var emptyStreams []int
+	for {
+		id, err := h.readNumber()
+		if err != nil {
+			return nil, err
+		}
+		if id == idEnd {
+			return files, nil
+		}
+		size, err := h.readNumber()
+		if err != nil {
+			return nil, err
+		}
+		p, err := h.readBytes(size)
+		if err != nil {
+			return nil, err
+		}
+		ph := &headerReader{p: p}
+		switch id {
+		case idEmptyStream:
+			v, err := ph.readBits(n)
+			if err != nil {
+				return nil, err
+			}
+			emptyStreams = emptyStreams[:0]
+			for i, b := range v {
+				files[i].emptyStream = b
+				if b {
+					emptyStreams = append(emptyStreams, i)
+				}
+			}
+		case idEmptyFile:
+			v, err := ph.readBits(len(emptyStreams))
+			if err != nil {
+				return nil, err
+			}
+			for i, b := range v {
+				files[emptyStreams[i]].emptyFile = b
+			}
+		case idName:
+			err = ph.readNames(files)
+		case idCTime, idATime, idMTime:
+			err = ph.readTimes(files, id)
+		case idWinAttributes:
+			err = ph.readAttributes(files)
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+}
+
+// readExternal reads the external flag, which must be zero.
+func (h *headerReader) readExternal() error {
+	external, err := h.readByte()
+	if err != nil {
+		return err
+	}
+	if external != 0 {
+		return errors.New("sevenzip: external data not supported")
+	}
+	return nil
+}
+
+// readNames reads the file names, which are stored as zero-terminated
+// UTF-16LE strings.
+func (h *headerReader) readNames(files []fileInfo) error {
+	if err := h.readExternal(); err != nil {
+		return err
+	}
+	if len(h.p)%2 != 0 {
+		return errHeader
+	}
+	var u []uint16
+	i := 0
+	for j := 0; j < len(h.p); j += 2 {
+		c := binary.LittleEndian.Uint16(h.p[j:])
+		if c != 0 {
+			u = append(u, c)
+			continue
+		}
+		if i >= len(files) {
+			return errHeader
+		}
+		files[i].name = string(utf16.Decode(u))
+		u = u[:0]
+		i++
+	}
+	if i != len(files) {
+		return errHeader
+	}
+	return nil
+}
+
+// filetimeOffset is the number of 100-nanosecond intervals between
+// January 1, 1601 and January 1, 1970.
+const filetimeOffset = 116444736000000000
+
+// filetime converts a Windows FILETIME value into a time.
+func filetime(ft uint64) time.Time {
+	t := int64(ft - filetimeOffset)
+	return time.Unix(t/1e7, (t%1e7)*100).UTC()
+}
+
+// readTimes reads the times identified by id.
+func (h *headerReader) readTimes(files []fileInfo, id uint64) error {
+	defined, err := h.readDefined(len(files))
+	if err != nil {
+		return err
+	}
+	if err = h.readExternal(); err != nil {
+		return err
+	}
+	for i, d := range defined {
+		if !d {
+			continue
+		}
+		ft, err := h.readUint64()
+		if err != nil {
+			return err
+		}
+		t := filetime(ft)
+		switch id {
+		case idCTime:
+			files[i].created = t
+		case idATime:
+			files[i].accessed = t
+		default:
+			files[i].modified = t
+		}
+	}
+	return nil
+}
+
+// readAttributes reads the file attributes.
+func (h *headerReader) readAttributes(files []fileInfo) error {
+	defined, err := h.readDefined(len(files))
+	if err != nil {
+		return err
+	}
+	if err = h.readExternal(); err != nil {
+		return err
+	}
+	for i, d := range defined {
+		if !d {
+			continue
+		}
+		if files[i].attributes, err = h.readUint32(); err != nil {
+			return err
+		}
+		files[i].hasAttrib = true
+	}
+	return nil
+}
+
+// header contains the main information of the archive.
+type header struct {
+	streams *streamsInfo
+	files   []fileInfo
+}
+
+// readHeader reads the archive header following the header ID.
+func (h *headerReader) readHeader() (hdr *header, err error) {
+	hdr = new(header)
+	id, err := h.readNumber()
+	if err != nil {
+		return nil, err
+	}
+	if id == idArchiveProperties {
+		for {
+			if id, err = h.readNumber(); err != nil {
+				return nil, err
+			}
+			if id == idEnd {
+				break
+			}
+			if err = h.skipProperty(); err != nil {
+				return nil, err
+			}
+		}
+		if id, err = h.readNumber(); err != nil {
+			return nil, err
+		}
+	}
+	if id == idAdditionalStreamsInfo {
+		if _, err = h.readStreamsInfo(); err != nil {
+			return nil, err
+		}
+		if id, err = h.readNumber(); err != nil {
+			return nil, err
+		}
+	}
+	if id == idMainStreamsInfo {
+		if hdr.streams, err = h.readStreamsInfo(); err != nil {
+			return nil, err
+		}
+		if id, err = h.readNumber(); err != nil {
+			return nil, err
+		}
+	}
+	if id == idFilesInfo {
+		if hdr.files, err = h.readFilesInfo(); err != nil {
+			return nil, err
+		}
+		if id, err = h.readNumber(); err != nil {
+			return nil, err
+		}
+	}
+	if id != idEnd {
+		return nil, errHeader
+	}
+	if hdr.streams == nil {
+		hdr.streams = new(streamsInfo)
+	}
+	return hdr, nil
+}
+
+// readFull reads the buffer and converts io.EOF into
+// io.ErrUnexpectedEOF.
+func readFull(r io.Reader, p []byte) error {
+	_, err := io.ReadFull(r, p)
+	if err == io.EOF {
+		err = io.ErrUnexpectedEOF
+	}
+	return err
+}
@@ -0,0 +1,565 @@
+// Copyright 2014-2017 Ulrich Kunitz. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// Package sevenzip supports the reading of 7z archives. It lists the
+// entries of an archive and extracts files from archives that are not
+// encrypted. The package supports the copy, LZMA, LZMA2 and delta coders
+// and the branch/call/jump converters, which cover the archives created
+// by the 7-Zip defaults. Solid blocks and packed headers are supported;
+// the BCJ2 converter and multi-volume archives are not. See
+// https://www.7-zip.org/recover.html and the file 7zFormat.txt of the
+// LZMA SDK for the format.
+package sevenzip
+
+import (
+	"bytes"
+	"errors"
+	"hash"
+	"hash/crc32"
+	"io"
+	"io/ioutil"
+	"os"
+	"sync"
+	"time"
+)
+
+// ReaderConfig defines the parameters for the 7z reader.
+type ReaderConfig struct {
+	// MemLimit limits the memory in bytes used by an LZMA or LZMA2
+	// coder. The memory usage is estimated from the dictionary
+	// capacity in the coder properties before the dictionary is
+	// allocated. If the limit is exceeded, an error of type
+	// *lzma.MemLimitError is returned. The value zero means no
+	// limit.
+	MemLimit int64
+}
+
+// Verify checks the reader parameters for validity.
+func (c *ReaderConfig) Verify() error {
+	if c == nil {
+		return errors.New("sevenzip: reader parameters are nil")
+	}
+	if c.MemLimit < 0 {
+		return errors.New("sevenzip: memory limit is negative")
+	}
+	return nil
+}
+
+// Reader provides access to the files of a 7z archive.
+type Reader struct {
+	File []*File
+
+	r       io.ReaderAt
+	streams *streamsInfo
+	config  ReaderConfig
+
+	// mu protects the cursor, which keeps the position in a folder
+	// after a file has been read completely, so the following file of
+	// a solid block can be read without decoding the folder again.
+	mu     sync.Mutex
+	cursor *folderCursor
+}
+
+// ReadCloser is a Reader that must be closed when no longer needed.
+type ReadCloser struct {
+	f *os.File
+	Reader
+}
+
+// OpenReader opens the 7z archive specified by name using the default
+// parameters.
+func OpenReader(name string) (*ReadCloser, error) {
+	return ReaderConfig{}.OpenReader(name)
+}
+
+// OpenReader opens the 7z archive specified by name.
+func (c ReaderConfig) OpenReader(name string) (*ReadCloser, error) {
+	if err := c.Verify(); err != nil {
+		return nil, err
+	}
+	f, err := os.Open(name)
+	if err != nil {
+		return nil, err
+	}
+	fi, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+	rc := &ReadCloser{f: f}
+	rc.config = c
+	if err = rc.init(f, fi.Size()); err != nil {
+		f.Close()
+		return nil, err
+	}
+	return rc, nil
+}
+
+// Close closes the 7z archive file.
+func (rc *ReadCloser) Close() error {
+	return rc.f.Close()
+}
+
+// NewReader returns a Reader for the 7z archive in r, which is assumed
+// to have the given size in bytes. The default parameters are used.
+func NewReader(r io.ReaderAt, size int64) (*Reader, error) {
+	return ReaderConfig{}.NewReader(r, size)
+}
+
+// NewReader returns a Reader for the 7z archive in r, which is assumed
+// to have the given size in bytes.
+func (c ReaderConfig) NewReader(r io.ReaderAt, size int64) (*Reader,
+	error) {
+
+	if err := c.Verify(); err != nil {
+		return nil, err
+	}
+	z := &Reader{config: c}
+	if err := z.init(r, size); err != nil {
+		return nil, err
+	}
+	return z, nil
+}
+
+// maxHeaderSize limits the size of the archive header.
+const maxHeaderSize = 1 << 30
+
+// init reads the headers of the archive.
+func (z *Reader) init(r io.ReaderAt, size int64) error {
+	z.r = r
+	data := make([]byte, signatureHeaderLen)
+	if err := readFull(io.NewSectionReader(r, 0, size), data); err != nil {
+		return err
+	}
+	var sh startHeader
+	if err := sh.UnmarshalBinary(data); err != nil {
+		return err
+	}
+	if sh.size == 0 {
+		// empty archive
+		z.streams = new(streamsInfo)
+		return nil
+	}
+	off := signatureHeaderLen + sh.offset
+	if sh.size > maxHeaderSize || off+sh.size > size {
+		return errHeader
+	}
+	data = make([]byte, sh.size)
+	if err := readFull(io.NewSectionReader(r, off, sh.size), data); err != nil {
+		return err
+	}
+	if crc32.ChecksumIEEE(data) != sh.crc {
+		return errors.New("sevenzip: header checksum error")
+	}
+	for {
+		h := &headerReader{p: data}
+		id, err := h.readNumber()
+		if err != nil {
+			return err
+		}
+		switch id {
+		case idHeader:
+			hdr, err := h.readHeader()
+			if err != nil {
+				return err
+			}
+			return z.setHeader(hdr)
+		case idEncodedHeader:
+			if data, err = z.decodeHeader(h); err != nil {
+				return err
+			}
+		default:
+			return errHeader
+		}
+	}
+}
+
+// decodeHeader decodes a packed header. It returns the data of the
+// header.
+func (z *Reader) decodeHeader(h *headerReader) (data []byte, err error) {
+	si, err := h.readStreamsInfo()
+	if err != nil {
+		return nil, err
+	}
+	if len(si.folders) == 0 {
+		return nil, errHeader
+	}
+	f := si.folders[0]
+	size := f.size()
+	if size > maxHeaderSize {
+		return nil, errHeader
+	}
+	fr, err := z.folderReader(si, f)
+	if err != nil {
+		return nil, err
+	}
+	var buf bytes.Buffer
+	if _, err = io.CopyN(&buf, fr, int64(size)); err != nil {
+		if err == io.EOF {
+			err = io.ErrUnexpectedEOF
+		}
+		return nil, err
+	}
+	data = buf.Bytes()
+	if f.hasCRC && crc32.ChecksumIEEE(data) != f.crc {
+		return nil, errors.New("sevenzip: header checksum error")
+	}
+	return data, nil
+}
+
+// setHeader creates the files of the archive from the header.
+func (z *Reader) setHeader(hdr *header) error {
+	z.streams = hdr.streams
+	si := z.streams
+	z.File = make([]*File, len(hdr.files))
+	// k counts the substreams, sub the substreams of the folder
+	folderIndex, sub, k := 0, 0, 0
+	var offset uint64
+	for i := range hdr.files {
+		fi := &hdr.files[i]
+		f := &File{
+			FileHeader: FileHeader{
+				Name:       fi.name,
+				Created:    fi.created,
+				Accessed:   fi.accessed,
+				Modified:   fi.modified,
+				Attributes: fi.attributes,
+			},
+			z:      z,
+			folder: -1,
+			isDir:  fi.emptyStream && !fi.emptyFile,
+		}
+		z.File[i] = f
+		if !fi.hasAttrib && f.isDir {
+			f.Attributes = attrDirectory
+		}
+		if fi.emptyStream {
+			continue
+		}
+		for folderIndex < len(si.folders) &&
+			sub >= si.folders[folderIndex].numSubstreams {
+			folderIndex++
+			sub = 0
+			offset = 0
+		}
+		if folderIndex >= len(si.folders) || k >= len(si.subSizes) {
+			return errHeader
+		}
+		size := si.subSizes[k]
+		if size >= 1<<63 {
+			return errHeader
+		}
+		f.folder = folderIndex
+		f.offset = offset
+		f.Size = int64(size)
+		f.CRC32, f.HasCRC = si.subCRCs[k], si.subHasCRC[k]
+		offset += size
+		sub++
+		k++
+	}
+	return nil
+}
+
+// folderReader creates the reader for the uncompressed data of the
+// folder.
+func (z *Reader) folderReader(si *streamsInfo, f *folder) (r io.Reader,
+	err error) {
+
+	off := int64(signatureHeaderLen) + int64(si.packPos)
+	for _, s := range si.packSizes[:f.firstPack] {
+		off += int64(s)
+	}
+	packed := make([]io.Reader, len(f.packed))
+	for i := range packed {
+		s := int64(si.packSizes[f.firstPack+i])
+		if s < 0 || off < 0 {
+			return nil, errHeader
+		}
+		packed[i] = io.NewSectionReader(z.r, off, s)
+		off += s
+	}
+	return f.outReader(f.mainOut(), packed, 0, &z.config)
+}
+
+// outReader returns the reader for the output stream out of the folder.
+// The depth argument protects against cycles in the bind pairs.
+func (f *folder) outReader(out int, packed []io.Reader, depth int,
+	config *ReaderConfig) (r io.Reader, err error) {
+
+	if depth > len(f.coders) {
+		return nil, errHeader
+	}
+	// All supported coders have a single output stream, so the
+	// index of the output stream is the index of the coder.
+	in := 0
+	for _, c := range f.coders[:out] {
+		in += c.numIn
+	}
+	c := &f.coders[out]
+	inputs := make([]io.Reader, c.numIn)
+	for j := range inputs {
+		inputs[j], err = f.inReader(in+j, packed, depth, config)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return newCoderReader(c, inputs, f.unpackSizes[out], config)
+}
+
+// inReader returns the reader for the input stream in of the folder.
+func (f *folder) inReader(in int, packed []io.Reader, depth int,
+	config *ReaderConfig) (r io.Reader, err error) {
+
+	if i := f.bindPairForIn(in); i >= 0 {
+		return f.outReader(f.bindPairs[i].out, packed, depth+1,
+			config)
+	}
+	for i, p := range f.packed {
+		if p == in {
+			return packed[i], nil
+		}
+	}
+	return nil, errHeader
+}
+
+// folderCursor stores the reader of a folder and the position in the
+// uncompressed data.
+type folderCursor struct {
+	folder int
+	r      io.Reader
+	pos    uint64
+}
+
+// Windows file attributes used by the package.
+const (
+	attrDirectory = 0x10
+	// The upper 16 bits contain the Unix mode.
+	attrUnixExtension = 0x8000
+)
+
+// FileHeader describes a file in a 7z archive.
+type FileHeader struct {
+	// Name of the file using slashes or backslashes as separator
+	// as stored in the archive
+	Name string
+	// uncompressed size of the file
+	Size int64
+	// times of the file; the zero value indicates that the time is
+	// not stored in the archive
+	Created  time.Time
+	Accessed time.Time
+	Modified time.Time
+	// Windows file attributes; if the bit 0x8000 is set, the upper
+	// 16 bits contain the Unix mode
+	Attributes uint32
+	// CRC32 checksum of the file data if HasCRC is set
+	CRC32  uint32
+	HasCRC bool
+}
+
+// Unix file type bits
+const (
+	sIFMT   = 0xf000
+	sIFSOCK = 0xc000
+	sIFLNK  = 0xa000
+	sIFBLK  = 0x6000
+	sIFDIR  = 0x4000
+	sIFCHR  = 0x2000
+	sIFIFO  = 0x1000
+	sISUID  = 0x800
+	sISGID  = 0x400
+	sISVTX  = 0x200
+)
+
+// Mode returns the permission and mode bits for the file header.
+func (h *FileHeader) Mode() (mode os.FileMode) {
+	if h.Attributes&attrUnixExtension != 0 {
+		m := h.Attributes >> 16
+		mode = os.FileMode(m & 0777)
+		switch m & sIFMT {
+		case sIFSOCK:
+			mode |= os.ModeSocket
+		case sIFLNK:
+			mode |= os.ModeSymlink
+		case sIFBLK:
+			mode |= os.ModeDevice
+		case sIFDIR:
+			mode |= os.ModeDir
+		case sIFCHR:
+			mode |= os.ModeDevice | os.ModeCharDevice
+		case sIFIFO:
+			mode |= os.ModeNamedPipe
+		}
+		if m&sISUID != 0 {
+			mode |= os.ModeSetuid
+		}
+		if m&sISGID != 0 {
+			mode |= os.ModeSetgid
+		}
+		if m&sISVTX != 0 {
+			mode |= os.ModeSticky
+		}
+		return mode
+	}
+	mode = 0666
+	if h.Attributes&0x01 != 0 {
+		// read-only
+		mode = 0444
+	}
+	if h.Attributes&attrDirectory != 0 {
+		mode |= os.ModeDir | 0111
+	}
+	return mode
+}
+
+// FileInfo returns an os.FileInfo for the file header.
+func (h *FileHeader) FileInfo() os.FileInfo {
+	return headerFileInfo{h}
+}
+
+// headerFileInfo implements os.FileInfo.
+type headerFileInfo struct {
+	fh *FileHeader
+}
+
+func (fi headerFileInfo) Name() string {
+	name := fi.fh.Name
+	for i := len(name) - 1; i >= 0; i-- {
+		if name[i] == '/' || name[i] == '\\' {
+			return name[i+1:]
+		}
+	}
+	return name
+}
+func (fi headerFileInfo) Size() int64        { return fi.fh.Size }
+func (fi headerFileInfo) IsDir() bool        { return fi.Mode().IsDir() }
+func (fi headerFileInfo) ModTime() time.Time { return fi.fh.Modified }
+func (fi headerFileInfo) Mode() os.FileMode  { return fi.fh.Mode() }
+func (fi headerFileInfo) Sys() interface{}   { return fi.fh }
+
+// File is a single file in a 7z archive. The file information is in
+// the embedded FileHeader. The file content can be accessed by calling
+// Open.
+type File struct {
+	FileHeader
+
+	z *Reader
+	// index of the folder or -1 for files without data
+	folder int
+	// offset of the file data in the folder
+	offset uint64
+	isDir  bool
+}
+
+// IsDir reports whether the file is a directory.
+func (f *File) IsDir() bool {
+	return f.isDir || f.Mode().IsDir()
+}
+
+// Open returns a ReadCloser that provides access to the file's
+// contents. Files of a solid block are read fastest in the order of
+// the archive, since a file following a completely read file doesn't
+// require the decoding of the preceding data again. Multiple files may
+// be read concurrently.
+func (f *File) Open() (io.ReadCloser, error) {
+	if f.folder < 0 {
+		if f.IsDir() {
+			return nil, errors.New("sevenzip: file is a directory")
+		}
+		return ioutil.NopCloser(bytes.NewReader(nil)), nil
+	}
+	z := f.z
+	z.mu.Lock()
+	c := z.cursor
+	if c != nil && c.folder == f.folder && c.pos <= f.offset {
+		z.cursor = nil
+	} else {
+		c = nil
+	}
+	z.mu.Unlock()
+	if c == nil {
+		r, err := z.folderReader(z.streams,
+			z.streams.folders[f.folder])
+		if err != nil {
+			return nil, err
+		}
+		c = &folderCursor{folder: f.folder, r: r}
+	}
+	if c.pos < f.offset {
+		n := int64(f.offset - c.pos)
+		k, err := io.CopyN(ioutil.Discard, c.r, n)
+		if err != nil {
+			if err == io.EOF {
+				err = io.ErrUnexpectedEOF
+			}
+			return nil, err
+		}
+		c.pos += uint64(k)
+	}
+	return &fileReader{f: f, c: c, n: f.Size, crc: crc32.NewIEEE()}, nil
+}
+
+// fileReader reads the data of a file from the folder.
+type fileReader struct {
+	f *File
+	c *folderCursor
+	// remaining bytes of the file
+	n      int64
+	crc    hash.Hash32
+	err    error
+	closed bool
+}
+
+// errChecksum indicates that the data of a file has a wrong checksum.
+var errChecksum = errors.New("sevenzip: checksum error")
+
+// Read reads the file data.
+func (r *fileReader) Read(p []byte) (n int, err error) {
+	if r.err != nil {
+		return 0, r.err
+	}
+	if r.n == 0 {
+		return 0, io.EOF
+	}
+	if int64(len(p)) > r.n {
+		p = p[:r.n]
+	}
+	n, err = r.c.r.Read(p)
+	r.crc.Write(p[:n])
+	r.n -= int64(n)
+	r.c.pos += uint64(n)
+	if r.n == 0 {
+		if r.f.HasCRC && r.crc.Sum32() != r.f.CRC32 {
+			r.err = errChecksum
+			return n, r.err
+		}
+		if err == nil || err == io.EOF {
+			return n, nil
+		}
+	}
+	if err != nil {
+		if err == io.EOF {
+			err = io.ErrUnexpectedEOF
+		}
+		r.err = err
+	}
+	return n, err
+}
+
+// Close closes the reader. If the file has been read completely, the
+// decoder may be reused for the next file of the folder.
+func (r *fileReader) Close() error {
+	if r.closed {
+		return nil
+	}
+	r.closed = true
+	if r.n == 0 && r.err == nil {
+		z := r.f.z
+		z.mu.Lock()
+		z.cursor = r.c
+		z.mu.Unlock()
+	}
+	r.err = errors.New("sevenzip: read after Close")
+	return nil
+}
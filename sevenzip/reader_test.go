@@ -0,0 +1,415 @@
+// Copyright 2014-2017 Ulrich Kunitz. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package sevenzip
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"io/ioutil"
+	"math/rand"
+	"os"
+	"testing"
+	"time"
+	"unicode/utf16"
+
+	"github.com/ulikunitz/xz/internal/bcj"
+	"github.com/ulikunitz/xz/internal/randtxt"
+	"github.com/ulikunitz/xz/lzma"
+)
+
+// numbers returns the content of numbers.txt in the test archives.
+func numbers() string {
+	var buf bytes.Buffer
+	for i := 1; i <= 1000; i++ {
+		fmt.Fprintf(&buf, "%d\n", i)
+	}
+	return buf.String()
+}
+
+func TestReaderTestdata(t *testing.T) {
+	files := map[string]string{
+		"a/fox.txt":   "The quick brown fox jumps over the lazy dog.\n",
+		"a/empty":     "",
+		"numbers.txt": numbers(),
+	}
+	dirs := map[string]bool{"a": true, "a/b": true}
+	modified := time.Date(2017, 5, 1, 12, 0, 0, 0, time.UTC)
+	for _, name := range []string{"copy.7z", "lzma.7z", "lzma2.7z"} {
+		z, err := OpenReader("testdata/" + name)
+		if err != nil {
+			t.Fatalf("%s: OpenReader error %s", name, err)
+		}
+		if len(z.File) != len(files)+len(dirs) {
+			t.Fatalf("%s: got %d files; want %d", name,
+				len(z.File), len(files)+len(dirs))
+		}
+		for _, f := range z.File {
+			if !f.Modified.Equal(modified) {
+				t.Errorf("%s: %s modified %s; want %s", name,
+					f.Name, f.Modified, modified)
+			}
+			if dirs[f.Name] {
+				if !f.IsDir() || !f.FileInfo().IsDir() {
+					t.Errorf("%s: %s is not a directory",
+						name, f.Name)
+				}
+				continue
+			}
+			want, ok := files[f.Name]
+			if !ok {
+				t.Fatalf("%s: unexpected file %s", name, f.Name)
+			}
+			if f.Size != int64(len(want)) {
+				t.Errorf("%s: %s size %d; want %d", name,
+					f.Name, f.Size, len(want))
+			}
+			r, err := f.Open()
+			if err != nil {
+				t.Fatalf("%s: %s Open error %s", name, f.Name, err)
+			}
+			got, err := ioutil.ReadAll(r)
+			if err != nil {
+				t.Fatalf("%s: %s ReadAll error %s", name,
+					f.Name, err)
+			}
+			r.Close()
+			if string(got) != want {
+				t.Errorf("%s: %s content differs", name, f.Name)
+			}
+		}
+		if err = z.Close(); err != nil {
+			t.Fatalf("%s: Close error %s", name, err)
+		}
+	}
+}
+
+// testCoder describes a coder of a test folder.
+type testCoder struct {
+	id    []byte
+	props []byte
+}
+
+// testFolder describes a folder of a test archive. The coders are
+// chained; the first coder produces the output of the folder.
+type testFolder struct {
+	coders []testCoder
+	packed []byte
+	// unpack sizes of the coders
+	sizes []uint64
+	files [][]byte
+}
+
+// putNumber appends x in the 7z number encoding. The function uses the
+// shortest form only for numbers smaller than 0x80.
+func putNumber(p []byte, x uint64) []byte {
+	if x < 0x80 {
+		return append(p, byte(x))
+	}
+	var b [8]byte
+	binary.LittleEndian.PutUint64(b[:], x)
+	return append(append(p, 0xff), b[:]...)
+}
+
+// buildArchive creates an archive with a plain header from the folders.
+func buildArchive(folders []testFolder, names []string) []byte {
+	var packed []byte
+	for _, f := range folders {
+		packed = append(packed, f.packed...)
+	}
+	h := []byte{idHeader, idMainStreamsInfo, idPackInfo, 0}
+	h = putNumber(h, uint64(len(folders)))
+	h = append(h, idSize)
+	for _, f := range folders {
+		h = putNumber(h, uint64(len(f.packed)))
+	}
+	h = append(h, idEnd, idUnpackInfo, idFolder)
+	h = putNumber(h, uint64(len(folders)))
+	h = append(h, 0)
+	for _, f := range folders {
+		h = putNumber(h, uint64(len(f.coders)))
+		for _, c := range f.coders {
+			flags := byte(len(c.id))
+			if c.props != nil {
+				flags |= 0x20
+			}
+			h = append(append(h, flags), c.id...)
+			if c.props != nil {
+				h = putNumber(h, uint64(len(c.props)))
+				h = append(h, c.props...)
+			}
+		}
+		// coder i reads the output of coder i+1
+		for i := 1; i < len(f.coders); i++ {
+			h = putNumber(h, uint64(i-1))
+			h = putNumber(h, uint64(i))
+		}
+	}
+	h = append(h, idCodersUnpackSize)
+	for _, f := range folders {
+		for _, s := range f.sizes {
+			h = putNumber(h, s)
+		}
+	}
+	h = append(h, idEnd, idSubStreamsInfo, idNumUnpackStream)
+	for _, f := range folders {
+		h = putNumber(h, uint64(len(f.files)))
+	}
+	h = append(h, idSize)
+	for _, f := range folders {
+		for _, p := range f.files[:len(f.files)-1] {
+			h = putNumber(h, uint64(len(p)))
+		}
+	}
+	h = append(h, idCRC, 1)
+	for _, f := range folders {
+		for _, p := range f.files {
+			var b [4]byte
+			binary.LittleEndian.PutUint32(b[:],
+				crc32.ChecksumIEEE(p))
+			h = append(h, b[:]...)
+		}
+	}
+	h = append(h, idEnd, idEnd, idFilesInfo)
+	h = putNumber(h, uint64(len(names)))
+	var u []byte
+	for _, name := range names {
+		for _, c := range utf16.Encode([]rune(name + "\x00")) {
+			u = append(u, byte(c), byte(c>>8))
+		}
+	}
+	h = append(h, idName)
+	h = putNumber(h, uint64(len(u)+1))
+	h = append(append(h, 0), u...)
+	h = append(h, idEnd, idEnd)
+
+	sh := make([]byte, signatureHeaderLen)
+	copy(sh, signature)
+	sh[7] = 4
+	binary.LittleEndian.PutUint64(sh[12:], uint64(len(packed)))
+	binary.LittleEndian.PutUint64(sh[20:], uint64(len(h)))
+	binary.LittleEndian.PutUint32(sh[28:], crc32.ChecksumIEEE(h))
+	binary.LittleEndian.PutUint32(sh[8:], crc32.ChecksumIEEE(sh[12:]))
+	return append(append(sh, packed...), h...)
+}
+
+// randomFiles creates n files of random text.
+func randomFiles(seed int64, n int) [][]byte {
+	files := make([][]byte, n)
+	r := randtxt.NewReader(rand.NewSource(seed))
+	for i := range files {
+		var buf bytes.Buffer
+		io.CopyN(&buf, r, int64(1000+3000*i))
+		files[i] = buf.Bytes()
+	}
+	return files
+}
+
+// bcjFolder creates a folder using the x86 converter and LZMA2.
+func bcjFolder(t *testing.T, files [][]byte) testFolder {
+	data := bytes.Join(files, nil)
+	var buf bytes.Buffer
+	lw, err := lzma.Writer2Config{DictCap: 1 << 16}.NewWriter2(&buf)
+	if err != nil {
+		t.Fatalf("NewWriter2 error %s", err)
+	}
+	w := bcj.NewWriter(lw, bcj.NewX86(true), 0)
+	if _, err = w.Write(data); err != nil {
+		t.Fatalf("Write error %s", err)
+	}
+	if err = w.Close(); err != nil {
+		t.Fatalf("Close error %s", err)
+	}
+	return testFolder{
+		coders: []testCoder{
+			{id: []byte{3, 3, 1, 3}},
+			{id: []byte{0x21}, props: []byte{lzma.EncodeDictCap(1 << 16)}},
+		},
+		packed: buf.Bytes(),
+		sizes:  []uint64{uint64(len(data)), uint64(len(data))},
+		files:  files,
+	}
+}
+
+// deltaFolder creates a folder using the delta filter and LZMA.
+func deltaFolder(t *testing.T, files [][]byte) testFolder {
+	const dist = 4
+	data := bytes.Join(files, nil)
+	enc := make([]byte, len(data))
+	for i, b := range data {
+		enc[i] = b
+		if i >= dist {
+			enc[i] -= data[i-dist]
+		}
+	}
+	props := lzma.Properties{LC: 3, LP: 0, PB: 2}
+	var buf bytes.Buffer
+	cfg := lzma.WriterConfig{Properties: &props, DictCap: 1 << 16,
+		Size: int64(len(enc))}
+	lw, err := cfg.NewRawWriter(&buf)
+	if err != nil {
+		t.Fatalf("NewRawWriter error %s", err)
+	}
+	if _, err = lw.Write(enc); err != nil {
+		t.Fatalf("Write error %s", err)
+	}
+	if err = lw.Close(); err != nil {
+		t.Fatalf("Close error %s", err)
+	}
+	lprops := []byte{props.Code(), 0, 0, 0, 0}
+	binary.LittleEndian.PutUint32(lprops[1:], 1<<16)
+	return testFolder{
+		coders: []testCoder{
+			{id: []byte{3}, props: []byte{dist - 1}},
+			{id: []byte{3, 1, 1}, props: lprops},
+		},
+		packed: buf.Bytes(),
+		sizes:  []uint64{uint64(len(data)), uint64(len(data))},
+		files:  files,
+	}
+}
+
+func TestReaderFolders(t *testing.T) {
+	f1 := randomFiles(1, 3)
+	f2 := randomFiles(2, 2)
+	data := buildArchive(
+		[]testFolder{bcjFolder(t, f1), deltaFolder(t, f2)},
+		[]string{"a", "b", "c", "d", "e"})
+	files := append(append([][]byte{}, f1...), f2...)
+	z, err := NewReader(bytes.NewReader(data), int64(len(data)))
+	if err != nil {
+		t.Fatalf("NewReader error %s", err)
+	}
+	if len(z.File) != len(files) {
+		t.Fatalf("got %d files; want %d", len(z.File), len(files))
+	}
+	// The order tests the reuse of the folder decoder and the
+	// decoding from the start.
+	for _, i := range []int{0, 1, 2, 4, 3, 1, 2} {
+		f := z.File[i]
+		r, err := f.Open()
+		if err != nil {
+			t.Fatalf("%s: Open error %s", f.Name, err)
+		}
+		got, err := ioutil.ReadAll(r)
+		if err != nil {
+			t.Fatalf("%s: ReadAll error %s", f.Name, err)
+		}
+		r.Close()
+		if !bytes.Equal(got, files[i]) {
+			t.Fatalf("%s: content differs", f.Name)
+		}
+	}
+}
+
+func TestReaderMemLimit(t *testing.T) {
+	files := randomFiles(4, 2)
+	data := buildArchive([]testFolder{bcjFolder(t, files)},
+		[]string{"a", "b"})
+	cfg := ReaderConfig{MemLimit: 1 << 20}
+	z, err := cfg.NewReader(bytes.NewReader(data), int64(len(data)))
+	if err != nil {
+		t.Fatalf("NewReader error %s", err)
+	}
+	r, err := z.File[1].Open()
+	if err != nil {
+		t.Fatalf("Open error %s", err)
+	}
+	got, err := ioutil.ReadAll(r)
+	if err != nil {
+		t.Fatalf("ReadAll error %s", err)
+	}
+	if !bytes.Equal(got, files[1]) {
+		t.Fatalf("content differs")
+	}
+
+	folders := []testFolder{bcjFolder(t, files), deltaFolder(t, files)}
+	// Both folders claim a dictionary capacity of 1 GiB.
+	folders[0].coders[1].props = []byte{lzma.EncodeDictCap(1 << 30)}
+	lprops := append([]byte{}, folders[1].coders[1].props...)
+	binary.LittleEndian.PutUint32(lprops[1:], 1<<30)
+	folders[1].coders[1].props = lprops
+	for i, folder := range folders {
+		data := buildArchive([]testFolder{folder}, []string{"a", "b"})
+		z, err := cfg.NewReader(bytes.NewReader(data),
+			int64(len(data)))
+		if err != nil {
+			t.Fatalf("NewReader error %s", err)
+		}
+		_, err = z.File[0].Open()
+		if _, ok := err.(*lzma.MemLimitError); !ok {
+			t.Errorf("folder %d: Open returned error %v; "+
+				"want *lzma.MemLimitError", i, err)
+		}
+	}
+}
+
+func TestReaderChecksum(t *testing.T) {
+	files := randomFiles(3, 2)
+	folder := bcjFolder(t, files)
+	folder.files = [][]byte{files[0], append([]byte{}, files[1]...)}
+	folder.files[1][0] ^= 1
+	data := buildArchive([]testFolder{folder}, []string{"a", "b"})
+	z, err := NewReader(bytes.NewReader(data), int64(len(data)))
+	if err != nil {
+		t.Fatalf("NewReader error %s", err)
+	}
+	r, err := z.File[1].Open()
+	if err != nil {
+		t.Fatalf("Open error %s", err)
+	}
+	if _, err = ioutil.ReadAll(r); err != errChecksum {
+		t.Fatalf("ReadAll returned error %v; want %v", err,
+			errChecksum)
+	}
+}
+
+func TestReaderCorrupt(t *testing.T) {
+	data, err := ioutil.ReadFile("testdata/lzma2.7z")
+	if err != nil {
+		t.Fatalf("ReadFile error %s", err)
+	}
+	for i := range data {
+		p := append([]byte{}, data...)
+		p[i] ^= 0x55
+		z, err := NewReader(bytes.NewReader(p), int64(len(p)))
+		if err != nil {
+			continue
+		}
+		// Errors are fine, but no panics.
+		for _, f := range z.File {
+			r, err := f.Open()
+			if err != nil {
+				continue
+			}
+			ioutil.ReadAll(r)
+			r.Close()
+		}
+	}
+}
+
+func TestFileHeaderMode(t *testing.T) {
+	tests := []struct {
+		attr uint32
+		mode os.FileMode
+	}{
+		{0x20, 0666},
+		{0x21, 0444},
+		{attrDirectory, os.ModeDir | 0777},
+		{attrUnixExtension | 0100755<<16, 0755},
+		{attrUnixExtension | 0120777<<16, os.ModeSymlink | 0777},
+		{attrDirectory | attrUnixExtension | 040750<<16,
+			os.ModeDir | 0750},
+	}
+	for _, tc := range tests {
+		h := FileHeader{Attributes: tc.attr}
+		if m := h.Mode(); m != tc.mode {
+			t.Errorf("attributes %#x: mode %s; want %s",
+				tc.attr, m, tc.mode)
+		}
+	}
+}
@@ -5,7 +5,9 @@
 package xz
 
 import (
+	"bytes"
 	"errors"
+	"fmt"
 	"hash"
 	"io"
 
@@ -18,12 +20,106 @@ type WriterConfig struct {
 	DictCap    int
 	BufSize    int
 	BlockSize  int64
-	// checksum method: CRC32, CRC64 or SHA256
+	// checksum method: CRC32, CRC64 or SHA256; the zero value selects
+	// CRC64 unless NoCheckSum is set. The value None is zero too and
+	// doesn't disable the check.
 	CheckSum byte
+	// NoCheckSum selects the check type None. This is the only way to
+	// write blocks without a check field.
+	NoCheckSum bool
 	// match algorithm
 	Matcher lzma.MatchAlgorithm
+	// Mode, NiceLen and Depth tune the LZMA2 encoder; see
+	// lzma.Writer2Config
+	Mode    lzma.Mode
+	NiceLen int
+	Depth   int
+	// Workers defines the number of goroutines compressing blocks in
+	// parallel. The values 0 and 1 select the single-threaded writer.
+	// Blocks written by multiple workers contain the compressed and
+	// uncompressed sizes in their headers.
+	Workers int
+	// Filters describes the filter chain written into every block
+	// header. The last filter must be the LZMA2 filter and the chain
+	// may contain at most four filters. A nil value selects the LZMA2
+	// filter with the dictionary capacity DictCap.
+	Filters []FilterConfig
 }
 
+// FilterLZMA2 is the filter ID of the LZMA2 filter.
+const FilterLZMA2 uint64 = lzmaFilterID
+
+// FilterConfig describes a single filter of a filter chain. The ID
+// selects the filter; only the fields relevant for the filter are used.
+type FilterConfig struct {
+	// ID of the filter: FilterLZMA2, FilterDelta or one of the BCJ
+	// filter IDs like FilterX86
+	ID uint64
+	// dictionary capacity of the LZMA2 filter; the value zero
+	// selects the DictCap field of the WriterConfig
+	DictCap int
+	// distance for the delta filter in the range 1 to 256
+	Dist int
+	// start offset for the BCJ filters; it must be a multiple of the
+	// instruction alignment of the architecture
+	StartOffset uint32
+}
+
+// filter converts the filter configuration into a filter. The argument
+// dictCap provides the default dictionary capacity for LZMA2.
+func (fc FilterConfig) filter(dictCap int) (f filter, err error) {
+	switch {
+	case fc.ID == FilterLZMA2:
+		if fc.DictCap != 0 {
+			dictCap = fc.DictCap
+		}
+		if !(lzma.MinDictCap <= dictCap &&
+			int64(dictCap) <= lzma.MaxDictCap) {
+			return nil, errors.New(
+				"xz: LZMA2 dictionary capacity out of range")
+		}
+		return &lzmaFilter{int64(dictCap)}, nil
+	case fc.ID == FilterDelta:
+		if !(minDeltaDist <= fc.Dist && fc.Dist <= maxDeltaDist) {
+			return nil, errors.New("xz: delta distance out of range")
+		}
+		return &deltaFilter{dist: fc.Dist}, nil
+	case isBCJFilter(fc.ID):
+		if fc.StartOffset%bcjArchs[fc.ID].alignment != 0 {
+			return nil, errors.New(
+				"xz: BCJ start offset not aligned")
+		}
+		return &bcjFilter{filterID: fc.ID, start: fc.StartOffset}, nil
+	}
+	return nil, fmt.Errorf("xz: filter id %#x not supported", fc.ID)
+}
+
+// WriterConfigForPreset returns the writer configuration for the preset
+// level in the range lzma.MinPreset to lzma.MaxPreset. The extreme flag
+// selects the slower variant of the preset. The configuration matches
+// the presets of the xz tool.
+func WriterConfigForPreset(level int, extreme bool) (c WriterConfig,
+	err error) {
+
+	lc, err := lzma.Writer2ConfigForPreset(level, extreme)
+	if err != nil {
+		return c, err
+	}
+	c = WriterConfig{
+		Properties: lc.Properties,
+		DictCap:    lc.DictCap,
+		Matcher:    lc.Matcher,
+		Mode:       lc.Mode,
+		NiceLen:    lc.NiceLen,
+		Depth:      lc.Depth,
+	}
+	return c, nil
+}
+
+// minParallelBlockSize is the smallest default block size used by the
+// parallel writer.
+const minParallelBlockSize = 1 << 20
+
 // fill replaces zero values with default values.
 func (c *WriterConfig) fill() {
 	if c.Properties == nil {
@@ -35,10 +131,23 @@ func (c *WriterConfig) fill() {
 	if c.BufSize == 0 {
 		c.BufSize = 4096
 	}
+	if c.Workers == 0 {
+		c.Workers = 1
+	}
 	if c.BlockSize == 0 {
-		c.BlockSize = maxInt64
+		if c.Workers > 1 {
+			// The xz tool uses three times the dictionary
+			// capacity as block size for multithreaded
+			// compression.
+			c.BlockSize = 3 * int64(c.DictCap)
+			if c.BlockSize < minParallelBlockSize {
+				c.BlockSize = minParallelBlockSize
+			}
+		} else {
+			c.BlockSize = maxInt64
+		}
 	}
-	if c.CheckSum == 0 {
+	if c.CheckSum == 0 && !c.NoCheckSum {
 		c.CheckSum = CRC64
 	}
 }
@@ -55,6 +164,9 @@ func (c *WriterConfig) Verify() error {
 		DictCap:    c.DictCap,
 		BufSize:    c.BufSize,
 		Matcher:    c.Matcher,
+		Mode:       c.Mode,
+		NiceLen:    c.NiceLen,
+		Depth:      c.Depth,
 	}
 	if err := lc.Verify(); err != nil {
 		return err
@@ -62,14 +174,48 @@ func (c *WriterConfig) Verify() error {
 	if c.BlockSize <= 0 {
 		return errors.New("xz: block size out of range")
 	}
-	if err := verifyFlags(c.CheckSum); err != nil {
+	if c.Workers < 1 {
+		return errors.New("xz: number of workers out of range")
+	}
+	if c.Workers > 1 && int64(int(c.BlockSize)) != c.BlockSize {
+		return errors.New(
+			"xz: block size too large for parallel compression")
+	}
+	if c.NoCheckSum && c.CheckSum != None {
+		return errors.New("xz: NoCheckSum set together with CheckSum")
+	}
+	if _, err := newHashFunc(c.CheckSum); err != nil {
 		return err
 	}
+	if c.Filters != nil {
+		f := make([]filter, 0, len(c.Filters))
+		for _, fc := range c.Filters {
+			g, err := fc.filter(c.DictCap)
+			if err != nil {
+				return err
+			}
+			f = append(f, g)
+		}
+		if err := verifyFilters(f); err != nil {
+			return err
+		}
+	}
 	return nil
 }
 
-// filters creates the filter list for the given parameters.
+// filters creates the filter list for the given parameters. The
+// configuration must have been verified.
 func (c *WriterConfig) filters() []filter {
+	if c.Filters != nil {
+		f := make([]filter, len(c.Filters))
+		for i, fc := range c.Filters {
+			var err error
+			if f[i], err = fc.filter(c.DictCap); err != nil {
+				panic(err)
+			}
+		}
+		return f
+	}
 	return []filter{&lzmaFilter{int64(c.DictCap)}}
 }
 
@@ -97,19 +243,47 @@ func verifyFilters(f []filter) error {
 }
 
 // newFilterWriteCloser converts a filter list into a WriteCloser that
-// can be used by a blockWriter.
-func (c *WriterConfig) newFilterWriteCloser(w io.Writer, f []filter) (fw io.WriteCloser, err error) {
+// can be used by a blockWriter. The LZMA2 writer lw is reset and reused
+// if it is not nil. The LZMA2 writer of the chain is returned as lzma2.
+func (c *WriterConfig) newFilterWriteCloser(w io.Writer, f []filter,
+	lw *lzma.Writer2) (fw io.WriteCloser, lzma2 *lzma.Writer2, err error) {
+
 	if err = verifyFilters(f); err != nil {
-		return nil, err
+		return nil, nil, err
 	}
 	fw = nopWriteCloser(w)
-	for i := len(f) - 1; i >= 0; i-- {
+	i := len(f) - 1
+	if lw != nil {
+		if err = lw.Reset(fw); err != nil {
+			return nil, nil, err
+		}
+		fw, lzma2 = lw, lw
+		i--
+	}
+	for ; i >= 0; i-- {
 		fw, err = f[i].writeCloser(fw, c)
 		if err != nil {
-			return nil, err
+			return nil, nil, err
 		}
+		if lzma2 == nil {
+			lzma2, _ = fw.(*lzma.Writer2)
+		}
+	}
+	return fw, lzma2, nil
+}
+
+// flusher is implemented by the writers of a filter chain that support
+// flushing.
+type flusher interface {
+	Flush() error
+}
+
+// flushWriter calls the Flush method of w if it has one.
+func flushWriter(w io.Writer) error {
+	if f, ok := w.(flusher); ok {
+		return f.Flush()
 	}
-	return fw, nil
+	return nil
 }
 
 // nopWCloser implements a WriteCloser with a Close method not doing
@@ -139,12 +313,27 @@ type Writer struct {
 	h       header
 	index   []record
 	closed  bool
+
+	// fields used by the parallel writer
+	buf  []byte
+	jobs []*blockJob
+	free [][]byte
 }
 
-// newBlockWriter creates a new block writer writes the header out.
+// newBlockWriter creates a new block writer writes the header out. The
+// LZMA2 writer and the hash of the previous block writer are reused.
 func (w *Writer) newBlockWriter() error {
+	var lw *lzma.Writer2
+	var h hash.Hash
+	if w.bw != nil {
+		lw = w.bw.lzma2
+		h = w.bw.hash
+		h.Reset()
+	} else {
+		h = w.newHash()
+	}
 	var err error
-	w.bw, err = w.WriterConfig.newBlockWriter(w.xz, w.newHash())
+	w.bw, err = w.WriterConfig.newBlockWriter(w.xz, h, lw)
 	if err != nil {
 		return err
 	}
@@ -184,15 +373,41 @@ func (c WriterConfig) NewWriter(xz io.Writer) (w *Writer, err error) {
 	if w.newHash, err = newHashFunc(c.CheckSum); err != nil {
 		return nil, err
 	}
-	data, err := w.h.MarshalBinary()
-	if _, err = xz.Write(data); err != nil {
-		return nil, err
+	if w.Workers > 1 {
+		w.buf = make([]byte, 0, int(w.BlockSize))
+		w.jobs = make([]*blockJob, 0, w.Workers)
 	}
-	if err = w.newBlockWriter(); err != nil {
+	if err = w.Reset(xz); err != nil {
 		return nil, err
 	}
 	return w, nil
+}
 
+// Reset discards the state of the writer and starts a new xz stream
+// written to xz. The stream header is written immediately. The LZMA2
+// writer and the buffers are reused, so Reset avoids the allocations of
+// a new writer.
+func (w *Writer) Reset(xz io.Writer) error {
+	for _, job := range w.jobs {
+		<-job.done
+		w.free = append(w.free, job.data[:0])
+	}
+	w.jobs = w.jobs[:0]
+	w.buf = w.buf[:0]
+	w.xz = xz
+	w.index = w.index[:0]
+	w.closed = false
+	data, err := w.h.MarshalBinary()
+	if err != nil {
+		return err
+	}
+	if _, err = xz.Write(data); err != nil {
+		return err
+	}
+	if w.Workers > 1 {
+		return nil
+	}
+	return w.newBlockWriter()
 }
 
 // Write compresses the uncompressed data provided.
@@ -200,6 +415,9 @@ func (w *Writer) Write(p []byte) (n int, err error) {
 	if w.closed {
 		return 0, errClosed
 	}
+	if w.Workers > 1 {
+		return w.writeParallel(p)
+	}
 	for {
 		k, err := w.bw.Write(p[n:])
 		n += k
@@ -215,6 +433,44 @@ func (w *Writer) Write(p []byte) (n int, err error) {
 	}
 }
 
+// Flush compresses all data written so far and writes it to the
+// underlying writer, so that a reader can decode it. The current block
+// is continued. A BCJ filter keeps the bytes of an incomplete
+// instruction until more data is written. The parallel writer can't
+// flush a block that is compressed by a worker, so it ends the current
+// block as FlushBlock does.
+//
+// Frequent flushing reduces the compression ratio.
+func (w *Writer) Flush() error {
+	if w.closed {
+		return errClosed
+	}
+	if w.Workers > 1 {
+		return w.flushJobs()
+	}
+	return w.bw.Flush()
+}
+
+// FlushBlock ends the current block and writes all data written so far
+// to the underlying writer. The next block will be started
+// immediately. FlushBlock does nothing if no data has been written into
+// the current block.
+func (w *Writer) FlushBlock() error {
+	if w.closed {
+		return errClosed
+	}
+	if w.Workers > 1 {
+		return w.flushJobs()
+	}
+	if w.bw.uncompressedSize() == 0 {
+		return nil
+	}
+	if err := w.closeBlockWriter(); err != nil {
+		return err
+	}
+	return w.newBlockWriter()
+}
+
 // Close closes the writer and adds the footer to the Writer. Close
 // doesn't close the underlying writer.
 func (w *Writer) Close() error {
@@ -223,7 +479,12 @@ func (w *Writer) Close() error {
 	}
 	w.closed = true
 	var err error
-	if err = w.closeBlockWriter(); err != nil {
+	if w.Workers > 1 {
+		err = w.flushJobs()
+	} else {
+		err = w.closeBlockWriter()
+	}
+	if err != nil {
 		return err
 	}
 
@@ -270,17 +531,22 @@ type blockWriter struct {
 
 	filters []filter
 	hash    hash.Hash
+	// LZMA2 writer of the filter chain
+	lzma2 *lzma.Writer2
 }
 
-// newBlockWriter creates a new block writer.
-func (c *WriterConfig) newBlockWriter(xz io.Writer, hash hash.Hash) (bw *blockWriter, err error) {
+// newBlockWriter creates a new block writer. The LZMA2 writer lw of a
+// previous block writer is reused if it is not nil.
+func (c *WriterConfig) newBlockWriter(xz io.Writer, hash hash.Hash,
+	lw *lzma.Writer2) (bw *blockWriter, err error) {
+
 	bw = &blockWriter{
 		cxz:       countingWriter{w: xz},
 		blockSize: c.BlockSize,
 		filters:   c.filters(),
 		hash:      hash,
 	}
-	bw.w, err = c.newFilterWriteCloser(&bw.cxz, bw.filters)
+	bw.w, bw.lzma2, err = c.newFilterWriteCloser(&bw.cxz, bw.filters, lw)
 	if err != nil {
 		return nil, err
 	}
@@ -341,6 +607,121 @@ func (bw *blockWriter) record() record {
 	return record{bw.unpaddedSize(), bw.uncompressedSize()}
 }
 
+// blockJob holds a block that is compressed by its own goroutine. The
+// channel done is closed after the compression has been finished.
+type blockJob struct {
+	data   []byte
+	header []byte
+	buf    bytes.Buffer
+	rec    record
+	err    error
+	done   chan struct{}
+}
+
+// compressBlock compresses the data of the job into a single block. The
+// block header will contain the compressed and uncompressed sizes.
+func (c *WriterConfig) compressBlock(job *blockJob, hash hash.Hash) {
+	defer close(job.done)
+	bw, err := c.newBlockWriter(&job.buf, hash, nil)
+	if err != nil {
+		job.err = err
+		return
+	}
+	if _, err = bw.Write(job.data); err != nil {
+		job.err = err
+		return
+	}
+	if err = bw.Close(); err != nil {
+		job.err = err
+		return
+	}
+	var h bytes.Buffer
+	if err = bw.writeHeader(&h); err != nil {
+		job.err = err
+		return
+	}
+	job.header = h.Bytes()
+	job.rec = bw.record()
+}
+
+// startJob starts the compression of the buffered data in a separate
+// goroutine. If all workers are busy the oldest block will be written
+// first.
+func (w *Writer) startJob() error {
+	if len(w.jobs) >= w.Workers {
+		if err := w.writeJob(); err != nil {
+			return err
+		}
+	}
+	job := &blockJob{data: w.buf, done: make(chan struct{})}
+	if k := len(w.free); k > 0 {
+		w.buf = w.free[k-1]
+		w.free = w.free[:k-1]
+	} else {
+		w.buf = make([]byte, 0, int(w.BlockSize))
+	}
+	w.jobs = append(w.jobs, job)
+	go w.WriterConfig.compressBlock(job, w.newHash())
+	return nil
+}
+
+// writeJob waits for the oldest job to complete and writes the block
+// to the underlying writer.
+func (w *Writer) writeJob() error {
+	job := w.jobs[0]
+	<-job.done
+	copy(w.jobs, w.jobs[1:])
+	w.jobs = w.jobs[:len(w.jobs)-1]
+	if job.err != nil {
+		return job.err
+	}
+	if _, err := w.xz.Write(job.header); err != nil {
+		return err
+	}
+	if _, err := job.buf.WriteTo(w.xz); err != nil {
+		return err
+	}
+	w.index = append(w.index, job.rec)
+	w.free = append(w.free, job.data[:0])
+	return nil
+}
+
+// flushJobs compresses the buffered data and writes all blocks
+// pending.
+func (w *Writer) flushJobs() error {
+	if len(w.buf) > 0 {
+		if err := w.startJob(); err != nil {
+			return err
+		}
+	}
+	for len(w.jobs) > 0 {
+		if err := w.writeJob(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// writeParallel buffers the data and starts the compression of a block
+// as soon as BlockSize bytes are buffered.
+func (w *Writer) writeParallel(p []byte) (n int, err error) {
+	for len(p) > 0 {
+		k := int(w.BlockSize) - len(w.buf)
+		if k > len(p) {
+			k = len(p)
+		}
+		w.buf = append(w.buf, p[:k]...)
+		n += k
+		p = p[k:]
+		if len(w.buf) == int(w.BlockSize) {
+			if err = w.startJob(); err != nil {
+				return n, err
+			}
+		}
+	}
+	return n, nil
+}
+
 var errClosed = errors.New("xz: writer already closed")
 
 var errNoSpace = errors.New("xz: no space")
@@ -366,6 +747,15 @@ func (bw *blockWriter) Write(p []byte) (n int, err error) {
 	return n, err
 }
 
+// Flush flushes the filter chain, so that all data written to the block
+// writer so far can be decoded.
+func (bw *blockWriter) Flush() error {
+	if bw.closed {
+		return errClosed
+	}
+	return flushWriter(bw.w)
+}
+
 // Close closes the writer.
 func (bw *blockWriter) Close() error {
 	if bw.closed {
@@ -7,12 +7,14 @@ package xz
 import (
 	"bytes"
 	"io"
+	"io/ioutil"
 	"log"
 	"math/rand"
 	"os"
 	"testing"
 
 	"github.com/ulikunitz/xz/internal/randtxt"
+	"github.com/ulikunitz/xz/lzma"
 )
 
 func TestWriter(t *testing.T) {
@@ -136,3 +138,453 @@ func TestWriter2(t *testing.T) {
 		t.Fatal("decompressed data differs from original")
 	}
 }
+
+func TestWriterWorkers(t *testing.T) {
+	const txtlen = 100000
+	var buf bytes.Buffer
+	io.CopyN(&buf, randtxt.NewReader(rand.NewSource(43)), txtlen)
+	txt := buf.String()
+
+	var outputs [][]byte
+	for _, workers := range []int{2, 5} {
+		buf.Reset()
+		cfg := WriterConfig{
+			DictCap:   1 << 12,
+			BlockSize: 1 << 13,
+			Workers:   workers,
+		}
+		w, err := cfg.NewWriter(&buf)
+		if err != nil {
+			t.Fatalf("NewWriter error %s", err)
+		}
+		if _, err = io.WriteString(w, txt); err != nil {
+			t.Fatalf("WriteString error %s", err)
+		}
+		if err = w.Close(); err != nil {
+			t.Fatalf("w.Close error %s", err)
+		}
+		if len(w.index) != (txtlen+1<<13-1)/(1<<13) {
+			t.Fatalf("workers %d: got %d blocks; want %d", workers,
+				len(w.index), (txtlen+1<<13-1)/(1<<13))
+		}
+		outputs = append(outputs, append([]byte(nil), buf.Bytes()...))
+		r, err := NewReader(&buf)
+		if err != nil {
+			t.Fatalf("NewReader error %s", err)
+		}
+		var out bytes.Buffer
+		if _, err = io.Copy(&out, r); err != nil {
+			t.Fatalf("io.Copy error %s", err)
+		}
+		if out.String() != txt {
+			t.Fatalf("workers %d: decompressed data differs from "+
+				"original", workers)
+		}
+	}
+	if !bytes.Equal(outputs[0], outputs[1]) {
+		t.Fatal("output depends on the number of workers")
+	}
+}
+
+func TestWriterBCJ(t *testing.T) {
+	const txtlen = 20000
+	var buf bytes.Buffer
+	io.CopyN(&buf, randtxt.NewReader(rand.NewSource(46)), txtlen)
+	txt := buf.String()
+
+	for id := range bcjArchs {
+		buf.Reset()
+		cfg := WriterConfig{Filters: []FilterConfig{{ID: id},
+			{ID: FilterLZMA2}}}
+		w, err := cfg.NewWriter(&buf)
+		if err != nil {
+			t.Fatalf("NewWriter error %s", err)
+		}
+		if _, err = io.WriteString(w, txt); err != nil {
+			t.Fatalf("WriteString error %s", err)
+		}
+		if err = w.Close(); err != nil {
+			t.Fatalf("w.Close error %s", err)
+		}
+		r, err := NewReader(&buf)
+		if err != nil {
+			t.Fatalf("NewReader error %s", err)
+		}
+		var out bytes.Buffer
+		if _, err = io.Copy(&out, r); err != nil {
+			t.Fatalf("filter %#x: io.Copy error %s", id, err)
+		}
+		if out.String() != txt {
+			t.Fatalf("filter %#x: decompressed data differs from "+
+				"original", id)
+		}
+	}
+	cfg := WriterConfig{Filters: []FilterConfig{{ID: FilterLZMA2},
+		{ID: FilterLZMA2}}}
+	if _, err := cfg.NewWriter(&buf); err == nil {
+		t.Fatal("NewWriter accepted LZMA2 as BCJ filter")
+	}
+}
+
+func TestWriterDelta(t *testing.T) {
+	// 16-bit stereo samples of two slowly changing signals
+	data := make([]byte, 40000)
+	for i := 0; i+4 <= len(data); i += 4 {
+		putUint32LE(data[i:], uint32(i/4)<<16|uint32(i/8))
+	}
+
+	var buf bytes.Buffer
+	cfg := WriterConfig{Filters: []FilterConfig{
+		{ID: FilterDelta, Dist: 4},
+		{ID: FilterX86},
+		{ID: FilterLZMA2},
+	}}
+	w, err := cfg.NewWriter(&buf)
+	if err != nil {
+		t.Fatalf("NewWriter error %s", err)
+	}
+	if _, err = w.Write(data); err != nil {
+		t.Fatalf("w.Write error %s", err)
+	}
+	if err = w.Close(); err != nil {
+		t.Fatalf("w.Close error %s", err)
+	}
+	r, err := NewReader(&buf)
+	if err != nil {
+		t.Fatalf("NewReader error %s", err)
+	}
+	out, err := ioutil.ReadAll(r)
+	if err != nil {
+		t.Fatalf("ReadAll error %s", err)
+	}
+	if !bytes.Equal(out, data) {
+		t.Fatal("decompressed data differs from original")
+	}
+
+	for _, dist := range []int{-1, 257} {
+		cfg = WriterConfig{Filters: []FilterConfig{
+			{ID: FilterDelta, Dist: dist},
+			{ID: FilterLZMA2},
+		}}
+		if err = cfg.Verify(); err == nil {
+			t.Errorf("Verify accepted delta distance %d", dist)
+		}
+	}
+}
+
+func TestWriterFilters(t *testing.T) {
+	const txtlen = 20000
+	var buf bytes.Buffer
+	io.CopyN(&buf, randtxt.NewReader(rand.NewSource(47)), txtlen)
+	txt := buf.String()
+
+	buf.Reset()
+	cfg := WriterConfig{
+		Filters: []FilterConfig{
+			{ID: FilterDelta, Dist: 2},
+			{ID: FilterARM64, StartOffset: 4096},
+			{ID: FilterLZMA2, DictCap: 1 << 16},
+		},
+	}
+	w, err := cfg.NewWriter(&buf)
+	if err != nil {
+		t.Fatalf("NewWriter error %s", err)
+	}
+	if _, err = io.WriteString(w, txt); err != nil {
+		t.Fatalf("WriteString error %s", err)
+	}
+	if err = w.Close(); err != nil {
+		t.Fatalf("w.Close error %s", err)
+	}
+	h, _, err := readBlockHeader(bytes.NewReader(buf.Bytes()[HeaderLen:]))
+	if err != nil {
+		t.Fatalf("readBlockHeader error %s", err)
+	}
+	if len(h.filters) != 3 {
+		t.Fatalf("got %d filters; want 3", len(h.filters))
+	}
+	if f := h.filters[1].(*bcjFilter); f.start != 4096 {
+		t.Errorf("got BCJ start offset %d; want 4096", f.start)
+	}
+	if f := h.filters[2].(*lzmaFilter); f.dictCap != 1<<16 {
+		t.Errorf("got dictCap %d; want %d", f.dictCap, 1<<16)
+	}
+	r, err := NewReader(&buf)
+	if err != nil {
+		t.Fatalf("NewReader error %s", err)
+	}
+	out, err := ioutil.ReadAll(r)
+	if err != nil {
+		t.Fatalf("ReadAll error %s", err)
+	}
+	if string(out) != txt {
+		t.Fatal("decompressed data differs from original")
+	}
+
+	invalid := []WriterConfig{
+		{Filters: []FilterConfig{}},
+		{Filters: []FilterConfig{{ID: FilterX86}}},
+		{Filters: []FilterConfig{{ID: FilterLZMA2},
+			{ID: FilterX86}}},
+		{Filters: []FilterConfig{{ID: FilterX86},
+			{ID: FilterX86}, {ID: FilterX86}, {ID: FilterX86},
+			{ID: FilterLZMA2}}},
+		{Filters: []FilterConfig{{ID: FilterDelta},
+			{ID: FilterLZMA2}}},
+		{Filters: []FilterConfig{{ID: FilterARM, StartOffset: 2},
+			{ID: FilterLZMA2}}},
+		{Filters: []FilterConfig{{ID: 0x40}, {ID: FilterLZMA2}}},
+	}
+	for i, c := range invalid {
+		if err = c.Verify(); err == nil {
+			t.Errorf("invalid configuration %d accepted", i)
+		}
+	}
+}
+
+func TestWriterEncoderParams(t *testing.T) {
+	const txtlen = 20000
+	var buf bytes.Buffer
+	io.CopyN(&buf, randtxt.NewReader(rand.NewSource(47)), txtlen)
+	txt := buf.String()
+
+	buf.Reset()
+	cfg := WriterConfig{Matcher: lzma.BT4, Mode: lzma.ModeNormal,
+		NiceLen: 32, Depth: 8}
+	w, err := cfg.NewWriter(&buf)
+	if err != nil {
+		t.Fatalf("NewWriter error %s", err)
+	}
+	if _, err = io.WriteString(w, txt); err != nil {
+		t.Fatalf("WriteString error %s", err)
+	}
+	if err = w.Close(); err != nil {
+		t.Fatalf("w.Close error %s", err)
+	}
+	r, err := NewReader(&buf)
+	if err != nil {
+		t.Fatalf("NewReader error %s", err)
+	}
+	var out bytes.Buffer
+	if _, err = io.Copy(&out, r); err != nil {
+		t.Fatalf("io.Copy error %s", err)
+	}
+	if out.String() != txt {
+		t.Fatal("decompressed data differs from original")
+	}
+
+	for _, c := range []WriterConfig{
+		{NiceLen: 1},
+		{NiceLen: 274},
+		{Depth: -1},
+		{Depth: lzma.MaxDepth + 1},
+		{Mode: 7},
+	} {
+		if err = c.Verify(); err == nil {
+			t.Errorf("Verify accepted %+v", c)
+		}
+	}
+}
+
+func TestWriterConfigForPreset(t *testing.T) {
+	const text = "The quick brown fox jumps over the lazy dog."
+	cfg, err := WriterConfigForPreset(1, true)
+	if err != nil {
+		t.Fatalf("WriterConfigForPreset error %s", err)
+	}
+	if cfg.DictCap != 1<<20 || cfg.Mode != lzma.ModeNormal {
+		t.Fatalf("unexpected configuration %+v", cfg)
+	}
+	var buf bytes.Buffer
+	w, err := cfg.NewWriter(&buf)
+	if err != nil {
+		t.Fatalf("NewWriter error %s", err)
+	}
+	if _, err = io.WriteString(w, text); err != nil {
+		t.Fatalf("WriteString error %s", err)
+	}
+	if err = w.Close(); err != nil {
+		t.Fatalf("w.Close error %s", err)
+	}
+	r, err := NewReader(&buf)
+	if err != nil {
+		t.Fatalf("NewReader error %s", err)
+	}
+	out, err := ioutil.ReadAll(r)
+	if err != nil {
+		t.Fatalf("ReadAll error %s", err)
+	}
+	if string(out) != text {
+		t.Fatalf("got %q; want %q", out, text)
+	}
+	if _, err = WriterConfigForPreset(10, false); err == nil {
+		t.Fatal("preset 10 accepted")
+	}
+}
+
+func TestWriterReset(t *testing.T) {
+	var buf bytes.Buffer
+	io.CopyN(&buf, randtxt.NewReader(rand.NewSource(29)), 30000)
+	txt := buf.Bytes()
+	for _, workers := range []int{1, 2} {
+		cfg := WriterConfig{DictCap: 1 << 14, BlockSize: 10000,
+			Workers: workers}
+		var want bytes.Buffer
+		w, err := cfg.NewWriter(&want)
+		if err != nil {
+			t.Fatalf("NewWriter error %s", err)
+		}
+		if _, err = w.Write(txt); err != nil {
+			t.Fatalf("w.Write error %s", err)
+		}
+		if err = w.Close(); err != nil {
+			t.Fatalf("w.Close error %s", err)
+		}
+		var got bytes.Buffer
+		for i := 0; i < 2; i++ {
+			got.Reset()
+			if err = w.Reset(&got); err != nil {
+				t.Fatalf("w.Reset error %s", err)
+			}
+			if _, err = w.Write(txt); err != nil {
+				t.Fatalf("w.Write error %s", err)
+			}
+			if i == 0 {
+				// Reset the writer without closing it.
+				continue
+			}
+			if err = w.Close(); err != nil {
+				t.Fatalf("w.Close error %s", err)
+			}
+		}
+		if !bytes.Equal(got.Bytes(), want.Bytes()) {
+			t.Fatalf("workers %d: output after Reset differs",
+				workers)
+		}
+
+		r, err := NewReader(bytes.NewReader(want.Bytes()))
+		if err != nil {
+			t.Fatalf("NewReader error %s", err)
+		}
+		for i := 0; i < 2; i++ {
+			if i > 0 {
+				err = r.Reset(bytes.NewReader(got.Bytes()))
+				if err != nil {
+					t.Fatalf("r.Reset error %s", err)
+				}
+			}
+			out, err := ioutil.ReadAll(r)
+			if err != nil {
+				t.Fatalf("ReadAll error %s", err)
+			}
+			if !bytes.Equal(out, txt) {
+				t.Fatalf("workers %d: decompressed data differs",
+					workers)
+			}
+		}
+	}
+}
+
+func TestWriterFlush(t *testing.T) {
+	// Data from a small alphabet produces long matches, which test
+	// the match finders at the flushes.
+	rnd := rand.New(rand.NewSource(31))
+	txt := make([]byte, 60000)
+	for i := range txt {
+		txt[i] = "ab"[rnd.Intn(2)]
+	}
+	// many flushes at random positions
+	var parts [][]byte
+	for p := txt; len(p) > 0; {
+		n := 500 + rnd.Intn(2500)
+		if n > len(p) {
+			n = len(p)
+		}
+		parts = append(parts, p[:n])
+		p = p[n:]
+	}
+	tests := []WriterConfig{
+		{},
+		{Filters: []FilterConfig{{ID: FilterDelta, Dist: 4},
+			{ID: FilterLZMA2}}},
+		{Filters: []FilterConfig{{ID: FilterX86}, {ID: FilterLZMA2}}},
+		{Workers: 2},
+	}
+	// The presets use the binary tree match finders from level 4 on.
+	// The dictionary capacity is reduced to keep the test fast.
+	for level := lzma.MinPreset; level <= lzma.MaxPreset; level++ {
+		cfg, err := WriterConfigForPreset(level, false)
+		if err != nil {
+			t.Fatalf("WriterConfigForPreset(%d) error %s",
+				level, err)
+		}
+		cfg.DictCap = 1 << 16
+		tests = append(tests, cfg)
+	}
+	for _, cfg := range tests {
+		pr, pw := io.Pipe()
+		next := make(chan struct{}, len(parts))
+		errc := make(chan error, 1)
+		go func() {
+			err := func() error {
+				w, err := cfg.NewWriter(pw)
+				if err != nil {
+					return err
+				}
+				for i, p := range parts {
+					if _, err = w.Write(p); err != nil {
+						return err
+					}
+					if i%4 != 3 {
+						err = w.Flush()
+					} else {
+						err = w.FlushBlock()
+					}
+					if err != nil {
+						return err
+					}
+					<-next
+				}
+				return w.Close()
+			}()
+			// A writer error must not block the reader.
+			pw.CloseWithError(err)
+			errc <- err
+		}()
+		r, err := NewReader(pr)
+		if err != nil {
+			t.Fatalf("NewReader error %s", err)
+		}
+		// A BCJ filter on both sides may keep the bytes of an
+		// incomplete instruction.
+		slack := 0
+		for _, fc := range cfg.Filters {
+			if isBCJFilter(fc.ID) {
+				slack = 16
+			}
+		}
+		off, end := 0, 0
+		for i, p := range parts {
+			end += len(p)
+			q := make([]byte, end-slack-off)
+			if _, err = io.ReadFull(r, q); err != nil {
+				t.Fatalf("%+v: ReadFull error %s", cfg, err)
+			}
+			if !bytes.Equal(q, txt[off:end-slack]) {
+				t.Fatalf("%+v: part %d differs", cfg, i)
+			}
+			off = end - slack
+			next <- struct{}{}
+		}
+		q, err := ioutil.ReadAll(r)
+		if err != nil {
+			t.Fatalf("ReadAll error %s", err)
+		}
+		if !bytes.Equal(q, txt[off:]) {
+			t.Fatalf("%+v: tail differs", cfg)
+		}
+		if err = <-errc; err != nil {
+			t.Fatalf("%+v: writer error %s", cfg, err)
+		}
+	}
+}
@@ -0,0 +1,195 @@
+// Copyright 2014-2017 Ulrich Kunitz. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// Package zipxz registers the LZMA and XZ compression methods with the
+// archive/zip package. Importing the package for its side effect is
+// sufficient:
+//
+//	import _ "github.com/ulikunitz/xz/zipxz"
+//
+// The LZMA method follows the ZIP application note: the compressed data
+// starts with two version bytes and the two-byte size of the LZMA
+// properties followed by the properties and the raw LZMA stream. The
+// XZ method stores a complete xz stream.
+package zipxz
+
+import (
+	"archive/zip"
+	"encoding/binary"
+	"errors"
+	"io"
+
+	"github.com/ulikunitz/xz"
+	"github.com/ulikunitz/xz/lzma"
+)
+
+// Compression methods registered by this package.
+const (
+	MethodLZMA uint16 = 14
+	MethodXZ   uint16 = 95
+)
+
+// FlagEOS is the general purpose flag that indicates that an LZMA
+// stream is terminated by an end-of-stream marker. The LZMA compressor
+// always writes the marker, but archive/zip doesn't set the flag. It
+// can be set in the Flags field of zip.FileHeader. The decompressor
+// doesn't require the flag.
+const FlagEOS uint16 = 0x2
+
+func init() {
+	zip.RegisterCompressor(MethodLZMA, LZMACompressor)
+	zip.RegisterDecompressor(MethodLZMA, LZMADecompressor)
+	zip.RegisterCompressor(MethodXZ, XZCompressor)
+	zip.RegisterDecompressor(MethodXZ, XZDecompressor)
+}
+
+// lzmaVersion are the version bytes written into the LZMA header. They
+// identify the version of the LZMA SDK in the ZIP format.
+var lzmaVersion = [2]byte{9, 20}
+
+// propsLen is the length of the LZMA properties in the ZIP format.
+const propsLen = 5
+
+// lzmaHeaderLen is the length of the LZMA header in the ZIP format.
+const lzmaHeaderLen = 4 + propsLen
+
+// LZMACompressor creates a writer for the LZMA method. It uses the
+// default parameters of lzma.WriterConfig and terminates the stream
+// with an end-of-stream marker.
+func LZMACompressor(w io.Writer) (io.WriteCloser, error) {
+	c := lzma.WriterConfig{EOSMarker: true}
+	if err := c.Verify(); err != nil {
+		return nil, err
+	}
+	return &lazyWriter{newWriter: func() (io.WriteCloser, error) {
+		h := make([]byte, lzmaHeaderLen)
+		copy(h, lzmaVersion[:])
+		binary.LittleEndian.PutUint16(h[2:], propsLen)
+		h[4] = c.Properties.Code()
+		binary.LittleEndian.PutUint32(h[5:], uint32(c.DictCap))
+		if _, err := w.Write(h); err != nil {
+			return nil, err
+		}
+		return c.NewRawWriter(w)
+	}}, nil
+}
+
+// LZMADecompressor creates a reader for the LZMA method. The LZMA
+// stream may end with or without an end-of-stream marker. Errors in the
+// header are reported by the Read method.
+func LZMADecompressor(r io.Reader) io.ReadCloser {
+	return &lazyReader{newReader: func() (io.Reader, error) {
+		return newLZMAReader(r)
+	}}
+}
+
+// newLZMAReader reads the LZMA header and creates the reader for the
+// raw LZMA stream.
+func newLZMAReader(r io.Reader) (lr io.Reader, err error) {
+	h := make([]byte, 4)
+	if _, err = io.ReadFull(r, h); err != nil {
+		if err == io.EOF {
+			err = io.ErrUnexpectedEOF
+		}
+		return nil, err
+	}
+	n := int(binary.LittleEndian.Uint16(h[2:]))
+	if n < propsLen {
+		return nil, errors.New("zipxz: LZMA properties too short")
+	}
+	props := make([]byte, n)
+	if _, err = io.ReadFull(r, props); err != nil {
+		if err == io.EOF {
+			err = io.ErrUnexpectedEOF
+		}
+		return nil, err
+	}
+	p, err := lzma.PropertiesForCode(props[0])
+	if err != nil {
+		return nil, err
+	}
+	dictCap := int64(binary.LittleEndian.Uint32(props[1:]))
+	if dictCap < lzma.MinDictCap {
+		dictCap = lzma.MinDictCap
+	}
+	c := lzma.ReaderConfig{DictCap: int(dictCap), EOSOptional: true}
+	return c.NewRawReader(r, p, -1)
+}
+
+// XZCompressor creates a writer for the XZ method using the default
+// parameters of xz.WriterConfig.
+func XZCompressor(w io.Writer) (io.WriteCloser, error) {
+	return &lazyWriter{newWriter: func() (io.WriteCloser, error) {
+		return xz.NewWriter(w)
+	}}, nil
+}
+
+// XZDecompressor creates a reader for the XZ method. Errors in the
+// header of the xz stream are reported by the Read method.
+func XZDecompressor(r io.Reader) io.ReadCloser {
+	return &lazyReader{newReader: func() (io.Reader, error) {
+		return xz.NewReader(r)
+	}}
+}
+
+// lazyReader creates the actual reader on the first call of Read. The
+// decompressors of archive/zip cannot return errors, so the headers
+// must be read by Read.
+type lazyReader struct {
+	newReader func() (io.Reader, error)
+	r         io.Reader
+	err       error
+}
+
+// Read reads decompressed data.
+func (lr *lazyReader) Read(p []byte) (n int, err error) {
+	if lr.err != nil {
+		return 0, lr.err
+	}
+	if lr.r == nil {
+		if lr.r, err = lr.newReader(); err != nil {
+			lr.err = err
+			return 0, err
+		}
+	}
+	return lr.r.Read(p)
+}
+
+// Close closes the reader. Further calls of Read return an error.
+func (lr *lazyReader) Close() error {
+	lr.err = errors.New("zipxz: read after Close")
+	return nil
+}
+
+// lazyWriter creates the actual writer on the first call of Write or
+// Close. The archive/zip package creates the compressor before it
+// writes the local file header, so no data must be written before.
+type lazyWriter struct {
+	newWriter func() (io.WriteCloser, error)
+	w         io.WriteCloser
+}
+
+// init creates the writer if required.
+func (lw *lazyWriter) init() (err error) {
+	if lw.w == nil {
+		lw.w, err = lw.newWriter()
+	}
+	return err
+}
+
+// Write compresses the data in p.
+func (lw *lazyWriter) Write(p []byte) (n int, err error) {
+	if err = lw.init(); err != nil {
+		return 0, err
+	}
+	return lw.w.Write(p)
+}
+
+// Close finishes the compressed stream.
+func (lw *lazyWriter) Close() error {
+	if err := lw.init(); err != nil {
+		return err
+	}
+	return lw.w.Close()
+}
@@ -0,0 +1,119 @@
+// Copyright 2014-2017 Ulrich Kunitz. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package zipxz
+
+import (
+	"archive/zip"
+	"bytes"
+	"encoding/binary"
+	"io"
+	"io/ioutil"
+	"math/rand"
+	"testing"
+
+	"github.com/ulikunitz/xz/internal/randtxt"
+	"github.com/ulikunitz/xz/lzma"
+)
+
+// readZip reads all files of the zip archive and compares them with
+// txt.
+func readZip(t *testing.T, data []byte, txt []byte) {
+	zr, err := zip.NewReader(bytes.NewReader(data), int64(len(data)))
+	if err != nil {
+		t.Fatalf("zip.NewReader error %s", err)
+	}
+	for _, f := range zr.File {
+		r, err := f.Open()
+		if err != nil {
+			t.Fatalf("%s: Open error %s", f.Name, err)
+		}
+		out, err := ioutil.ReadAll(r)
+		if err != nil {
+			t.Fatalf("%s: ReadAll error %s", f.Name, err)
+		}
+		if err = r.Close(); err != nil {
+			t.Fatalf("%s: Close error %s", f.Name, err)
+		}
+		if !bytes.Equal(out, txt) {
+			t.Fatalf("%s: decompressed data differs", f.Name)
+		}
+	}
+}
+
+func TestZip(t *testing.T) {
+	const txtlen = 30000
+	var buf bytes.Buffer
+	io.CopyN(&buf, randtxt.NewReader(rand.NewSource(41)), txtlen)
+	txt := buf.Bytes()
+
+	var zbuf bytes.Buffer
+	zw := zip.NewWriter(&zbuf)
+	for _, fh := range []*zip.FileHeader{
+		{Name: "a.txt", Method: MethodLZMA, Flags: FlagEOS},
+		{Name: "b.txt", Method: MethodXZ},
+	} {
+		w, err := zw.CreateHeader(fh)
+		if err != nil {
+			t.Fatalf("CreateHeader error %s", err)
+		}
+		if _, err = w.Write(txt); err != nil {
+			t.Fatalf("Write error %s", err)
+		}
+	}
+	if err := zw.Close(); err != nil {
+		t.Fatalf("zw.Close error %s", err)
+	}
+	readZip(t, zbuf.Bytes(), txt)
+}
+
+// TestZipLZMANoEOS tests LZMA data without end-of-stream marker as
+// written by 7-Zip.
+func TestZipLZMANoEOS(t *testing.T) {
+	const txtlen = 30000
+	var buf bytes.Buffer
+	io.CopyN(&buf, randtxt.NewReader(rand.NewSource(42)), txtlen)
+	txt := buf.Bytes()
+
+	var zbuf bytes.Buffer
+	zw := zip.NewWriter(&zbuf)
+	zw.RegisterCompressor(MethodLZMA,
+		func(w io.Writer) (io.WriteCloser, error) {
+			c := lzma.WriterConfig{
+				Properties: &lzma.Properties{LC: 3, LP: 0, PB: 2},
+				DictCap:    1 << 16,
+				Size:       txtlen,
+			}
+			nw := func() (io.WriteCloser, error) {
+				h := []byte{16, 4, propsLen, 0,
+					c.Properties.Code(), 0, 0, 0, 0}
+				binary.LittleEndian.PutUint32(h[5:],
+					uint32(c.DictCap))
+				if _, err := w.Write(h); err != nil {
+					return nil, err
+				}
+				return c.NewRawWriter(w)
+			}
+			return &lazyWriter{newWriter: nw}, nil
+		})
+	w, err := zw.CreateHeader(&zip.FileHeader{
+		Name: "a.txt", Method: MethodLZMA})
+	if err != nil {
+		t.Fatalf("CreateHeader error %s", err)
+	}
+	if _, err = w.Write(txt); err != nil {
+		t.Fatalf("Write error %s", err)
+	}
+	if err = zw.Close(); err != nil {
+		t.Fatalf("zw.Close error %s", err)
+	}
+	readZip(t, zbuf.Bytes(), txt)
+}
+
+func TestLZMADecompressorError(t *testing.T) {
+	r := LZMADecompressor(bytes.NewReader([]byte{9, 20, 2, 0, 0x5d, 0}))
+	if _, err := ioutil.ReadAll(r); err == nil {
+		t.Fatalf("short properties: no error")
+	}
+}